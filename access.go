@@ -0,0 +1,258 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	// External modules
+	gin "github.com/gin-gonic/gin"
+	bcrypt "golang.org/x/crypto/bcrypt"
+)
+
+//
+//// READ ACCESS
+//
+
+// Read access to a repo or target, built from the 'access' section of its
+// configuration file.
+type accessPolicy struct {
+	private bool
+	// Users allowed to read the resource (any user in the htpasswd file if
+	// empty)
+	users []string
+	// Set if the configuration file could not be read, in which case nobody
+	// can read the resource
+	denied bool
+}
+
+func newAccessPolicy(settings AccessSettings, err error) accessPolicy {
+	if err != nil {
+		return accessPolicy{private: true, denied: true}
+	}
+
+	return accessPolicy{
+		private: settings.Private,
+		users:   settings.Users,
+	}
+}
+
+// Client of a read request, identified by the HTTP Basic credentials sent
+// with the request (if any).
+type accessClient struct {
+	user          string
+	authenticated bool
+	// Set if credentials were sent but are not valid
+	rejected bool
+}
+
+func (policy accessPolicy) allows(client accessClient) bool {
+	if policy.private == false {
+		return true
+	}
+	if policy.denied == true || client.authenticated == false {
+		return false
+	}
+	if len(policy.users) == 0 {
+		return true
+	}
+
+	for _, user := range policy.users {
+		if user == client.user {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+//// HTPASSWD FILE
+//
+
+// Users of private repos and targets, read from an htpasswd file with bcrypt
+// hashes (as created by 'htpasswd -B'). The file is reloaded when it changes.
+type htpasswdFile struct {
+	path    string
+	mutex   sync.Mutex
+	modTime time.Time
+	hashes  map[string]string
+	// Hashes (SHA-256) of the last password verified for each user, so that
+	// bcrypt only runs once per user instead of once per download
+	verified map[string][sha256.Size]byte
+}
+
+func newHtpasswdFile(path string) *htpasswdFile {
+	return &htpasswdFile{
+		path:     path,
+		hashes:   make(map[string]string),
+		verified: make(map[string][sha256.Size]byte),
+	}
+}
+
+// Returns the number of users in the file.
+func (file *htpasswdFile) count() (int, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	err := file.reload()
+
+	return len(file.hashes), err
+}
+
+// Reads the file again if it changed since it was last read. A missing file
+// means that there are no users. Must be called with the mutex held.
+func (file *htpasswdFile) reload() error {
+	info, err := os.Stat(file.path)
+	if os.IsNotExist(err) {
+		file.modTime = time.Time{}
+		file.hashes = make(map[string]string)
+		file.verified = make(map[string][sha256.Size]byte)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(file.modTime) {
+		return nil
+	}
+
+	contents, err := os.ReadFile(file.path)
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, found := strings.Cut(line, ":")
+		if found == false {
+			continue
+		}
+
+		// Only bcrypt hashes are supported
+		if strings.HasPrefix(hash, "$2a$") == false && strings.HasPrefix(hash, "$2b$") == false && strings.HasPrefix(hash, "$2y$") == false {
+			continue
+		}
+
+		hashes[user] = hash
+	}
+
+	file.modTime = info.ModTime()
+	file.hashes = hashes
+	file.verified = make(map[string][sha256.Size]byte)
+
+	return nil
+}
+
+func (file *htpasswdFile) authenticate(user string, password string) (bool, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	err := file.reload()
+	if err != nil {
+		return false, err
+	}
+
+	hash, exists := file.hashes[user]
+	if exists == false {
+		return false, nil
+	}
+
+	passwordHash := sha256.Sum256([]byte(password))
+	if verified, found := file.verified[user]; found == true && verified == passwordHash {
+		return true, nil
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, nil
+	}
+
+	file.verified[user] = passwordHash
+
+	return true, nil
+}
+
+//
+//// READ ACCESS (SERVER FUNCTIONALITY)
+//
+
+// Returns the client of the request, verifying its credentials once per
+// request.
+func getAccessClient(c *gin.Context, users *htpasswdFile, program Program) accessClient {
+	if value, exists := c.Get("accessClient"); exists == true {
+		return value.(accessClient)
+	}
+
+	var client accessClient
+
+	user, password, found := c.Request.BasicAuth()
+	if found == true {
+		authenticated, err := users.authenticate(user, password)
+		if err != nil {
+			showError(fmt.Sprintf("=> Failed to read htpasswd file -> %v", err), program.indentLevel)
+		}
+
+		client = accessClient{
+			user:          user,
+			authenticated: authenticated,
+			rejected:      authenticated == false,
+		}
+	}
+
+	c.Set("accessClient", client)
+
+	return client
+}
+
+// Verifies that the client can read the repo and, if targetName is not empty,
+// the target: its address must be allowed to download from them and, if they
+// are private, it must be an allowed user. Responds with 401 (asking for
+// credentials) or 403 and returns false otherwise.
+func authorizeRead(c *gin.Context, index *serverIndex, users *htpasswdFile, repoName string, targetName string, program Program) bool {
+	if authorizeAddress(c, index, repoName, targetName, false, program) == false {
+		return false
+	}
+
+	client := getAccessClient(c, users, program)
+
+	if index.canRead(client, repoName, targetName) == true {
+		return true
+	}
+
+	if client.authenticated == true {
+		logSecurityEvent(c, fmt.Sprintf("user '%s' is not allowed to read the resource", client.user), program)
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"message": "Forbidden: you are not allowed to access this resource",
+		})
+		return false
+	}
+
+	if client.rejected == true {
+		logSecurityEvent(c, fmt.Sprintf("invalid credentials for user '%s'", client.user), program)
+	}
+
+	c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=\"%s\"", program.name))
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"message": "Unauthorized: authentication is required to access this resource",
+	})
+
+	return false
+}
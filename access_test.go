@@ -0,0 +1,236 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	// External modules
+	gin "github.com/gin-gonic/gin"
+	bcrypt "golang.org/x/crypto/bcrypt"
+)
+
+//
+//// HTPASSWD FILE
+//
+
+// Writes an htpasswd file with a bcrypt hash of each password (by user), with
+// the given modification time.
+func writeHtpasswdFile(t *testing.T, path string, passwords map[string]string, modTime time.Time) {
+	t.Helper()
+
+	var contents []byte
+	for user, password := range passwords {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contents = append(contents, []byte(user+":"+string(hash)+"\n")...)
+	}
+
+	if err := os.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHtpasswdFileAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	modTime := time.Now().Add(-time.Hour)
+
+	writeHtpasswdFile(t, path, map[string]string{"alice": "secret", "bob": "hunter2"}, modTime)
+
+	users := newHtpasswdFile(path)
+
+	tests := []struct {
+		user     string
+		password string
+		expected bool
+	}{
+		{user: "alice", password: "secret", expected: true},
+		{user: "bob", password: "hunter2", expected: true},
+		{user: "alice", password: "hunter2", expected: false},
+		{user: "alice", password: "", expected: false},
+		{user: "carol", password: "secret", expected: false},
+	}
+
+	for _, test := range tests {
+		authenticated, err := users.authenticate(test.user, test.password)
+		if err != nil || authenticated != test.expected {
+			t.Errorf("authenticate(%q, %q) = %v, %v, expected %v", test.user, test.password, authenticated, err, test.expected)
+		}
+	}
+
+	// Verified passwords are cached, so bcrypt does not run again (here, it
+	// would fail)
+	users.mutex.Lock()
+	users.hashes["alice"] = "$2y$10$invalid"
+	users.mutex.Unlock()
+
+	if authenticated, err := users.authenticate("alice", "secret"); err != nil || authenticated == false {
+		t.Errorf("authenticate(\"alice\", \"secret\") = %v, %v, expected the cached password to be accepted", authenticated, err)
+	}
+	if authenticated, _ := users.authenticate("alice", "hunter2"); authenticated == true {
+		t.Errorf("authenticate(\"alice\", \"hunter2\") = true, expected a password other than the cached one to be verified")
+	}
+}
+
+func TestHtpasswdFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	modTime := time.Now().Add(-time.Hour)
+
+	users := newHtpasswdFile(path)
+
+	// A missing file has no users
+	if count, err := users.count(); err != nil || count != 0 {
+		t.Errorf("count() = %d, %v, expected 0", count, err)
+	}
+
+	writeHtpasswdFile(t, path, map[string]string{"alice": "secret"}, modTime)
+
+	if authenticated, err := users.authenticate("alice", "secret"); err != nil || authenticated == false {
+		t.Fatalf("authenticate(\"alice\", \"secret\") = %v, %v, expected true", authenticated, err)
+	}
+
+	// The password of alice changes and bob is added
+	writeHtpasswdFile(t, path, map[string]string{"alice": "changed", "bob": "hunter2"}, modTime.Add(time.Minute))
+
+	tests := []struct {
+		user     string
+		password string
+		expected bool
+	}{
+		// The cached password is no longer accepted
+		{user: "alice", password: "secret", expected: false},
+		{user: "alice", password: "changed", expected: true},
+		{user: "bob", password: "hunter2", expected: true},
+	}
+
+	for _, test := range tests {
+		authenticated, err := users.authenticate(test.user, test.password)
+		if err != nil || authenticated != test.expected {
+			t.Errorf("authenticate(%q, %q) = %v, %v, expected %v after the file changed", test.user, test.password, authenticated, err, test.expected)
+		}
+	}
+
+	if count, err := users.count(); err != nil || count != 2 {
+		t.Errorf("count() = %d, %v, expected 2", count, err)
+	}
+
+	// Removing the file removes all users
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if authenticated, _ := users.authenticate("bob", "hunter2"); authenticated == true {
+		t.Errorf("authenticate(\"bob\", \"hunter2\") = true, expected false once the file is removed")
+	}
+	if count, err := users.count(); err != nil || count != 0 {
+		t.Errorf("count() = %d, %v, expected 0 once the file is removed", count, err)
+	}
+}
+
+//
+//// READ ACCESS
+//
+
+func TestAuthorizeRead(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dataDir := t.TempDir()
+	program := Program{
+		name:         "pacpilot",
+		dataDir:      dataDir,
+		reposDir:     dataDir + "/repos",
+		htpasswdPath: dataDir + "/htpasswd",
+	}
+
+	// Targets 'public', 'private' (any user) and 'restricted' (bob only)
+	configs := map[string]string{
+		"public":     "",
+		"private":    "[access]\nprivate = true\n",
+		"restricted": "[access]\nprivate = true\nusers = [\"bob\"]\n",
+	}
+	for name, config := range configs {
+		targetDir := filepath.Join(program.reposDir, "repo", "targets", name)
+		if err := os.MkdirAll(filepath.Join(targetDir, "pool"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(targetDir, "config.toml"), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeHtpasswdFile(t, program.htpasswdPath, map[string]string{"alice": "secret", "bob": "hunter2"}, time.Now().Add(-time.Hour))
+	users := newHtpasswdFile(program.htpasswdPath)
+
+	index, err := newServerIndex(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.watcher.Close()
+
+	router := gin.New()
+	router.GET("/repos/:repo/:target", func(c *gin.Context) {
+		if authorizeRead(c, index, users, c.Param("repo"), c.Param("target"), program) == true {
+			c.Status(http.StatusOK)
+		}
+	})
+
+	tests := []struct {
+		target   string
+		user     string
+		password string
+		status   int
+		// Whether the target is listed in the index of the repo
+		listed bool
+	}{
+		{target: "public", status: http.StatusOK, listed: true},
+		{target: "public", user: "alice", password: "wrong", status: http.StatusOK, listed: true},
+		{target: "private", status: http.StatusUnauthorized, listed: false},
+		{target: "private", user: "alice", password: "wrong", status: http.StatusUnauthorized, listed: false},
+		{target: "private", user: "alice", password: "secret", status: http.StatusOK, listed: true},
+		{target: "restricted", status: http.StatusUnauthorized, listed: false},
+		{target: "restricted", user: "alice", password: "secret", status: http.StatusForbidden, listed: false},
+		{target: "restricted", user: "bob", password: "hunter2", status: http.StatusOK, listed: true},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/repos/repo/"+test.target, nil)
+		if test.user != "" {
+			request.SetBasicAuth(test.user, test.password)
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf("GET %s as %q = %d, expected %d", request.URL.Path, test.user, recorder.Code, test.status)
+		}
+
+		authenticate := recorder.Header().Get("WWW-Authenticate")
+		if test.status == http.StatusUnauthorized && authenticate != "Basic realm=\"pacpilot\"" {
+			t.Errorf("GET %s as %q: WWW-Authenticate = %q, expected a Basic challenge", request.URL.Path, test.user, authenticate)
+		} else if test.status != http.StatusUnauthorized && authenticate != "" {
+			t.Errorf("GET %s as %q: WWW-Authenticate = %q, expected none", request.URL.Path, test.user, authenticate)
+		}
+
+		// The index of the repo only lists the targets that the client can read
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = request
+		if listed := index.canRead(getAccessClient(c, users, program), "repo", test.target); listed != test.listed {
+			t.Errorf("canRead(%q, %q) = %v, expected %v", test.user, test.target, listed, test.listed)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	// External modules
+)
+
+//
+//// CHECKSUM CACHE
+//
+
+type fileChecksums struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Inode   uint64 `json:"inode"`
+	MD5     string `json:"md5"`
+	SHA256  string `json:"sha256"`
+	// The file could not be hashed (it is not retried until it changes)
+	Failed bool `json:"failed,omitempty"`
+}
+
+// Persistent cache of file checksums. Entries are keyed by path and are only
+// valid while the size, modification time and inode of the file are the same.
+// Missing or outdated checksums are calculated in the background.
+type checksumCache struct {
+	path    string
+	mutex   sync.Mutex
+	entries map[string]fileChecksums
+	pending map[string]bool
+	queue   chan string
+}
+
+func getFileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino
+	}
+
+	return 0
+}
+
+func (entry fileChecksums) matches(info os.FileInfo) bool {
+	return entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() && entry.Inode == getFileInode(info)
+}
+
+// Loads the cache from the given path (entries of files that no longer exist
+// are dropped) and starts the background worker.
+func newChecksumCache(path string) (*checksumCache, error) {
+	cache := &checksumCache{
+		path:    path,
+		entries: make(map[string]fileChecksums),
+		pending: make(map[string]bool),
+		queue:   make(chan string, 4096),
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil && os.IsNotExist(err) == false {
+		return nil, err
+	}
+
+	if err == nil {
+		err = json.Unmarshal(contents, &cache.entries)
+		if err != nil {
+			// A corrupted cache is simply rebuilt
+			cache.entries = make(map[string]fileChecksums)
+		}
+	}
+
+	for filePath := range cache.entries {
+		if _, err := os.Stat(filePath); err != nil {
+			delete(cache.entries, filePath)
+		}
+	}
+
+	go cache.worker()
+
+	return cache, nil
+}
+
+// Returns the cached checksums of the file if they are up to date. Otherwise,
+// the file is queued to be hashed in the background and false is returned.
+func (cache *checksumCache) lookup(filePath string, info os.FileInfo) (fileChecksums, bool) {
+	filePath = filepath.Clean(filePath)
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry, found := cache.entries[filePath]
+	if found == true && entry.matches(info) {
+		return entry, true
+	}
+
+	cache.enqueueLocked(filePath)
+
+	return fileChecksums{}, false
+}
+
+func (cache *checksumCache) enqueueLocked(filePath string) {
+	if cache.pending[filePath] == true {
+		return
+	}
+
+	// If the queue is full, the file is queued again on the next lookup
+	select {
+	case cache.queue <- filePath:
+		cache.pending[filePath] = true
+	default:
+	}
+}
+
+// Queues all files in the directory (recursively) that are not cached yet.
+func (cache *checksumCache) warm(dir string) {
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() == true {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.Mode().IsRegular() == false {
+			return nil
+		}
+
+		cache.lookup(path, info)
+
+		return nil
+	})
+}
+
+func (cache *checksumCache) worker() {
+	for filePath := range cache.queue {
+		cache.hash(filePath)
+
+		// Save once the queue is drained to avoid rewriting the cache file
+		// after every single file
+		if len(cache.queue) == 0 {
+			cache.save()
+		}
+	}
+}
+
+func (cache *checksumCache) hash(filePath string) {
+	defer func() {
+		cache.mutex.Lock()
+		delete(cache.pending, filePath)
+		cache.mutex.Unlock()
+	}()
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		cache.mutex.Lock()
+		delete(cache.entries, filePath)
+		cache.mutex.Unlock()
+		return
+	}
+
+	entry := fileChecksums{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Inode:   getFileInode(info),
+	}
+
+	entry.MD5, err = calculateMD5(filePath)
+	if err == nil {
+		entry.SHA256, err = calculateSHA256(filePath)
+	}
+	if err != nil {
+		entry = fileChecksums{Size: entry.Size, ModTime: entry.ModTime, Inode: entry.Inode, Failed: true}
+	}
+
+	// Discard the checksums if the file changed while it was being hashed
+	newInfo, err := os.Stat(filePath)
+	if err != nil || entry.matches(newInfo) == false {
+		return
+	}
+
+	cache.mutex.Lock()
+	cache.entries[filePath] = entry
+	cache.mutex.Unlock()
+}
+
+func (cache *checksumCache) save() error {
+	cache.mutex.Lock()
+	contents, err := json.Marshal(cache.entries)
+	cache.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(cache.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that the cache is never left
+	// partially written
+	tempPath := cache.path + ".new"
+	err = os.WriteFile(tempPath, contents, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, cache.path)
+}
@@ -0,0 +1,117 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//
+//// CHECKSUM CACHE
+//
+
+func newTestChecksumCache(t *testing.T) *checksumCache {
+	// The worker isn't started, files are hashed by calling hash() directly
+	return &checksumCache{
+		path:    filepath.Join(t.TempDir(), "checksums.json"),
+		entries: make(map[string]fileChecksums),
+		pending: make(map[string]bool),
+		queue:   make(chan string, 16),
+	}
+}
+
+func TestChecksumCacheHash(t *testing.T) {
+	cache := newTestChecksumCache(t)
+
+	filePath := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(filePath, []byte("abc"), 0644)
+	info, _ := os.Stat(filePath)
+
+	if _, found := cache.lookup(filePath, info); found == true {
+		t.Fatal("lookup() found checksums before the file was hashed")
+	}
+	if len(cache.queue) != 1 {
+		t.Errorf("lookup() queued %d files, expected 1", len(cache.queue))
+	}
+
+	cache.hash(<-cache.queue)
+
+	entry, found := cache.lookup(filePath, info)
+	if found == false || entry.Failed == true {
+		t.Fatalf("lookup() = %+v, %v, expected the checksums of the file", entry, found)
+	}
+	if entry.MD5 != "900150983cd24fb0d6963f7d28e17f72" {
+		t.Errorf("MD5 = %s, expected 900150983cd24fb0d6963f7d28e17f72", entry.MD5)
+	}
+	if entry.SHA256 != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("SHA256 = %s, expected ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", entry.SHA256)
+	}
+
+	// The entry is outdated once the file changes
+	os.WriteFile(filePath, []byte("abcd"), 0644)
+	info, _ = os.Stat(filePath)
+	if _, found := cache.lookup(filePath, info); found == true {
+		t.Error("lookup() returned outdated checksums after the file changed")
+	}
+}
+
+func TestChecksumCacheFailure(t *testing.T) {
+	cache := newTestChecksumCache(t)
+
+	// Reading a directory fails, like an unreadable file
+	dirPath := t.TempDir()
+	info, _ := os.Stat(dirPath)
+
+	cache.lookup(dirPath, info)
+	cache.hash(<-cache.queue)
+
+	entry, found := cache.lookup(dirPath, info)
+	if found == false || entry.Failed == false {
+		t.Fatalf("lookup() = %+v, %v, expected a failed entry", entry, found)
+	}
+	if len(cache.queue) != 0 {
+		t.Error("lookup() should not queue a failed file again until it changes")
+	}
+
+	// The failure is forgotten once the file changes
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(dirPath, later, later)
+	info, _ = os.Stat(dirPath)
+	if _, found := cache.lookup(dirPath, info); found == true || len(cache.queue) != 1 {
+		t.Error("lookup() should queue a failed file again after it changes")
+	}
+}
+
+func TestChecksumCacheSaveAndLoad(t *testing.T) {
+	cache := newTestChecksumCache(t)
+
+	filePath := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(filePath, []byte("abc"), 0644)
+	info, _ := os.Stat(filePath)
+
+	cache.lookup(filePath, info)
+	cache.hash(<-cache.queue)
+	cache.entries[filepath.Join(t.TempDir(), "removed")] = fileChecksums{MD5: "x"}
+
+	if err := cache.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	loaded, err := newChecksumCache(cache.path)
+	if err != nil {
+		t.Fatalf("newChecksumCache() error = %v", err)
+	}
+
+	loaded.mutex.Lock()
+	defer loaded.mutex.Unlock()
+
+	if len(loaded.entries) != 1 || loaded.entries[filePath] != cache.entries[filePath] {
+		t.Errorf("newChecksumCache() loaded %+v, expected only the entry of '%s'", loaded.entries, filePath)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	// External modules
+)
+
+//
+//// CLIENT CONFIGURATION (pacman.conf)
+//
+
+type clientConfig struct {
+	pacmanConf string
+	mirrorlist string
+}
+
+// Returns the pacman SigLevel for a target. Explicit repo signing settings take
+// precedence; otherwise, the target's pool directory is inspected for signatures.
+func getTargetSigLevel(repo Repo, target Target, settings RepoSettings) string {
+	signedPackages := false
+	signedDatabase := false
+
+	if settings.Signing.Packages != nil {
+		signedPackages = *settings.Signing.Packages
+	} else {
+		matches, _ := filepath.Glob(filepath.Join(target.PoolDir, "*.pkg.tar.*.sig"))
+		signedPackages = len(matches) > 0
+	}
+
+	if settings.Signing.Database != nil {
+		signedDatabase = *settings.Signing.Database
+	} else {
+		_, err := os.Stat(filepath.Join(target.PoolDir, repo.Name+".db.sig"))
+		signedDatabase = err == nil
+	}
+
+	switch {
+	case signedPackages && signedDatabase:
+		return "Required"
+	case signedPackages:
+		return "PackageRequired DatabaseOptional"
+	case signedDatabase:
+		return "PackageOptional DatabaseRequired"
+	default:
+		return "Optional TrustAll"
+	}
+}
+
+// Returns the Server URL for a target given the base URL of a pacpilot server.
+// Targets with an architecture use the layout of the official mirrors.
+func getTargetServerURL(baseURL string, repo Repo, target Target, targetSettings TargetSettings) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	if targetArchitecture(target, targetSettings) != "" {
+		return baseURL + "/$repo/os/$arch"
+	}
+
+	return baseURL + "/repos/" + repo.Name + "/" + target.Name + "/tree"
+}
+
+func generateClientConfig(repo Repo, target Target, baseURLs []string) (clientConfig, error) {
+	repoSettings, err := loadRepoSettings(repo)
+	if err != nil {
+		return clientConfig{}, err
+	}
+
+	targetSettings, err := loadTargetSettings(target)
+	if err != nil {
+		return clientConfig{}, err
+	}
+
+	// Configured public URLs take precedence over the given base URLs
+	if len(repoSettings.PublicURLs) > 0 {
+		baseURLs = repoSettings.PublicURLs
+	}
+
+	if len(baseURLs) == 0 {
+		return clientConfig{}, fmt.Errorf("no server URL available: set 'public_urls' in the repo configuration file or pass '--url/-u'")
+	}
+
+	var config clientConfig
+	var pacmanConf strings.Builder
+
+	pacmanConf.WriteString(fmt.Sprintf("[%s]\n", repo.Name))
+	pacmanConf.WriteString(fmt.Sprintf("SigLevel = %s\n", getTargetSigLevel(repo, target, repoSettings)))
+
+	if len(baseURLs) > 1 {
+		var mirrorlist strings.Builder
+
+		mirrorlist.WriteString(fmt.Sprintf("## Mirrorlist for the '%s' repo (%s)\n", repo.Name, target.Name))
+		for _, baseURL := range baseURLs {
+			mirrorlist.WriteString(fmt.Sprintf("Server = %s\n", getTargetServerURL(baseURL, repo, target, targetSettings)))
+		}
+
+		config.mirrorlist = mirrorlist.String()
+
+		pacmanConf.WriteString(fmt.Sprintf("Include = /etc/pacman.d/%s-mirrorlist\n", repo.Name))
+	} else {
+		pacmanConf.WriteString(fmt.Sprintf("Server = %s\n", getTargetServerURL(baseURLs[0], repo, target, targetSettings)))
+	}
+
+	config.pacmanConf = pacmanConf.String()
+
+	return config, nil
+}
+
+func reposClientConfig(repo Repo, targets []Target, baseURL string, printMirrorlist bool, program Program) functionResponse {
+	if len(targets) != 1 {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Exactly one target should be specified",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	var baseURLs []string
+	if baseURL != "" {
+		baseURLs = []string{baseURL}
+	}
+
+	config, err := generateClientConfig(repo, targets[0], baseURLs)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Failed to generate client configuration -> " + err.Error(),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	if printMirrorlist == true {
+		if config.mirrorlist == "" {
+			return functionResponse{
+				exitCode:    1,
+				message:     "A mirrorlist is only generated when several public URLs are configured",
+				logLevel:    "attention",
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		fmt.Print(config.mirrorlist)
+	} else {
+		fmt.Print(config.pacmanConf)
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
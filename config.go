@@ -11,8 +11,10 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+
 	// External modules
 	//color "github.com/gookit/color"
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
 )
 
 //
@@ -32,7 +34,14 @@ type Program struct {
 	templatesDir        string
 	targetsTemplatesDir string
 	reposTemplatesDir   string
-	indentLevel         int
+	cacheDir            string
+	jobsDir             string
+	tokensPath          string
+	htpasswdPath        string
+	clientsPath         string
+	// Limits of the hooks run by the command (overriding the hook manifests)
+	hookLimits  pacpilot.HookLimits
+	indentLevel int
 }
 
 func getDefaultShellAbsolutePath(shellName string) string {
@@ -82,6 +91,13 @@ func initializeDefaultProgram(dataDir string) Program {
 	templatesDir := dataDir + "/templates"
 	targetsTemplatesDir := dataDir + "/templates" + "/targets"
 	reposTemplatesDir := dataDir + "/templates" + "/repos"
+	cacheDir := dataDir + "/cache"
+	jobsDir := dataDir + "/jobs"
+
+	// FILES
+	tokensPath := dataDir + "/tokens.json"
+	htpasswdPath := dataDir + "/htpasswd"
+	clientsPath := dataDir + "/clients.toml"
 
 	// INDENT LEVEL
 	indentLevel := 0
@@ -99,10 +115,31 @@ func initializeDefaultProgram(dataDir string) Program {
 		templatesDir:        templatesDir,
 		targetsTemplatesDir: targetsTemplatesDir,
 		reposTemplatesDir:   reposTemplatesDir,
+		cacheDir:            cacheDir,
+		jobsDir:             jobsDir,
+		tokensPath:          tokensPath,
+		htpasswdPath:        htpasswdPath,
+		clientsPath:         clientsPath,
 		indentLevel:         indentLevel,
 	}
 }
 
+// Returns the configuration used by the pacpilot package to generate repos
+// and targets.
+func getLibraryConfig(program Program) pacpilot.Config {
+	return pacpilot.Config{
+		ProgramName:         program.name,
+		ProgramExec:         program.exec,
+		DefaultShell:        program.defaultShell,
+		HookLimitsShim:      hookLimitsShimPath,
+		DataDir:             program.dataDir,
+		ReposDir:            program.reposDir,
+		TemplatesDir:        program.templatesDir,
+		ReposTemplatesDir:   program.reposTemplatesDir,
+		TargetsTemplatesDir: program.targetsTemplatesDir,
+	}
+}
+
 func getRootDirectory() string {
 	// Check if the "PREFIX" environment variable is set
 	prefix := os.Getenv("PREFIX")
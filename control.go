@@ -8,6 +8,7 @@ import (
 	// Modules in GOROOT
 	"fmt"
 	"os"
+	"strings"
 	// External modules
 )
 
@@ -16,6 +17,7 @@ import (
 //
 
 func finishProgram(code int) {
+	releaseHeldLocks()
 	os.Exit(code)
 }
 
@@ -65,3 +67,14 @@ func handleFunctionResponse(response functionResponse, finishProgramAfter bool)
 		}
 	}
 }
+
+// Returns the message of an error returned by the pacpilot package, starting
+// with an uppercase letter like the other messages of the program.
+func formatErrorMessage(err error) string {
+	message := err.Error()
+	if message == "" {
+		return message
+	}
+
+	return strings.ToUpper(message[:1]) + message[1:]
+}
@@ -0,0 +1,306 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	// External modules
+)
+
+//
+//// PACKAGE EVENTS
+//
+
+const maxTargetEvents = 500
+
+// Serializes updates of the events files (hooks and feed requests may record
+// events at the same time)
+var targetEventsMutex sync.Mutex
+
+const (
+	packageEventAdd       = "add"
+	packageEventRemove    = "remove"
+	packageEventUpgrade   = "upgrade"
+	packageEventDowngrade = "downgrade"
+)
+
+type packageEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Repo       string    `json:"repo"`
+	Target     string    `json:"target"`
+	Package    string    `json:"package"`
+	OldVersion string    `json:"oldVersion,omitempty"`
+	NewVersion string    `json:"newVersion,omitempty"`
+	Packager   string    `json:"packager,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+type packageSnapshot struct {
+	Version  string `json:"version"`
+	Packager string `json:"packager"`
+}
+
+// The events file stores the last known state of the target's database and
+// the most recent events (newest last)
+type targetEvents struct {
+	Snapshot map[string]packageSnapshot `json:"snapshot"`
+	Events   []packageEvent             `json:"events"`
+}
+
+func loadTargetEvents(target Target) (targetEvents, bool, error) {
+	var events targetEvents
+
+	contents, err := os.ReadFile(target.EventsPath)
+	if os.IsNotExist(err) {
+		return events, false, nil
+	} else if err != nil {
+		return events, false, err
+	}
+
+	err = json.Unmarshal(contents, &events)
+	if err != nil {
+		return events, false, fmt.Errorf("failed to parse events file '%s' -> %v", target.EventsPath, err)
+	}
+
+	return events, true, nil
+}
+
+func saveTargetEvents(target Target, events targetEvents) error {
+	contents, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that readers never see a partial file
+	tempPath := target.EventsPath + ".new"
+	err = os.WriteFile(tempPath, contents, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, target.EventsPath)
+}
+
+// Compares the target's database with the last recorded snapshot and records
+// an event for each added, removed, upgraded or downgraded package.
+func recordTargetDatabaseEvents(target Target) ([]packageEvent, error) {
+	targetEventsMutex.Lock()
+	defer targetEventsMutex.Unlock()
+
+	packages, err := readRepoDatabase(getTargetDatabasePath(target))
+	if err != nil {
+		return nil, err
+	}
+
+	events, found, err := loadTargetEvents(target)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]packageSnapshot)
+	for name, pkg := range packages {
+		snapshot[name] = packageSnapshot{
+			Version:  pkg.version,
+			Packager: pkg.field("PACKAGER"),
+		}
+	}
+
+	// The first snapshot of an existing database is used as a baseline
+	if found == false {
+		events.Snapshot = snapshot
+		return nil, saveTargetEvents(target, events)
+	}
+
+	now := time.Now().UTC()
+	var newEvents []packageEvent
+
+	addEvent := func(eventType string, name string, oldVersion string, newVersion string, packager string) {
+		newEvents = append(newEvents, packageEvent{
+			ID:         strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.Itoa(len(newEvents)),
+			Type:       eventType,
+			Repo:       target.Repo.Name,
+			Target:     target.Name,
+			Package:    name,
+			OldVersion: oldVersion,
+			NewVersion: newVersion,
+			Packager:   packager,
+			Time:       now,
+		})
+	}
+
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		current := snapshot[name]
+		previous, existed := events.Snapshot[name]
+
+		switch {
+		case existed == false:
+			addEvent(packageEventAdd, name, "", current.Version, current.Packager)
+		case vercmp(current.Version, previous.Version) > 0:
+			addEvent(packageEventUpgrade, name, previous.Version, current.Version, current.Packager)
+		case vercmp(current.Version, previous.Version) < 0:
+			addEvent(packageEventDowngrade, name, previous.Version, current.Version, current.Packager)
+		}
+	}
+
+	var removedNames []string
+	for name := range events.Snapshot {
+		if _, exists := snapshot[name]; exists == false {
+			removedNames = append(removedNames, name)
+		}
+	}
+	sort.Strings(removedNames)
+
+	for _, name := range removedNames {
+		previous := events.Snapshot[name]
+		addEvent(packageEventRemove, name, previous.Version, "", previous.Packager)
+	}
+
+	if len(newEvents) == 0 {
+		return nil, nil
+	}
+
+	events.Snapshot = snapshot
+	events.Events = append(events.Events, newEvents...)
+	if len(events.Events) > maxTargetEvents {
+		events.Events = events.Events[len(events.Events)-maxTargetEvents:]
+	}
+
+	return newEvents, saveTargetEvents(target, events)
+}
+
+// Records database events for the targets after running hooks. Failures are
+// only reported, since they should not change the outcome of the hooks.
+func recordTargetsDatabaseEvents(targets []Target, program Program) {
+	for _, target := range targets {
+		events, err := recordTargetDatabaseEvents(target)
+		if err != nil {
+			showAttention(displayTargetTag("> Failed to record package events", target)+" -> "+err.Error(), program.indentLevel)
+			continue
+		}
+
+		if len(events) > 0 {
+			showInfo(displayTargetTag(fmt.Sprintf("> Recorded %d package event(s)", len(events)), target), program.indentLevel)
+		}
+	}
+}
+
+//
+//// ATOM FEEDS
+//
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID       string        `xml:"id"`
+	Title    string        `xml:"title"`
+	Updated  string        `xml:"updated"`
+	Author   *atomAuthor   `xml:"author,omitempty"`
+	Category *atomCategory `xml:"category,omitempty"`
+	Link     *atomLink     `xml:"link,omitempty"`
+	Summary  string        `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func describePackageEvent(event packageEvent) (string, string) {
+	switch event.Type {
+	case packageEventAdd:
+		return fmt.Sprintf("%s %s added", event.Package, event.NewVersion),
+			fmt.Sprintf("Package %s %s was added to %s/%s.", event.Package, event.NewVersion, event.Repo, event.Target)
+	case packageEventRemove:
+		return fmt.Sprintf("%s %s removed", event.Package, event.OldVersion),
+			fmt.Sprintf("Package %s %s was removed from %s/%s.", event.Package, event.OldVersion, event.Repo, event.Target)
+	default:
+		return fmt.Sprintf("%s %s -> %s", event.Package, event.OldVersion, event.NewVersion),
+			fmt.Sprintf("Package %s was %sd from %s to %s in %s/%s.", event.Package, event.Type, event.OldVersion, event.NewVersion, event.Repo, event.Target)
+	}
+}
+
+// Generates an Atom feed from the given events (newest first). Links are
+// relative to baseURL (the public URL of the server).
+func generateAtomFeed(title string, feedPath string, pagePath string, baseURL string, events []packageEvent) ([]byte, error) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.After(events[j].Time)
+	})
+
+	updated := time.Unix(0, 0).UTC()
+	if len(events) > 0 {
+		updated = events[0].Time
+	}
+
+	feed := atomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		ID:      baseURL + feedPath,
+		Title:   title,
+		Updated: updated.Format(time.RFC3339),
+		Author:  atomAuthor{Name: "pacpilot"},
+		Links: []atomLink{
+			{Href: baseURL + feedPath, Rel: "self"},
+			{Href: baseURL + pagePath},
+		},
+	}
+
+	for _, event := range events {
+		entryTitle, summary := describePackageEvent(event)
+
+		entry := atomEntry{
+			ID:       fmt.Sprintf("%s%s#%s/%s", baseURL, feedPath, event.Target, event.ID),
+			Title:    entryTitle,
+			Updated:  event.Time.Format(time.RFC3339),
+			Category: &atomCategory{Term: event.Type},
+			Link:     &atomLink{Href: fmt.Sprintf("%s/repos/%s/%s/tree/", baseURL, event.Repo, event.Target)},
+			Summary:  summary,
+		}
+
+		if event.Packager != "" {
+			entry.Author = &atomAuthor{Name: event.Packager}
+			entry.Summary += " Packager: " + event.Packager
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	output, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), output...), nil
+}
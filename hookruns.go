@@ -0,0 +1,497 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	// External modules
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
+	color "github.com/gookit/color"
+)
+
+//
+//// HOOK RUN SUMMARIES
+//
+
+type hookSummaryRow struct {
+	target string
+	hook   string
+	run    hookRun
+}
+
+// Results of the hooks run on several targets, shown at the end of the run
+// when it continues after failures or runs targets in parallel.
+type hookSummary struct {
+	rows []hookSummaryRow
+}
+
+func (summary *hookSummary) add(target string, hook string, run hookRun) {
+	summary.rows = append(summary.rows, hookSummaryRow{target: target, hook: hook, run: run})
+}
+
+// Adds the hooks that were not run (after a failure) as skipped.
+func (summary *hookSummary) skip(target string, hooks []string) {
+	for _, hook := range hooks {
+		summary.add(target, hook, hookRun{status: hookSkipped})
+	}
+}
+
+// Returns the number of hooks that failed (or could not run).
+func (summary *hookSummary) failures() int {
+	failures := 0
+	for _, row := range summary.rows {
+		if row.run.status != hookSucceeded && row.run.status != hookSkipped {
+			failures++
+		}
+	}
+
+	return failures
+}
+
+func showHookSummary(summary *hookSummary, program Program) {
+	showInfoSectionTitle("Summary", program.indentLevel)
+
+	header := []string{"TARGET", "HOOK", "STATUS", "DURATION", "EXIT CODE"}
+	lines := [][]string{header}
+
+	for _, row := range summary.rows {
+		duration := "-"
+		if row.run.status != hookSkipped && row.run.status != hookNotFound && row.run.status != hookLocked {
+			duration = row.run.duration.Round(100 * time.Millisecond).String()
+		}
+
+		exitCode := "-"
+		if row.run.exited == true {
+			exitCode = strconv.Itoa(row.run.ExitCode)
+		}
+
+		lines = append(lines, []string{row.target, row.hook, row.run.status, duration, exitCode})
+	}
+
+	widths := make([]int, len(header))
+	for _, line := range lines {
+		for column, value := range line {
+			widths[column] = max(widths[column], len(value))
+		}
+	}
+
+	for index, line := range lines {
+		var columns []string
+		for column, value := range line {
+			// Pad before coloring, as escape sequences have no width
+			padded := fmt.Sprintf("%-*s", widths[column], value)
+
+			switch {
+			case index == 0:
+				padded = lightGray.Sprint(padded)
+			case column == 2 && value == hookSucceeded:
+				padded = blue.Sprint(padded)
+			case column == 2 && value == hookSkipped:
+				padded = gray.Sprint(padded)
+			case column == 2:
+				padded = red.Sprint(padded)
+			}
+
+			columns = append(columns, padded)
+		}
+
+		showText(strings.Join(columns, "   "), program.indentLevel+1)
+	}
+}
+
+//
+//// TARGET HOOK RUNS
+//
+
+// How the hooks of several targets are run: one target at a time (targets is
+// 1) or several at once, with the output of each target either prefixed with
+// its name or buffered until its hooks have finished.
+type parallelism struct {
+	targets  int
+	buffered bool
+}
+
+func getParallelismFromCLI(targets int, output string, program Program) (parallelism, functionResponse) {
+	if targets < 1 {
+		return parallelism{}, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Invalid number of parallel targets '%d' (expected at least 1)", targets),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	if output != "prefix" && output != "buffer" {
+		return parallelism{}, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Invalid parallel output '%s' (expected 'prefix' or 'buffer')", output),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return parallelism{targets: targets, buffered: output == "buffer"}, functionResponse{
+		exitCode: 0,
+	}
+}
+
+// Where the messages and the output of the hooks of a target are written: the
+// terminal when targets run one at a time, or a targetOutput when they run in
+// parallel.
+type hookOutput interface {
+	io.Writer
+	show(c color.RGBColor, msg string, indentLevel int)
+}
+
+// Output of a target whose hooks run on their own, written to the terminal as
+// is (indented by indentLevel).
+type terminalOutput struct {
+	indentLevel int
+}
+
+func (output terminalOutput) Write(data []byte) (int, error) {
+	return os.Stdout.Write(data)
+}
+
+func (output terminalOutput) show(c color.RGBColor, msg string, indentLevel int) {
+	printColoredMessage(c, msg, output.indentLevel+indentLevel)
+}
+
+// Output of a target whose hooks run at the same time as those of other
+// targets. Each line is written at once, prefixed with the name of the target,
+// or kept until flush.
+type targetOutput struct {
+	// Shared by the outputs of all targets, so that lines are not mixed up
+	mutex    *sync.Mutex
+	prefix   string
+	buffered bool
+	lines    []string
+	// Last line written by the hook, until it is complete
+	partial []byte
+}
+
+func (output *targetOutput) Write(data []byte) (int, error) {
+	output.partial = append(output.partial, data...)
+
+	for {
+		index := bytes.IndexByte(output.partial, '\n')
+		if index < 0 {
+			break
+		}
+
+		output.println(strings.TrimSuffix(string(output.partial[:index]), "\r"))
+		output.partial = output.partial[index+1:]
+	}
+
+	return len(data), nil
+}
+
+func (output *targetOutput) println(line string) {
+	if output.buffered == true {
+		output.lines = append(output.lines, line)
+		return
+	}
+
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	fmt.Println(output.prefix + line)
+}
+
+func (output *targetOutput) show(c color.RGBColor, msg string, indentLevel int) {
+	output.println(c.Sprint(strings.Repeat("    ", indentLevel) + msg))
+}
+
+// Shows the message of the response, like handleFunctionResponse.
+func showOutputResponse(output hookOutput, response functionResponse) {
+	switch {
+	case response.message == "":
+	case response.logLevel == "attention":
+		output.show(orange, "> "+response.message, response.indentLevel)
+	case response.logLevel == "error":
+		output.show(red, "> Error: "+response.message, response.indentLevel)
+	case response.logLevel == "success" && response.exitCode == 0:
+		output.show(blue, "> "+response.message, response.indentLevel)
+	}
+}
+
+// Writes the last (incomplete) line of the hooks and, if the output is
+// buffered, all lines under title.
+func (output *targetOutput) flush(title string, program Program) {
+	if len(output.partial) > 0 {
+		output.println(strings.TrimSuffix(string(output.partial), "\r"))
+		output.partial = nil
+	}
+
+	if output.buffered == false {
+		return
+	}
+
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	showInfoSectionTitle(title, program.indentLevel)
+	for _, line := range output.lines {
+		showText(line, program.indentLevel+1)
+	}
+
+	space()
+}
+
+// Runs the hooks of the targets, parallel.targets at a time. A target run on
+// its own writes to the terminal and its hooks can read input from it; targets
+// run in parallel each write to their own targetOutput. The results are added
+// to summary, in the order of the targets. Without keepGoing, no other target
+// is started once a target fails. On SIGINT or SIGTERM, the running hooks are
+// killed.
+func runHooksOfTargets(repo Repo, targets []Target, hooks []string, notCreateTempDir bool, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, lock lockMode, hookArgs []string, hookEnv map[string]string, keepGoing bool, parallel parallelism, summary *hookSummary, program Program) functionResponse {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if parallel.targets > 1 {
+		showInfoSectionTitle(displayRepoTag(fmt.Sprintf("Running hook(s) of %d target(s), %d at a time", len(targets), parallel.targets), repo), program.indentLevel)
+		space()
+	}
+
+	width := 0
+	for _, target := range targets {
+		width = max(width, len(target.Name))
+	}
+
+	var outputMutex sync.Mutex
+	// Set once no other target should be started
+	var stopped atomic.Bool
+
+	results := make([]*hookSummary, len(targets))
+	responses := make([]functionResponse, len(targets))
+	indexes := make(chan int)
+
+	var waitGroup sync.WaitGroup
+	for worker := 0; worker < min(parallel.targets, len(targets)); worker++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+
+			for index := range indexes {
+				target := targets[index]
+
+				if ctx.Err() != nil || stopped.Load() == true {
+					results[index] = &hookSummary{}
+					results[index].skip(target.Name, hooks)
+					continue
+				}
+
+				if parallel.targets == 1 {
+					orange.Println(fmt.Sprintf("(%v/%v)", index+1, len(targets)))
+					showInfoSectionTitle(displayTargetTag("Running hook(s)", target), program.indentLevel)
+					space()
+
+					output := terminalOutput{indentLevel: program.indentLevel + 1}
+					results[index], responses[index] = runTargetHooks(ctx, repo, target, hooks, notCreateTempDir, notRemoveTempDir, notPrintOutput, notPrintEntryCmd, lock, hookArgs, hookEnv, true, output, program)
+
+					space()
+				} else {
+					output := &targetOutput{
+						mutex:    &outputMutex,
+						prefix:   gray.Sprintf("%-*s", width+2, "["+target.Name+"]") + " ",
+						buffered: parallel.buffered,
+					}
+					results[index], responses[index] = runTargetHooks(ctx, repo, target, hooks, notCreateTempDir, notRemoveTempDir, notPrintOutput, notPrintEntryCmd, lock, hookArgs, hookEnv, false, output, program)
+
+					output.flush(displayTargetTag("Hook(s) output", target), program)
+				}
+
+				if responses[index].exitCode != 0 || (keepGoing == false && results[index].failures() > 0) {
+					stopped.Store(true)
+				}
+			}
+		}()
+	}
+
+	for index := range targets {
+		indexes <- index
+	}
+	close(indexes)
+
+	waitGroup.Wait()
+
+	for _, result := range results {
+		summary.rows = append(summary.rows, result.rows...)
+	}
+
+	if parallel.targets > 1 && parallel.buffered == false {
+		space()
+	}
+
+	for _, response := range responses {
+		if response.exitCode != 0 {
+			response.indentLevel = program.indentLevel
+			return response
+		}
+	}
+
+	if ctx.Err() != nil {
+		return functionResponse{
+			exitCode:    130,
+			message:     "Interrupted",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	if failures := summary.failures(); failures > 0 && keepGoing == false {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("%d hook(s) failed", failures),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
+
+// Runs the hooks of a target, writing the messages and the output of the
+// hooks to output. Hook failures (and a lock held by another run) are only
+// recorded in the returned summary; errors that should stop the whole run
+// (the state or the temporary directory of the target cannot be handled) are
+// also returned as a response.
+func runTargetHooks(ctx context.Context, repo Repo, target Target, hooks []string, notCreateTempDir bool, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, lock lockMode, hookArgs []string, hookEnv map[string]string, interactive bool, output hookOutput, program Program) (*hookSummary, functionResponse) {
+	summary := &hookSummary{}
+
+	failed := func(response functionResponse) (*hookSummary, functionResponse) {
+		showOutputResponse(output, response)
+
+		for _, hook := range hooks {
+			summary.add(target.Name, hook, hookRun{status: hookFailed})
+		}
+		return summary, response
+	}
+
+	disabled, err := target.IsDisabled()
+	if err != nil {
+		return failed(functionResponse{
+			exitCode: 1,
+			message:  formatErrorMessage(err),
+			logLevel: "error",
+		})
+	} else if disabled == true {
+		output.show(orange, "> Target is disabled", 0)
+		return summary, functionResponse{exitCode: 0}
+	}
+
+	targetLock, err := acquireLock(ctx, targetLockPath(target), repo.Name+"/"+target.Name, newLockHolder(commandLine()), lock, func(holder lockHolder) {
+		output.show(orange, fmt.Sprintf("Waiting for the lock of '%s', held by %s", repo.Name+"/"+target.Name, holder), 0)
+	})
+	if err != nil {
+		status := hookLocked
+		if errors.Is(err, context.Canceled) {
+			status = hookSkipped
+		}
+
+		output.show(red, "> Error: "+formatErrorMessage(err), 0)
+
+		for _, hook := range hooks {
+			summary.add(target.Name, hook, hookRun{status: status})
+		}
+		return summary, functionResponse{exitCode: 0}
+	}
+	defer targetLock.release()
+
+	if response := setupTargetTempDirectory(target, notCreateTempDir, output); response.exitCode != 0 {
+		return failed(response)
+	}
+
+	for index, name := range hooks {
+		if ctx.Err() != nil {
+			summary.skip(target.Name, hooks[index:])
+			break
+		}
+
+		output.show(lightGray, lightGray.Sprintf("Running ")+orange.Sprintf(name)+lightGray.Sprintf(" hook"), 0)
+
+		hook, err := pacpilot.GetHook(getLibraryConfig(program), target.HooksDir, name)
+		if errors.Is(err, pacpilot.ErrHookNotFound) {
+			output.show(red, fmt.Sprintf("> Error: No '%v' hook found", name), 1)
+
+			summary.add(target.Name, name, hookRun{status: hookNotFound})
+			continue
+		} else if err != nil {
+			output.show(red, "> Error: "+formatErrorMessage(err), 1)
+
+			summary.add(target.Name, name, hookRun{status: hookFailed})
+			summary.skip(target.Name, hooks[index+1:])
+			break
+		}
+
+		if notPrintEntryCmd == false {
+			output.show(lightGray, fmt.Sprintf("Entry command: %s", paleLime.Sprintf(hook.Entry)), 1)
+		}
+
+		options := pacpilot.HookOptions{
+			Env:         mergeEnvironment(target.Environment, hookEnv),
+			Interactive: interactive,
+			Limits:      program.hookLimits,
+			Args:        hookArgs,
+		}
+		if notPrintOutput == false {
+			options.Output = output
+		}
+
+		started := time.Now()
+		result, err := pacpilot.RunHook(ctx, hook, options)
+		run := newHookRun(result, err, time.Since(started))
+
+		summary.add(target.Name, name, run)
+
+		if run.status == hookSucceeded {
+			output.show(blue, "> Finished", 1)
+			continue
+		}
+
+		switch {
+		case run.exited == true:
+			output.show(red, fmt.Sprintf("> Error: Failed to execute hook: exit code %v", result.ExitCode), 1)
+		case run.status == hookInterrupted:
+			output.show(red, "> Error: Interrupted", 1)
+		case run.status == hookFailed:
+			output.show(red, "> Error: Failed to execute hook -> "+err.Error(), 1)
+		default:
+			output.show(red, "> Error: "+formatErrorMessage(err), 1)
+		}
+
+		summary.skip(target.Name, hooks[index+1:])
+		break
+	}
+
+	// Hooks may have changed the target's database
+	events, err := recordTargetDatabaseEvents(target)
+	if err != nil {
+		output.show(orange, "> Failed to record package events -> "+err.Error(), 0)
+	} else if len(events) > 0 {
+		output.show(gray, fmt.Sprintf("> Recorded %d package event(s)", len(events)), 0)
+	}
+
+	response := removeTargetTempDirectory(target, notRemoveTempDir, output)
+	showOutputResponse(output, response)
+
+	return summary, response
+}
@@ -0,0 +1,164 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"os"
+	"path/filepath"
+	"testing"
+
+	// External modules
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
+)
+
+//
+//// TARGET HOOK RUNS
+//
+
+// Hook that claims the temporary directory of its target, waits for the
+// hooks of the other targets to do the same and fails if the directory was
+// shared (or if the target is 'b').
+const isolationTestHook = `#!/bin/sh
+echo "$TARGET_NAME" > "$TARGET_TEMP_DIR/owner"
+sleep 0.3
+[ "$(ls "$TARGET_TEMP_DIR")" = owner ] || exit 2
+[ "$(cat "$TARGET_TEMP_DIR/owner")" = "$TARGET_NAME" ] || exit 3
+[ "$TARGET_NAME" != b ]
+`
+
+// Creates the targets a, b and c of a repo, each with the hooks 'first' (see
+// isolationTestHook) and 'second'.
+func createTestTargets(t *testing.T) (Program, Repo, []Target) {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	program := Program{
+		name:         "pacpilot",
+		defaultShell: "/bin/sh",
+		dataDir:      dataDir,
+		reposDir:     dataDir + "/repos",
+	}
+
+	var targets []Target
+	for _, name := range []string{"a", "b", "c"} {
+		target := pacpilot.NewTarget(getLibraryConfig(program), "repo", name)
+
+		hooks := map[string]string{
+			"first":  isolationTestHook,
+			"second": "#!/bin/sh\ntrue\n",
+		}
+		for hook, script := range hooks {
+			if err := os.MkdirAll(target.HooksDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(target.HooksDir, hook), []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		targets = append(targets, target)
+	}
+
+	return program, targets[0].Repo, targets
+}
+
+func TestRunHooksOfTargets(t *testing.T) {
+	type row struct {
+		target string
+		hook   string
+		status string
+	}
+
+	tests := []struct {
+		name      string
+		parallel  int
+		keepGoing bool
+		exitCode  int
+		rows      []row
+	}{
+		{
+			name:      "parallel, keep going",
+			parallel:  3,
+			keepGoing: true,
+			exitCode:  0,
+			rows: []row{
+				{"a", "first", hookSucceeded}, {"a", "second", hookSucceeded},
+				{"b", "first", hookFailed}, {"b", "second", hookSkipped},
+				{"c", "first", hookSucceeded}, {"c", "second", hookSucceeded},
+			},
+		},
+		{
+			// Targets already running are finished
+			name:     "parallel, fail fast",
+			parallel: 3,
+			exitCode: 1,
+			rows: []row{
+				{"a", "first", hookSucceeded}, {"a", "second", hookSucceeded},
+				{"b", "first", hookFailed}, {"b", "second", hookSkipped},
+				{"c", "first", hookSucceeded}, {"c", "second", hookSucceeded},
+			},
+		},
+		{
+			name:      "one at a time, keep going",
+			parallel:  1,
+			keepGoing: true,
+			exitCode:  0,
+			rows: []row{
+				{"a", "first", hookSucceeded}, {"a", "second", hookSucceeded},
+				{"b", "first", hookFailed}, {"b", "second", hookSkipped},
+				{"c", "first", hookSucceeded}, {"c", "second", hookSucceeded},
+			},
+		},
+		{
+			name:     "one at a time, fail fast",
+			parallel: 1,
+			exitCode: 1,
+			rows: []row{
+				{"a", "first", hookSucceeded}, {"a", "second", hookSucceeded},
+				{"b", "first", hookFailed}, {"b", "second", hookSkipped},
+				{"c", "first", hookSkipped}, {"c", "second", hookSkipped},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program, repo, targets := createTestTargets(t)
+
+			summary := &hookSummary{}
+			response := runHooksOfTargets(repo, targets, []string{"first", "second"}, false, false, true, true, lockMode{wait: false}, nil, nil, test.keepGoing, parallelism{targets: test.parallel}, summary, program)
+
+			if response.exitCode != test.exitCode {
+				t.Errorf("exit code = %d (%q), expected %d", response.exitCode, response.message, test.exitCode)
+			}
+
+			var rows []row
+			for _, summaryRow := range summary.rows {
+				rows = append(rows, row{summaryRow.target, summaryRow.hook, summaryRow.run.status})
+
+				// A failure of the isolation checks has another exit code
+				if summaryRow.run.exited == true && summaryRow.run.ExitCode > 1 {
+					t.Errorf("hook '%s' of target '%s' exited with %d, expected its temporary directory to be its own", summaryRow.hook, summaryRow.target, summaryRow.run.ExitCode)
+				}
+			}
+
+			if len(rows) != len(test.rows) {
+				t.Fatalf("summary = %v, expected %v", rows, test.rows)
+			}
+			for index := range rows {
+				if rows[index] != test.rows[index] {
+					t.Errorf("summary row %d = %v, expected %v", index, rows[index], test.rows[index])
+				}
+			}
+
+			for _, target := range targets {
+				if _, err := os.Stat(target.TempDir); os.IsNotExist(err) == false {
+					t.Errorf("temporary directory of target '%s' was not removed", target.Name)
+				}
+			}
+		})
+	}
+}
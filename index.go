@@ -0,0 +1,649 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// External modules
+	fsnotify "github.com/fsnotify/fsnotify"
+)
+
+//
+//// SERVER INDEX
+//
+
+// Time to wait for more filesystem events before updating the index, so that
+// a burst of changes (e.g. repo-add writing a database) causes a single rescan
+const indexRescanDelay = 200 * time.Millisecond
+
+var (
+	errRepoNotFound   = errors.New("the requested repo could not be found")
+	errTargetNotFound = errors.New("the requested target could not be found")
+)
+
+type indexedTarget struct {
+	target  Target
+	enabled bool
+	arch    string
+	access  accessPolicy
+	network networkPolicy
+	// Set if the target could not be fully scanned
+	err error
+	// Contents of the pool directory, keyed by directory path relative to the
+	// pool directory ("" for the pool directory itself)
+	pool map[string][]os.FileInfo
+}
+
+type indexedRepo struct {
+	repo    Repo
+	enabled bool
+	access  accessPolicy
+	network networkPolicy
+	// Set if the repo could not be fully scanned
+	err     error
+	targets map[string]*indexedTarget
+}
+
+// In-memory index of the repos and targets served by the server, kept up to
+// date by watching the repos directory, so that requests do not need to read
+// the data directory.
+type serverIndex struct {
+	program     Program
+	mutex       sync.RWMutex
+	repos       map[string]*indexedRepo
+	watcher     *fsnotify.Watcher
+	dirtyMutex  sync.Mutex
+	dirty       map[string]bool
+	rescanTimer *time.Timer
+}
+
+func newServerIndex(program Program) (*serverIndex, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	index := &serverIndex{
+		program: program,
+		repos:   make(map[string]*indexedRepo),
+		watcher: watcher,
+		dirty:   make(map[string]bool),
+	}
+
+	err = watcher.Add(program.reposDir)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	repoNames, err := readDirNames(program.reposDir)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	for _, repoName := range repoNames {
+		index.rescanRepo(repoName)
+	}
+
+	go index.watch()
+
+	return index, nil
+}
+
+// Returns the names of the non-hidden entries of the directory.
+func readDirNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if fileIsHidden(entry.Name()) == false {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+//
+//// SCANNING
+//
+
+func (index *serverIndex) scanRepo(repoName string) *indexedRepo {
+	repo := generateRepoObj(repoName, index.program)
+
+	info, err := os.Stat(repo.Path)
+	if err != nil || info.IsDir() == false {
+		return nil
+	}
+
+	index.watcher.Add(repo.Path)
+	index.watcher.Add(repo.TargetsDir)
+
+	disabled, response := isRepoDisabled(repo, index.program)
+
+	indexed := &indexedRepo{
+		repo:    repo,
+		enabled: disabled == false,
+		targets: make(map[string]*indexedTarget),
+	}
+
+	if response.exitCode != 0 {
+		indexed.err = errors.New(response.message)
+		return indexed
+	}
+
+	settings, err := loadRepoSettings(repo)
+	indexed.access = newAccessPolicy(settings.Access, err)
+	indexed.network = newNetworkPolicy(settings.Network, err)
+
+	targetNames, err := readDirNames(repo.TargetsDir)
+	if err != nil && os.IsNotExist(err) == false {
+		indexed.err = fmt.Errorf("failed to read the repo's targets directory -> %v", err)
+		return indexed
+	}
+	for _, targetName := range targetNames {
+		if target := index.scanTarget(repoName, targetName); target != nil {
+			indexed.targets[targetName] = target
+		}
+	}
+
+	return indexed
+}
+
+func (index *serverIndex) scanTarget(repoName string, targetName string) *indexedTarget {
+	if fileIsHidden(targetName) {
+		return nil
+	}
+
+	target := generateTargetObj(repoName, targetName, index.program)
+
+	info, err := os.Stat(target.Path)
+	if err != nil || info.IsDir() == false {
+		return nil
+	}
+
+	index.watcher.Add(target.Path)
+
+	disabled, response := isTargetDisabled(target, index.program)
+
+	indexed := &indexedTarget{
+		target:  target,
+		enabled: disabled == false,
+		pool:    make(map[string][]os.FileInfo),
+	}
+
+	if response.exitCode != 0 {
+		indexed.err = errors.New(response.message)
+		return indexed
+	}
+
+	// An invalid configuration means that the target has no architecture and
+	// cannot be read by anyone
+	settings, err := loadTargetSettings(target)
+	if err == nil {
+		indexed.arch = targetArchitecture(target, settings)
+	}
+	indexed.access = newAccessPolicy(settings.Access, err)
+	indexed.network = newNetworkPolicy(settings.Network, err)
+
+	err = filepath.WalkDir(target.PoolDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// A target without a pool directory simply has no files
+			if path == target.PoolDir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if entry.IsDir() == false {
+			return nil
+		}
+
+		// Hidden entries (e.g. uploads being verified) are not served
+		if path != target.PoolDir && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		relativePath, err := filepath.Rel(target.PoolDir, path)
+		if err != nil {
+			return err
+		}
+		if relativePath == "." {
+			relativePath = ""
+		}
+
+		index.watcher.Add(path)
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+
+		files := make([]os.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if info, err := entry.Info(); err == nil {
+				files = append(files, info)
+			}
+		}
+		indexed.pool[relativePath] = files
+
+		return nil
+	})
+	if err != nil {
+		indexed.err = fmt.Errorf("failed to read the target's pool directory -> %v", err)
+	}
+
+	return indexed
+}
+
+func (index *serverIndex) rescanRepo(repoName string) {
+	indexed := index.scanRepo(repoName)
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	if indexed == nil {
+		delete(index.repos, repoName)
+	} else {
+		index.repos[repoName] = indexed
+	}
+}
+
+func (index *serverIndex) rescanTarget(repoName string, targetName string) {
+	indexed := index.scanTarget(repoName, targetName)
+
+	index.mutex.Lock()
+	repo, exists := index.repos[repoName]
+	if exists == true {
+		if indexed == nil {
+			delete(repo.targets, targetName)
+		} else {
+			repo.targets[targetName] = indexed
+		}
+	}
+	index.mutex.Unlock()
+
+	if exists == true && indexed != nil {
+		index.recordDatabaseEvents([]*indexedTarget{indexed})
+	}
+}
+
+// Records package events for the targets, whose databases may have been
+// changed outside of hook runs (e.g. by uploads or by hand).
+func (index *serverIndex) recordDatabaseEvents(indexedTargets []*indexedTarget) {
+	var targets []Target
+	for _, indexed := range indexedTargets {
+		if indexed.err == nil && indexed.enabled == true {
+			targets = append(targets, indexed.target)
+		}
+	}
+
+	recordTargetsDatabaseEvents(targets, index.program)
+}
+
+//
+//// WATCHING
+//
+
+func (index *serverIndex) watch() {
+	for {
+		select {
+		case event, ok := <-index.watcher.Events:
+			if ok == false {
+				return
+			}
+			index.handleEvent(event)
+		case err, ok := <-index.watcher.Errors:
+			if ok == false {
+				return
+			}
+			showAttention("=> Filesystem watcher error -> "+err.Error(), index.program.indentLevel)
+
+			// Events may have been lost, so everything is scanned again
+			index.markDirty("")
+		}
+	}
+}
+
+// Maps a filesystem event to the part of the index that needs to be scanned
+// again: a whole repo ('<repo>') or a single target ('<repo>/<target>').
+func (index *serverIndex) handleEvent(event fsnotify.Event) {
+	relativePath, err := filepath.Rel(index.program.reposDir, event.Name)
+	if err != nil || strings.HasPrefix(relativePath, "..") {
+		return
+	}
+
+	parts := strings.Split(relativePath, string(filepath.Separator))
+	if fileIsHidden(parts[0]) {
+		return
+	}
+
+	// Hidden directories in the targets directory are not targets
+	if len(parts) >= 3 && parts[1] == "targets" && fileIsHidden(parts[2]) {
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		index.markDirty(parts[0])
+	case len(parts) == 2 && (parts[1] == "disabled" || parts[1] == "targets" || parts[1] == "config.toml"):
+		index.markDirty(parts[0])
+	case len(parts) == 3 && parts[1] == "targets":
+		index.markDirty(parts[0] + "/" + parts[2])
+	case len(parts) >= 4 && parts[1] == "targets" && (parts[3] == "disabled" || parts[3] == "config.toml" || parts[3] == "pool"):
+		index.markDirty(parts[0] + "/" + parts[2])
+	}
+}
+
+func (index *serverIndex) markDirty(key string) {
+	index.dirtyMutex.Lock()
+	defer index.dirtyMutex.Unlock()
+
+	index.dirty[key] = true
+
+	if index.rescanTimer == nil {
+		index.rescanTimer = time.AfterFunc(indexRescanDelay, index.rescanDirty)
+	}
+}
+
+func (index *serverIndex) rescanDirty() {
+	index.dirtyMutex.Lock()
+	dirty := index.dirty
+	index.dirty = make(map[string]bool)
+	index.rescanTimer = nil
+	index.dirtyMutex.Unlock()
+
+	// An empty key means that the whole index must be rebuilt
+	if dirty[""] == true {
+		repoNames, _ := readDirNames(index.program.reposDir)
+
+		repos := make(map[string]*indexedRepo)
+		for _, repoName := range repoNames {
+			if indexed := index.scanRepo(repoName); indexed != nil {
+				repos[repoName] = indexed
+			}
+		}
+
+		index.mutex.Lock()
+		index.repos = repos
+		index.mutex.Unlock()
+
+		for _, repo := range repos {
+			targets := make([]*indexedTarget, 0, len(repo.targets))
+			for _, target := range repo.targets {
+				targets = append(targets, target)
+			}
+			index.recordDatabaseEvents(targets)
+		}
+
+		return
+	}
+
+	for key := range dirty {
+		if strings.Contains(key, "/") == false {
+			index.rescanRepo(key)
+		}
+	}
+
+	for key := range dirty {
+		repoName, targetName, found := strings.Cut(key, "/")
+		if found == true && dirty[repoName] == false {
+			index.rescanTarget(repoName, targetName)
+		}
+	}
+}
+
+//
+//// LOOKUPS
+//
+
+// Returns the repo if it exists and is enabled. Returns errRepoNotFound
+// otherwise, or the error found while scanning the repo.
+func (index *serverIndex) getRepo(repoName string) (Repo, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	repo, exists := index.repos[repoName]
+	if exists == false {
+		return Repo{}, errRepoNotFound
+	}
+	if repo.err != nil {
+		return Repo{}, repo.err
+	}
+	if repo.enabled == false {
+		return Repo{}, errRepoNotFound
+	}
+
+	return repo.repo, nil
+}
+
+// Returns the target if it and its repo exist and are enabled. Returns
+// errTargetNotFound otherwise, or the error found while scanning the target.
+func (index *serverIndex) getTarget(repoName string, targetName string) (Target, error) {
+	if _, err := index.getRepo(repoName); err != nil {
+		return Target{}, err
+	}
+
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	target, exists := index.repos[repoName].targets[targetName]
+	if exists == false {
+		return Target{}, errTargetNotFound
+	}
+	if target.err != nil {
+		return Target{}, target.err
+	}
+	if target.enabled == false {
+		return Target{}, errTargetNotFound
+	}
+
+	return target.target, nil
+}
+
+func (index *serverIndex) getEnabledRepos() []Repo {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	var repos []Repo
+	for _, repo := range index.repos {
+		if repo.enabled == true && repo.err == nil {
+			repos = append(repos, repo.repo)
+		}
+	}
+
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].Name < repos[j].Name
+	})
+
+	return repos
+}
+
+func (index *serverIndex) getEnabledTargets(repoName string) []Target {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	repo, exists := index.repos[repoName]
+	if exists == false || repo.enabled == false || repo.err != nil {
+		return nil
+	}
+
+	var targets []Target
+	for _, target := range repo.targets {
+		if target.enabled == true && target.err == nil {
+			targets = append(targets, target.target)
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Name < targets[j].Name
+	})
+
+	return targets
+}
+
+// Returns whether the client can read the repo and, if targetName is not
+// empty, the target. Resources that are not indexed have nothing to protect
+// (requests for them are answered with 404).
+func (index *serverIndex) canRead(client accessClient, repoName string, targetName string) bool {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	repo, exists := index.repos[repoName]
+	if exists == false {
+		return true
+	}
+	if repo.access.allows(client) == false {
+		return false
+	}
+
+	if targetName == "" {
+		return true
+	}
+
+	target, exists := repo.targets[targetName]
+	if exists == false {
+		return true
+	}
+
+	return target.access.allows(client)
+}
+
+// Returns whether the address is allowed to download from (or, if api is
+// true, to use the API of) the repo and, if targetName is not empty, the
+// target. Both the repo's and the target's networks must allow it.
+func (index *serverIndex) allowsAddress(ip net.IP, repoName string, targetName string, api bool) bool {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	repo, exists := index.repos[repoName]
+	if exists == false {
+		return true
+	}
+	if repo.network.allows(ip, api) == false {
+		return false
+	}
+
+	if targetName == "" {
+		return true
+	}
+
+	target, exists := repo.targets[targetName]
+	if exists == false {
+		return true
+	}
+
+	return target.network.allows(ip, api)
+}
+
+// Returns the first enabled target (in alphabetical order) that serves the
+// given architecture.
+func (index *serverIndex) getTargetByArch(repoName string, arch string) (Target, bool) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	repo, exists := index.repos[repoName]
+	if exists == false || repo.enabled == false || repo.err != nil {
+		return Target{}, false
+	}
+
+	var names []string
+	for name, target := range repo.targets {
+		if target.enabled == true && target.err == nil && target.arch == arch {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return Target{}, false
+	}
+
+	sort.Strings(names)
+
+	return repo.targets[names[0]].target, true
+}
+
+// Looks up a path relative to the target's pool directory. Returns the file's
+// info or, if the path is a directory, a nil info and the directory entries.
+func (index *serverIndex) getPoolEntry(target Target, resourcePath string) (os.FileInfo, []os.FileInfo, bool) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	repo, exists := index.repos[target.Repo.Name]
+	if exists == false {
+		return nil, nil, false
+	}
+
+	indexed, exists := repo.targets[target.Name]
+	if exists == false {
+		return nil, nil, false
+	}
+
+	resourcePath = strings.Trim(filepath.Clean("/"+resourcePath), "/")
+
+	if entries, isDir := indexed.pool[resourcePath]; isDir == true {
+		return nil, entries, true
+	}
+
+	dir, name := filepath.Split(resourcePath)
+	for _, entry := range indexed.pool[strings.TrimSuffix(dir, "/")] {
+		if entry.Name() == name {
+			return entry, nil, true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// Returns the package files in the target's pool directory (including
+// subdirectories) relative to the pool directory.
+func (index *serverIndex) getPoolPackages(target Target) []string {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	repo, exists := index.repos[target.Repo.Name]
+	if exists == false {
+		return nil
+	}
+
+	indexed, exists := repo.targets[target.Name]
+	if exists == false {
+		return nil
+	}
+
+	var packages []string
+	for dir, entries := range indexed.pool {
+		if strings.HasPrefix(dir, ".") || strings.Contains(dir, "/.") {
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() == false && isPackageFile(entry.Name()) && fileIsHidden(entry.Name()) == false {
+				packages = append(packages, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(packages)
+
+	return packages
+}
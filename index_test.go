@@ -0,0 +1,66 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// External modules
+	fsnotify "github.com/fsnotify/fsnotify"
+)
+
+//
+//// SERVER INDEX
+//
+
+func TestServerIndexHiddenTargets(t *testing.T) {
+	dataDir := t.TempDir()
+	program := Program{
+		name:     "pacpilot",
+		dataDir:  dataDir,
+		reposDir: dataDir + "/repos",
+	}
+
+	targetsDir := filepath.Join(program.reposDir, "repo", "targets")
+	for _, name := range []string{"x86_64", ".tmp"} {
+		if err := os.MkdirAll(filepath.Join(targetsDir, name, "pool"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index, err := newServerIndex(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.watcher.Close()
+
+	if _, err := index.getTarget("repo", "x86_64"); err != nil {
+		t.Errorf("getTarget(\"repo\", \"x86_64\") = %v, expected the target", err)
+	}
+
+	// Events of a hidden directory do not cause a rescan
+	for _, path := range []string{".tmp", ".tmp/pool", ".tmp/config.toml"} {
+		index.handleEvent(fsnotify.Event{Name: filepath.Join(targetsDir, path), Op: fsnotify.Create})
+	}
+
+	index.dirtyMutex.Lock()
+	dirty := len(index.dirty)
+	index.dirtyMutex.Unlock()
+
+	if dirty > 0 {
+		t.Errorf("events of a hidden directory marked %d key(s) to be rescanned, expected none", dirty)
+	}
+
+	// Nor is it indexed when rescanned
+	index.rescanTarget("repo", ".tmp")
+
+	if _, err := index.getTarget("repo", ".tmp"); errors.Is(err, errTargetNotFound) == false {
+		t.Errorf("getTarget(\"repo\", \".tmp\") = %v, expected %v", err, errTargetNotFound)
+	}
+}
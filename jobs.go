@@ -0,0 +1,639 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	// External modules
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
+	gin "github.com/gin-gonic/gin"
+)
+
+//
+//// HOOK JOBS
+//
+
+// Number of finished jobs kept in the history (older jobs are removed)
+const maxJobHistory = 200
+
+// Maximum size of the output stored with a job (only the end of longer outputs
+// is kept)
+const maxJobOutput = 1 << 20 // 1 MiB
+
+const (
+	// The job waits for the lock of its target (held by another run)
+	jobWaiting     = "waiting"
+	jobRunning     = "running"
+	jobSucceeded   = "succeeded"
+	jobFailed      = "failed"
+	jobCanceled    = "canceled"
+	jobInterrupted = "interrupted"
+	// The hook was terminated after running longer than its timeout
+	jobTimedOut = "timed-out"
+)
+
+var (
+	errJobNotFound = errors.New("the requested job could not be found")
+	errJobFinished = errors.New("the job has already finished")
+)
+
+// Hook run started through the API. Each job is saved in its own file in the
+// jobs directory, so that it can be looked up after the server restarts.
+type hookJob struct {
+	ID     string `json:"id"`
+	Repo   string `json:"repo"`
+	Target string `json:"target"`
+	Action string `json:"action"`
+	// Arguments given to the hook (the environment is not saved, as it may
+	// contain secrets)
+	Args   []string `json:"args,omitempty"`
+	Status string   `json:"status"`
+	// Not set until the hook exits
+	ExitCode *int   `json:"exitCode"`
+	Output   string `json:"output"`
+	// Reason why the hook could not run or finish
+	Error string `json:"error,omitempty"`
+	// Identity of the API credential that created the job
+	CreatedBy  string     `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+func (job hookJob) finished() bool {
+	return job.Status != jobWaiting && job.Status != jobRunning
+}
+
+// Output of a running job, written by the hook while it runs. Only the last
+// maxJobOutput bytes are kept, in a ring buffer. Readers are notified of new
+// output (and of the end of the output) through the updated channel, which is
+// closed and replaced on each write.
+type jobOutput struct {
+	mutex sync.Mutex
+	// Grows up to maxJobOutput bytes, then the oldest bytes are overwritten
+	buffer []byte
+	// Position of the oldest byte in the buffer (once it is full)
+	start int
+	// Number of bytes written since the job started
+	written int
+	updated chan struct{}
+	closed  bool
+}
+
+func newJobOutput() *jobOutput {
+	return &jobOutput{
+		updated: make(chan struct{}),
+	}
+}
+
+func (output *jobOutput) Write(data []byte) (int, error) {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	n := len(data)
+	output.written += n
+
+	if len(data) > maxJobOutput {
+		data = data[len(data)-maxJobOutput:]
+	}
+
+	if len(output.buffer) < maxJobOutput {
+		size := min(maxJobOutput-len(output.buffer), len(data))
+		output.buffer = append(output.buffer, data[:size]...)
+		data = data[size:]
+	}
+
+	for len(data) > 0 {
+		copied := copy(output.buffer[output.start:], data)
+		data = data[copied:]
+		output.start = (output.start + copied) % maxJobOutput
+	}
+
+	close(output.updated)
+	output.updated = make(chan struct{})
+
+	return n, nil
+}
+
+// Returns the kept output written after offset (relative to the start of the
+// output). Must be called with the mutex locked.
+func (output *jobOutput) bytes(offset int) []byte {
+	first := output.written - len(output.buffer)
+
+	skip := 0
+	if offset > first {
+		skip = offset - first
+	} else if first > 0 {
+		// The beginning was overwritten, which may have split a character
+		for skip < utf8.UTFMax-1 && utf8.RuneStart(output.buffer[(output.start+skip)%maxJobOutput]) == false {
+			skip++
+		}
+	}
+
+	data := make([]byte, 0, len(output.buffer)-skip)
+	if skip < len(output.buffer)-output.start {
+		data = append(data, output.buffer[output.start+skip:]...)
+		data = append(data, output.buffer[:output.start]...)
+	} else {
+		data = append(data, output.buffer[skip-(len(output.buffer)-output.start):output.start]...)
+	}
+
+	return data
+}
+
+func (output *jobOutput) String() string {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	return string(output.bytes(0))
+}
+
+// Returns the output written after offset, the offset of the end of the
+// output, a channel closed when more output is written and whether the output
+// has ended. Output that was overwritten before it was read is skipped.
+func (output *jobOutput) read(offset int) ([]byte, int, <-chan struct{}, bool) {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	return output.bytes(offset), output.written, output.updated, output.closed
+}
+
+// Marks the end of the output (once the job is finished).
+func (output *jobOutput) close() {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	if output.closed == false {
+		output.closed = true
+		close(output.updated)
+	}
+}
+
+type runningJob struct {
+	cancel context.CancelFunc
+	output *jobOutput
+	// Pseudo terminal of the hook (nil until the hook starts)
+	terminal *pacpilot.Terminal
+}
+
+type jobManager struct {
+	program Program
+	mutex   sync.Mutex
+	jobs    map[string]*hookJob
+	running map[string]*runningJob
+}
+
+// Loads the job history. Jobs that were running when the server stopped are
+// marked as interrupted.
+func newJobManager(program Program) (*jobManager, error) {
+	manager := &jobManager{
+		program: program,
+		jobs:    make(map[string]*hookJob),
+		running: make(map[string]*runningJob),
+	}
+
+	err := os.MkdirAll(program.jobsDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jobs directory -> %v", err)
+	}
+
+	paths, err := filepath.Glob(program.jobsDir + "/*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job file -> %v", err)
+		}
+
+		var job hookJob
+		err = json.Unmarshal(contents, &job)
+		if err != nil || job.ID == "" || filepath.Base(path) != job.ID+".json" {
+			showAttention(fmt.Sprintf("=> Ignoring invalid job file '%s'", path), program.indentLevel)
+			continue
+		}
+
+		if job.finished() == false {
+			now := time.Now().UTC()
+			job.Status = jobInterrupted
+			job.Error = "the server stopped while the hook was running"
+			job.FinishedAt = &now
+
+			err = manager.save(&job)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		manager.jobs[job.ID] = &job
+	}
+
+	manager.prune()
+
+	return manager, nil
+}
+
+// Saves the job atomically (jobs are read by clients while being updated).
+// Must be called with the mutex held (or before the manager is shared).
+func (manager *jobManager) save(job *hookJob) error {
+	contents, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := manager.program.jobsDir + "/" + job.ID + ".json"
+	tempPath := path + ".new"
+
+	err = os.WriteFile(tempPath, contents, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to save job '%s' -> %v", job.ID, err)
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+// Removes the oldest finished jobs beyond the history limit. Must be called
+// with the mutex held.
+func (manager *jobManager) prune() {
+	var finished []*hookJob
+	for _, job := range manager.jobs {
+		if job.finished() == true {
+			finished = append(finished, job)
+		}
+	}
+
+	if len(finished) <= maxJobHistory {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].CreatedAt.Before(finished[j].CreatedAt)
+	})
+
+	for _, job := range finished[:len(finished)-maxJobHistory] {
+		os.Remove(manager.program.jobsDir + "/" + job.ID + ".json")
+		delete(manager.jobs, job.ID)
+	}
+}
+
+// Starts running the hook in the background and returns its job. The job
+// holds the lock of its target while the hook runs. If the target is locked,
+// the job waits for the lock according to mode (and a lockedError is returned,
+// without creating a job, in fail-fast mode).
+func (manager *jobManager) start(target Target, hook pacpilot.Hook, args []string, env map[string]string, createdBy string, mode lockMode) (hookJob, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return hookJob{}, fmt.Errorf("failed to generate job ID -> %v", err)
+	}
+
+	lockPath := targetLockPath(target)
+	lockName := target.Repo.Name + "/" + target.Name
+	holder := newLockHolder(fmt.Sprintf("%s (job %s)", commandLine(), id))
+
+	// Try to take the lock at once, so that the job is only created (and
+	// waits) if it is allowed to
+	lock, err := acquireLock(context.Background(), lockPath, lockName, holder, lockMode{wait: false}, nil)
+	var lockedErr *lockedError
+	if errors.As(err, &lockedErr) && mode.wait == false {
+		return hookJob{}, err
+	} else if err != nil && lockedErr == nil {
+		return hookJob{}, err
+	}
+
+	job := &hookJob{
+		ID:        id,
+		Repo:      target.Repo.Name,
+		Target:    target.Name,
+		Action:    hook.Name,
+		Args:      args,
+		Status:    jobRunning,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}
+	if lock == nil {
+		job.Status = jobWaiting
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	running := &runningJob{
+		cancel: cancel,
+		output: newJobOutput(),
+	}
+
+	manager.mutex.Lock()
+	err = manager.save(job)
+	if err == nil {
+		manager.jobs[id] = job
+		manager.running[id] = running
+	}
+	manager.mutex.Unlock()
+
+	if err != nil {
+		cancel()
+		if lock != nil {
+			lock.release()
+		}
+		return hookJob{}, err
+	}
+
+	// The job is updated by the goroutine once it takes the lock
+	snapshot := *job
+
+	go func() {
+		defer cancel()
+
+		if lock == nil {
+			showInfo(fmt.Sprintf("=> Job %s: waiting for the lock of '%s', held by %s", id, lockName, lockedErr.holder), manager.program.indentLevel)
+			fmt.Fprintf(running.output, "Waiting for the lock of '%s', held by %s\r\n", lockName, lockedErr.holder)
+
+			lock, err = acquireLock(ctx, lockPath, lockName, holder, mode, nil)
+			if err != nil {
+				manager.finish(id, pacpilot.HookResult{}, err, running.output)
+				return
+			}
+
+			manager.mutex.Lock()
+			job.Status = jobRunning
+			err = manager.save(job)
+			manager.mutex.Unlock()
+			if err != nil {
+				showError(fmt.Sprintf("=> Job %s: %v", id, err), manager.program.indentLevel)
+			}
+		}
+		defer lock.release()
+
+		showInfo(fmt.Sprintf("=> Job %s: running hook '%s' on '%s'", id, hook.Name, lockName), manager.program.indentLevel)
+
+		// Hooks run without a terminal, as nobody is there to interact with them
+		result, err := pacpilot.RunHook(ctx, hook, pacpilot.HookOptions{
+			Env:    mergeEnvironment(target.Environment, env),
+			Args:   args,
+			Output: running.output,
+			Attach: func(terminal *pacpilot.Terminal) {
+				manager.mutex.Lock()
+				running.terminal = terminal
+				manager.mutex.Unlock()
+			},
+		})
+
+		// The hook may have changed the target's database
+		recordTargetsDatabaseEvents([]Target{target}, manager.program)
+
+		manager.finish(id, result, err, running.output)
+	}()
+
+	return snapshot, nil
+}
+
+func (manager *jobManager) finish(id string, result pacpilot.HookResult, err error, output *jobOutput) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	job := manager.jobs[id]
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+
+	var lockedErr *lockedError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Status = jobCanceled
+		job.Output = pacpilot.CleanupOutput(output.String())
+	case errors.Is(err, pacpilot.ErrHookTimedOut):
+		job.Status = jobTimedOut
+		job.Error = err.Error()
+		job.Output = result.Output
+	case errors.Is(err, pacpilot.ErrCPULimitExceeded):
+		job.Status = jobFailed
+		job.Error = err.Error()
+		job.Output = result.Output
+	case errors.As(err, &lockedErr):
+		job.Status = jobFailed
+		job.Error = err.Error()
+		job.Output = pacpilot.CleanupOutput(output.String())
+	case err != nil:
+		job.Status = jobFailed
+		job.Error = fmt.Sprintf("failed to execute hook -> %v", err)
+		job.Output = pacpilot.CleanupOutput(output.String())
+	default:
+		job.Status = jobSucceeded
+		if result.ExitCode != 0 {
+			job.Status = jobFailed
+		}
+		job.ExitCode = &result.ExitCode
+		job.Output = result.Output
+	}
+
+	job.Output = truncateJobOutput(job.Output)
+
+	delete(manager.running, id)
+
+	err = manager.save(job)
+	if err != nil {
+		showError(fmt.Sprintf("=> Job %s: %v", id, err), manager.program.indentLevel)
+	}
+
+	// Streams read the finished job once the output ends
+	output.close()
+
+	showInfo(fmt.Sprintf("=> Job %s: %s", id, job.Status), manager.program.indentLevel)
+
+	manager.prune()
+}
+
+// Returns the last maxJobOutput bytes of the output, starting at the next
+// character so that the cut doesn't leave part of a character.
+func truncateJobOutput(output string) string {
+	if len(output) <= maxJobOutput {
+		return output
+	}
+
+	output = output[len(output)-maxJobOutput:]
+	for i := 0; i < utf8.UTFMax-1; i++ {
+		if utf8.RuneStart(output[0]) == true {
+			break
+		}
+		output = output[1:]
+	}
+
+	return output
+}
+
+// Returns a copy of the job, with the output written so far if it is running.
+func (manager *jobManager) get(id string) (hookJob, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	job, exists := manager.jobs[id]
+	if exists == false {
+		return hookJob{}, errJobNotFound
+	}
+
+	if running, exists := manager.running[id]; exists == true {
+		snapshot := *job
+		snapshot.Output = truncateJobOutput(pacpilot.CleanupOutput(running.output.String()))
+		return snapshot, nil
+	}
+
+	return *job, nil
+}
+
+// Returns the output of the job, or nil if it is not running.
+func (manager *jobManager) output(id string) *jobOutput {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if running, exists := manager.running[id]; exists == true {
+		return running.output
+	}
+
+	return nil
+}
+
+// Returns the pseudo terminal and output of the job, or nil if it is not
+// running.
+func (manager *jobManager) terminal(id string) (*pacpilot.Terminal, *jobOutput) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if running, exists := manager.running[id]; exists == true && running.terminal != nil {
+		return running.terminal, running.output
+	}
+
+	return nil, nil
+}
+
+// Kills the hook of a running job (and all processes in its process group).
+// The job is marked as canceled once the hook exits.
+func (manager *jobManager) cancel(id string) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if _, exists := manager.jobs[id]; exists == false {
+		return errJobNotFound
+	}
+
+	running, exists := manager.running[id]
+	if exists == false {
+		return errJobFinished
+	}
+
+	running.cancel()
+
+	return nil
+}
+
+//
+//// HOOK JOBS (SERVER FUNCTIONALITY)
+//
+
+// Returns the job of the request, if the request's credential can run the
+// job's action on its target (and its address can use the target's API).
+// Responds and returns false otherwise.
+func getAuthorizedJob(c *gin.Context, jobs *jobManager, index *serverIndex, program Program) (hookJob, bool) {
+	job, err := jobs.get(c.Param("id"))
+	if err != nil {
+		respondWithError(c, err, program)
+		return hookJob{}, false
+	}
+
+	if authorizeAddress(c, index, job.Repo, job.Target, true, program) == false {
+		return hookJob{}, false
+	}
+
+	if authorizeAPIRequest(c, generateTargetObj(job.Repo, job.Target, program), job.Action, program) == false {
+		return hookJob{}, false
+	}
+
+	return job, true
+}
+
+// Maximum size of the body of a hook request
+const maxHookRequestSize = 64 << 10 // 64 KiB
+
+// Optional JSON body of a hook request: the arguments of the hook (by name)
+// and extra environment variables.
+type hookRequest struct {
+	Args map[string]any    `json:"args"`
+	Env  map[string]string `json:"env"`
+}
+
+// Returns the arguments and environment variables given in the body of the
+// request, which should be declared in the hook manifest.
+func parseHookRequest(c *gin.Context, hook pacpilot.Hook) ([]string, map[string]string, error) {
+	var request hookRequest
+
+	decoder := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxHookRequestSize))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+
+	// The body is optional
+	err := decoder.Decode(&request)
+	if err != nil && errors.Is(err, io.EOF) == false {
+		return nil, nil, fmt.Errorf("invalid request body -> %v", err)
+	}
+
+	if len(request.Args) > 0 && len(hook.Args) == 0 {
+		return nil, nil, fmt.Errorf("hook '%s' does not declare arguments", hook.Name)
+	}
+
+	names := make([]string, 0, len(request.Args))
+	for name := range request.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, name := range names {
+		var value string
+		switch typed := request.Args[name].(type) {
+		case string:
+			value = typed
+		case json.Number:
+			value = typed.String()
+		case bool:
+			value = strconv.FormatBool(typed)
+		default:
+			return nil, nil, fmt.Errorf("argument '%s' should be a string, number or boolean", name)
+		}
+
+		args = append(args, "--"+name+"="+value)
+	}
+
+	_, err = hook.ParseArgs(args)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w -> %v", pacpilot.ErrInvalidHookArgs, err)
+	}
+
+	for name := range request.Env {
+		if hook.DeclaresEnv(name) == false {
+			return nil, nil, fmt.Errorf("environment variable '%s' is not declared by hook '%s'", name, hook.Name)
+		}
+	}
+
+	return args, request.Env, nil
+}
+
+// Whether the route of the request is a jobs route, which requires an API
+// credential like the other API routes.
+func isJobsRoute(c *gin.Context) bool {
+	return strings.HasPrefix(c.FullPath(), "/jobs/")
+}
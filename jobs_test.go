@@ -0,0 +1,206 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	// External modules
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
+	gin "github.com/gin-gonic/gin"
+)
+
+//
+//// HOOK JOBS
+//
+
+func TestJobOutputRead(t *testing.T) {
+	output := newJobOutput()
+	output.Write([]byte("first\n"))
+
+	data, offset, updated, closed := output.read(0)
+	if string(data) != "first\n" || offset != 6 || closed == true {
+		t.Fatalf("read(0) = %q, %d, %v, expected \"first\\n\", 6, false", data, offset, closed)
+	}
+
+	output.Write([]byte("second\n"))
+
+	select {
+	case <-updated:
+	default:
+		t.Error("the updated channel should be closed after a write")
+	}
+
+	data, offset, _, _ = output.read(offset)
+	if string(data) != "second\n" || offset != 13 {
+		t.Errorf("read(6) = %q, %d, expected \"second\\n\", 13", data, offset)
+	}
+
+	output.close()
+	if _, _, _, closed := output.read(offset); closed == false {
+		t.Error("read() should report the end of the output after close()")
+	}
+}
+
+func TestJobOutputKeepsEnd(t *testing.T) {
+	output := newJobOutput()
+
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	var written bytes.Buffer
+	for i := 0; written.Len() < 3*maxJobOutput; i++ {
+		// Chunks of varying sizes, so that writes wrap around the buffer at
+		// different positions
+		data := chunk[:len(chunk)-i%7]
+		output.Write(data)
+		written.Write(data)
+	}
+
+	expected := written.Bytes()[written.Len()-maxJobOutput:]
+	if result := output.String(); result != string(expected) {
+		t.Errorf("String() kept %d bytes, expected the last %d bytes of the output", len(result), maxJobOutput)
+	}
+	if len(output.buffer) != maxJobOutput {
+		t.Errorf("the buffer has %d bytes, expected %d", len(output.buffer), maxJobOutput)
+	}
+
+	// Reading from an overwritten offset skips to the oldest kept output
+	data, offset, _, _ := output.read(0)
+	if bytes.Equal(data, expected) == false || offset != written.Len() {
+		t.Errorf("read(0) = %d bytes, %d, expected %d bytes, %d", len(data), offset, len(expected), written.Len())
+	}
+
+	// Reading from a kept offset returns the output after it
+	data, _, _, _ = output.read(written.Len() - 10)
+	if bytes.Equal(data, expected[len(expected)-10:]) == false {
+		t.Errorf("read() = %q, expected %q", data, expected[len(expected)-10:])
+	}
+
+	// A single write larger than the buffer
+	output.Write(bytes.Repeat([]byte("x"), maxJobOutput+100))
+	if result := output.String(); result != strings.Repeat("x", maxJobOutput) {
+		t.Errorf("String() after a large write kept %d bytes, expected %d bytes of 'x'", len(result), maxJobOutput)
+	}
+}
+
+func TestJobOutputRuneBoundary(t *testing.T) {
+	output := newJobOutput()
+
+	// The cut falls in the middle of the first 'é' that is kept
+	output.Write([]byte(strings.Repeat("é", maxJobOutput/2)))
+	output.Write([]byte("a"))
+
+	result := output.String()
+	if utf8.ValidString(result) == false {
+		t.Fatal("String() should not start in the middle of a character")
+	}
+	if result != strings.Repeat("é", maxJobOutput/2-1)+"a" {
+		t.Errorf("String() = %d bytes, expected %d bytes", len(result), maxJobOutput-1)
+	}
+}
+
+func TestTruncateJobOutput(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected string
+	}{
+		{output: "", expected: ""},
+		{output: "short output", expected: "short output"},
+		{output: strings.Repeat("x", maxJobOutput), expected: strings.Repeat("x", maxJobOutput)},
+		{output: "abc" + strings.Repeat("x", maxJobOutput), expected: strings.Repeat("x", maxJobOutput)},
+		// Two-byte characters, cut after the first byte of one
+		{output: strings.Repeat("é", maxJobOutput/2) + "a", expected: strings.Repeat("é", maxJobOutput/2-1) + "a"},
+		// Four-byte character cut after its first byte
+		{output: "🙂" + strings.Repeat("x", maxJobOutput-3), expected: strings.Repeat("x", maxJobOutput-3)},
+	}
+
+	for _, test := range tests {
+		result := truncateJobOutput(test.output)
+		if result != test.expected {
+			t.Errorf("truncateJobOutput(%d bytes) = %d bytes, expected %d bytes", len(test.output), len(result), len(test.expected))
+		}
+		if utf8.ValidString(result) == false {
+			t.Errorf("truncateJobOutput(%d bytes) returned invalid UTF-8", len(test.output))
+		}
+	}
+}
+
+func TestParseHookRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	declared := pacpilot.Hook{
+		Name:        "build",
+		Declared:    true,
+		RequiredEnv: []string{"PACKAGER"},
+		OptionalEnv: []string{"DEBUG"},
+		Args: []pacpilot.HookArg{
+			{Name: "pkg", Type: pacpilot.HookArgString, Required: true},
+			{Name: "jobs", Type: pacpilot.HookArgInt},
+			{Name: "clean", Type: pacpilot.HookArgBool},
+		},
+	}
+	undeclared := pacpilot.Hook{Name: "build"}
+
+	tests := []struct {
+		hook    pacpilot.Hook
+		body    string
+		args    []string
+		env     map[string]string
+		fails   bool
+		argsErr bool
+	}{
+		{hook: undeclared, body: ""},
+		{hook: undeclared, body: `{}`},
+		{hook: declared, body: `{"args": {"pkg": "foo"}}`, args: []string{"--pkg=foo"}},
+		{
+			hook: declared,
+			body: `{"args": {"pkg": "foo", "jobs": 4, "clean": true}, "env": {"PACKAGER": "John", "DEBUG": "1"}}`,
+			args: []string{"--clean=true", "--jobs=4", "--pkg=foo"},
+			env:  map[string]string{"PACKAGER": "John", "DEBUG": "1"},
+		},
+		// Missing required argument
+		{hook: declared, body: "", fails: true, argsErr: true},
+		{hook: declared, body: `{"args": {"jobs": 4}}`, fails: true, argsErr: true},
+		// Wrong argument type
+		{hook: declared, body: `{"args": {"pkg": "foo", "jobs": "four"}}`, fails: true, argsErr: true},
+		{hook: declared, body: `{"args": {"pkg": "foo", "clean": "yes"}}`, fails: true, argsErr: true},
+		{hook: declared, body: `{"args": {"pkg": ["foo"]}}`, fails: true},
+		{hook: declared, body: `{"args": {"pkg": null}}`, fails: true},
+		// Unknown argument
+		{hook: declared, body: `{"args": {"pkg": "foo", "force": true}}`, fails: true, argsErr: true},
+		{hook: undeclared, body: `{"args": {"pkg": "foo"}}`, fails: true},
+		// Undeclared environment variable
+		{hook: declared, body: `{"args": {"pkg": "foo"}, "env": {"HOME": "/tmp"}}`, fails: true},
+		{hook: undeclared, body: `{"env": {"PACKAGER": "John"}}`, fails: true},
+		// Unknown JSON field or invalid body
+		{hook: declared, body: `{"args": {"pkg": "foo"}, "environment": {"PACKAGER": "John"}}`, fails: true},
+		{hook: declared, body: `{"args": {"pkg": "foo"}, "env": {"PACKAGER": 1}}`, fails: true},
+		{hook: declared, body: `{"args": `, fails: true},
+	}
+
+	for _, test := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/repos/repo/target/api/build", strings.NewReader(test.body))
+
+		args, env, err := parseHookRequest(c, test.hook)
+
+		if test.fails == true {
+			if err == nil {
+				t.Errorf("parseHookRequest(%q) = %q, %v, expected an error", test.body, args, env)
+			} else if errors.Is(err, pacpilot.ErrInvalidHookArgs) != test.argsErr {
+				t.Errorf("parseHookRequest(%q) = %v, expected errors.Is(err, ErrInvalidHookArgs) to be %v", test.body, err, test.argsErr)
+			}
+		} else if err != nil || reflect.DeepEqual(args, test.args) == false || reflect.DeepEqual(env, test.env) == false {
+			t.Errorf("parseHookRequest(%q) = %q, %v, %v, expected %q, %v", test.body, args, env, err, test.args, test.env)
+		}
+	}
+}
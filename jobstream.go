@@ -0,0 +1,290 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// External modules
+	gin "github.com/gin-gonic/gin"
+)
+
+//
+//// JOB OUTPUT STREAMS (SERVER FUNCTIONALITY)
+//
+
+// Time between heartbeat events, which keep idle connections from being
+// closed by proxies
+const jobHeartbeatInterval = 15 * time.Second
+
+// Streams the output of the job as Server-Sent Events: a 'line' event for
+// each line of output, a 'heartbeat' event while the hook is quiet and an
+// 'exit' event with the final status of the job. The output of finished jobs
+// is replayed from the job history.
+func streamJob(c *gin.Context, jobs *jobManager, job hookJob, ansiMode string) {
+	converter := &ansiConverter{mode: ansiMode}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// Disable response buffering in nginx
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	sendLine := func(line string) {
+		// Lines end with '\r\n' in pseudo terminals. Other carriage returns
+		// move the cursor back to the start of the line, so only the text
+		// written after the last one is visible.
+		line = strings.TrimRight(line, "\r")
+		if index := strings.LastIndex(line, "\r"); index >= 0 {
+			line = line[index+1:]
+		}
+
+		c.SSEvent("line", gin.H{"line": converter.convert(line)})
+	}
+
+	output := jobs.output(job.ID)
+
+	if output == nil {
+		if job.Output != "" {
+			for _, line := range strings.Split(job.Output, "\n") {
+				sendLine(line)
+			}
+		}
+	} else {
+		heartbeat := time.NewTicker(jobHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		offset := 0
+		var partialLine string
+
+		for {
+			data, end, updated, closed := output.read(offset)
+			offset = end
+
+			lines := strings.Split(partialLine+string(data), "\n")
+			partialLine = lines[len(lines)-1]
+			for _, line := range lines[:len(lines)-1] {
+				sendLine(line)
+			}
+
+			if closed == true {
+				if strings.Trim(partialLine, "\r") != "" {
+					sendLine(partialLine)
+				}
+				break
+			}
+
+			c.Writer.Flush()
+
+			select {
+			case <-updated:
+			case <-heartbeat.C:
+				c.SSEvent("heartbeat", gin.H{"time": time.Now().UTC()})
+			case <-c.Request.Context().Done():
+				return
+			}
+		}
+
+		job, _ = jobs.get(job.ID)
+	}
+
+	c.SSEvent("exit", gin.H{
+		"status":   job.Status,
+		"exitCode": job.ExitCode,
+		"error":    job.Error,
+	})
+	c.Writer.Flush()
+}
+
+//
+//// ANSI ESCAPE SEQUENCES
+//
+
+const (
+	// Escape sequences are sent as written by the hook
+	ansiKeep = "keep"
+	// Escape sequences are removed
+	ansiStrip = "strip"
+	// Colors are converted to HTML (and other escape sequences are removed)
+	ansiHTML = "html"
+)
+
+func validateANSIMode(mode string) error {
+	if mode != ansiKeep && mode != ansiStrip && mode != ansiHTML {
+		return fmt.Errorf("invalid ANSI mode '%s' (expected '%s', '%s' or '%s')", mode, ansiKeep, ansiStrip, ansiHTML)
+	}
+
+	return nil
+}
+
+var ansiEscapeSequence = regexp.MustCompile(`\x1b\[([0-9;]*)([a-zA-Z])`)
+
+// Standard xterm colors (0-7) and their bright variants (8-15)
+var ansiBasicColors = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// Converts the escape sequences of a stream of lines. In HTML mode, the SGR
+// state (colors and bold) carries over to the next lines, like in a terminal.
+type ansiConverter struct {
+	mode       string
+	foreground string
+	background string
+	bold       bool
+}
+
+func (converter *ansiConverter) convert(line string) string {
+	switch converter.mode {
+	case ansiStrip:
+		return ansiEscapeSequence.ReplaceAllString(line, "")
+	case ansiHTML:
+		return converter.toHTML(line)
+	}
+
+	return line
+}
+
+func (converter *ansiConverter) toHTML(line string) string {
+	var builder strings.Builder
+
+	builder.WriteString(converter.openSpan())
+
+	last := 0
+	for _, match := range ansiEscapeSequence.FindAllStringSubmatchIndex(line, -1) {
+		builder.WriteString(html.EscapeString(line[last:match[0]]))
+		last = match[1]
+
+		// Only SGR sequences (colors and text attributes) are converted
+		if line[match[4]:match[5]] != "m" {
+			continue
+		}
+
+		builder.WriteString(converter.closeSpan())
+		converter.applySGR(line[match[2]:match[3]])
+		builder.WriteString(converter.openSpan())
+	}
+	builder.WriteString(html.EscapeString(line[last:]))
+
+	builder.WriteString(converter.closeSpan())
+
+	return builder.String()
+}
+
+func (converter *ansiConverter) styled() bool {
+	return converter.foreground != "" || converter.background != "" || converter.bold == true
+}
+
+func (converter *ansiConverter) openSpan() string {
+	if converter.styled() == false {
+		return ""
+	}
+
+	var styles []string
+	if converter.foreground != "" {
+		styles = append(styles, "color:"+converter.foreground)
+	}
+	if converter.background != "" {
+		styles = append(styles, "background-color:"+converter.background)
+	}
+	if converter.bold == true {
+		styles = append(styles, "font-weight:bold")
+	}
+
+	return "<span style=\"" + strings.Join(styles, ";") + "\">"
+}
+
+func (converter *ansiConverter) closeSpan() string {
+	if converter.styled() == false {
+		return ""
+	}
+
+	return "</span>"
+}
+
+func (converter *ansiConverter) applySGR(parameters string) {
+	var codes []int
+	for _, parameter := range strings.Split(parameters, ";") {
+		// An empty parameter means 0 (reset)
+		code, _ := strconv.Atoi(parameter)
+		codes = append(codes, code)
+	}
+
+	for i := 0; i < len(codes); i++ {
+		code := codes[i]
+
+		switch {
+		case code == 0:
+			converter.foreground = ""
+			converter.background = ""
+			converter.bold = false
+		case code == 1:
+			converter.bold = true
+		case code == 22:
+			converter.bold = false
+		case code >= 30 && code <= 37:
+			converter.foreground = ansiBasicColors[code-30]
+		case code >= 90 && code <= 97:
+			converter.foreground = ansiBasicColors[code-90+8]
+		case code == 39:
+			converter.foreground = ""
+		case code >= 40 && code <= 47:
+			converter.background = ansiBasicColors[code-40]
+		case code >= 100 && code <= 107:
+			converter.background = ansiBasicColors[code-100+8]
+		case code == 49:
+			converter.background = ""
+		case code == 38 || code == 48:
+			// Extended colors: '5;<index>' (256 colors) or '2;<r>;<g>;<b>'
+			// (true color)
+			color, used := extendedColor(codes[i+1:])
+			i += used
+			if code == 38 {
+				converter.foreground = color
+			} else {
+				converter.background = color
+			}
+		}
+	}
+}
+
+// Returns the color of the parameters of an extended color sequence and the
+// number of parameters used.
+func extendedColor(parameters []int) (string, int) {
+	if len(parameters) >= 2 && parameters[0] == 5 {
+		return xtermColor(parameters[1]), 2
+	}
+	if len(parameters) >= 4 && parameters[0] == 2 {
+		return fmt.Sprintf("#%02x%02x%02x", parameters[1]&0xff, parameters[2]&0xff, parameters[3]&0xff), 4
+	}
+
+	return "", len(parameters)
+}
+
+// Returns the color of the xterm 256-color palette.
+func xtermColor(index int) string {
+	switch {
+	case index >= 0 && index < 16:
+		return ansiBasicColors[index]
+	case index >= 16 && index < 232:
+		// 6x6x6 color cube
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		index -= 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[index/36], levels[index/6%6], levels[index%6])
+	case index >= 232 && index < 256:
+		// Grayscale ramp
+		level := 8 + (index-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", level, level, level)
+	}
+
+	return ""
+}
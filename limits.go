@@ -0,0 +1,402 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// External modules
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
+	gin "github.com/gin-gonic/gin"
+)
+
+//
+//// SERVER LIMITS
+//
+
+// Limits of 'repos serve'. A zero value disables the limit.
+type serverLimits struct {
+	// Requests per second allowed for each client address
+	requestRate float64
+	// Requests a client can make at once before being limited to requestRate
+	requestBurst int
+	// Bytes per second for all downloads and for the downloads of each client
+	// address
+	bandwidth       int64
+	clientBandwidth int64
+	// Concurrent downloads allowed for each client address
+	clientDownloads int
+}
+
+func validateServerLimits(limits serverLimits) error {
+	if limits.requestRate < 0 {
+		return fmt.Errorf("the request rate should not be negative")
+	}
+	if limits.requestRate > 0 && limits.requestBurst < 1 {
+		return fmt.Errorf("the request burst should be at least 1")
+	}
+	if limits.bandwidth < 0 || limits.clientBandwidth < 0 {
+		return fmt.Errorf("bandwidth limits should not be negative")
+	}
+	if limits.clientDownloads < 0 {
+		return fmt.Errorf("the number of concurrent downloads should not be negative")
+	}
+
+	return nil
+}
+
+// Parses a number of bytes per second with an optional K, M or G suffix
+// (powers of 1024) and an optional '/s' suffix, e.g. '512K' or '10M/s'.
+func parseByteRate(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	number := strings.TrimSuffix(value, "/s")
+
+	rate, err := pacpilot.ParseSize(number)
+	if err != nil || number == "" || rate > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid bandwidth '%s' (expected bytes per second, e.g. '512K' or '10M')", value)
+	}
+
+	return int64(rate), nil
+}
+
+//
+//// RATE LIMITING
+//
+
+// Time after which the bucket of an idle client is forgotten
+const rateLimiterIdleTime = 10 * time.Minute
+
+// Token buckets for each client address: a bucket holds up to burst tokens,
+// is refilled with rate tokens per second and each request takes one token.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// Set while requests are rejected, so that only the first rejection is
+	// logged
+	limited bool
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	limiter := &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	go limiter.cleanup()
+
+	return limiter
+}
+
+// Takes a token from the client's bucket. If the bucket is empty, returns
+// false and the time until a token is available.
+func (limiter *rateLimiter) take(client string) (bool, time.Duration, bool) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+
+	bucket, exists := limiter.buckets[client]
+	if exists == false {
+		bucket = &tokenBucket{tokens: limiter.burst, updated: now}
+		limiter.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*limiter.rate)
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.limited = false
+		return true, 0, false
+	}
+
+	firstRejection := bucket.limited == false
+	bucket.limited = true
+
+	wait := time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+
+	return false, wait, firstRejection
+}
+
+func (limiter *rateLimiter) cleanup() {
+	for range time.Tick(rateLimiterIdleTime) {
+		limiter.mutex.Lock()
+		for client, bucket := range limiter.buckets {
+			if time.Since(bucket.updated) > rateLimiterIdleTime {
+				delete(limiter.buckets, client)
+			}
+		}
+		limiter.mutex.Unlock()
+	}
+}
+
+// Rejects requests from clients that exceeded their request rate with 429 and
+// a Retry-After header.
+func rateLimit(limiter *rateLimiter, program Program) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, wait, firstRejection := limiter.take(c.ClientIP())
+		if allowed == true {
+			c.Next()
+			return
+		}
+
+		if firstRejection == true {
+			logSecurityEvent(c, fmt.Sprintf("address %s exceeded the request rate", c.ClientIP()), program)
+		}
+
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"message": "Too many requests: try again later",
+		})
+	}
+}
+
+//
+//// DOWNLOAD LIMITS
+//
+
+// Seconds after which clients rejected for having too many concurrent
+// downloads should try again
+const downloadRetryAfter = 10
+
+// Size of the chunks in which throttled downloads are written
+const throttledChunkSize = 16 << 10
+
+// Limits the bandwidth of file downloads (for all clients and for each client
+// address) and the number of concurrent downloads of each client address.
+type downloadLimiter struct {
+	limits serverLimits
+	// Shared by all downloads (nil if there is no global limit)
+	bandwidth *bandwidthLimiter
+	mutex     sync.Mutex
+	// Clients with active downloads, keyed by address
+	clients map[string]*downloadClient
+}
+
+type downloadClient struct {
+	active int
+	// Shared by the downloads of the client (nil if there is no limit)
+	bandwidth *bandwidthLimiter
+}
+
+func newDownloadLimiter(limits serverLimits) *downloadLimiter {
+	limiter := &downloadLimiter{
+		limits:  limits,
+		clients: make(map[string]*downloadClient),
+	}
+
+	if limits.bandwidth > 0 {
+		limiter.bandwidth = newBandwidthLimiter(limits.bandwidth)
+	}
+
+	return limiter
+}
+
+// Registers a download of the client, unless it already has the maximum
+// number of concurrent downloads.
+func (limiter *downloadLimiter) acquire(address string) (*downloadClient, bool) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	client, exists := limiter.clients[address]
+	if exists == false {
+		client = &downloadClient{}
+		if limiter.limits.clientBandwidth > 0 {
+			client.bandwidth = newBandwidthLimiter(limiter.limits.clientBandwidth)
+		}
+		limiter.clients[address] = client
+	}
+
+	if limiter.limits.clientDownloads > 0 && client.active >= limiter.limits.clientDownloads {
+		return nil, false
+	}
+
+	client.active++
+
+	return client, true
+}
+
+func (limiter *downloadLimiter) release(address string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	client, exists := limiter.clients[address]
+	if exists == false {
+		return
+	}
+
+	client.active--
+	if client.active <= 0 {
+		delete(limiter.clients, address)
+	}
+}
+
+// Serves a file within the download limits. Clients with too many concurrent
+// downloads get a 503 response with a Retry-After header, which makes pacman
+// try the next mirror.
+func (limiter *downloadLimiter) serveFile(c *gin.Context, path string, program Program) {
+	address := c.ClientIP()
+
+	client, ok := limiter.acquire(address)
+	if ok == false {
+		showAttention(fmt.Sprintf("=> Download limit reached: rejected request '%s %s' from %s (%d concurrent downloads)", c.Request.Method, c.Request.URL.Path, address, limiter.limits.clientDownloads), program.indentLevel)
+
+		c.Header("Retry-After", strconv.Itoa(downloadRetryAfter))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"message": "Service unavailable: too many concurrent downloads, try again later",
+		})
+		return
+	}
+	defer limiter.release(address)
+
+	var bandwidths []*bandwidthLimiter
+	for _, bandwidth := range []*bandwidthLimiter{limiter.bandwidth, client.bandwidth} {
+		if bandwidth != nil {
+			bandwidths = append(bandwidths, bandwidth)
+		}
+	}
+
+	if len(bandwidths) > 0 {
+		c.Writer = &throttledWriter{
+			ResponseWriter: c.Writer,
+			ctx:            c.Request.Context(),
+			bandwidths:     bandwidths,
+		}
+	}
+
+	c.File(path)
+}
+
+//
+//// BANDWIDTH LIMITING
+//
+
+// Spaces out writes so that they do not exceed rate bytes per second. Each
+// write reserves the time needed to send it after the previous writes.
+type bandwidthLimiter struct {
+	rate  float64
+	mutex sync.Mutex
+	// Time at which the reserved bytes will have been sent
+	next time.Time
+}
+
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	return &bandwidthLimiter{rate: float64(rate)}
+}
+
+// Returns how long a write would have to wait if it was reserved now.
+func (limiter *bandwidthLimiter) delay() time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	return max(limiter.next.Sub(time.Now()), 0)
+}
+
+// Reserves the time needed to send size bytes and returns how long to wait
+// before sending them.
+func (limiter *bandwidthLimiter) reserve(size int) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	if limiter.next.Before(now) {
+		limiter.next = now
+	}
+
+	wait := limiter.next.Sub(now)
+	limiter.next = limiter.next.Add(time.Duration(float64(size) / limiter.rate * float64(time.Second)))
+
+	return wait
+}
+
+// Response writer that writes in chunks, waiting for the bandwidth limiters
+// before each chunk. Stops writing when the request is canceled.
+type throttledWriter struct {
+	gin.ResponseWriter
+	ctx        context.Context
+	bandwidths []*bandwidthLimiter
+}
+
+func (writer *throttledWriter) Write(data []byte) (int, error) {
+	written := 0
+
+	for written < len(data) {
+		chunk := data[written:min(written+throttledChunkSize, len(data))]
+
+		// The chunk is reserved from the slowest limiter first and from the
+		// others once it can be sent, so that they are not held while waiting
+		slowest := 0
+		for i, bandwidth := range writer.bandwidths {
+			if bandwidth.delay() > writer.bandwidths[slowest].delay() {
+				slowest = i
+			}
+		}
+
+		err := writer.wait(writer.bandwidths[slowest].reserve(len(chunk)))
+		if err != nil {
+			return written, err
+		}
+
+		var wait time.Duration
+		for i, bandwidth := range writer.bandwidths {
+			if i != slowest {
+				wait = max(wait, bandwidth.reserve(len(chunk)))
+			}
+		}
+
+		err = writer.wait(wait)
+		if err != nil {
+			return written, err
+		}
+
+		n, err := writer.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Waits for the given time, unless the request is canceled first.
+func (writer *throttledWriter) wait(wait time.Duration) error {
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-writer.ctx.Done():
+		return writer.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (writer *throttledWriter) WriteString(data string) (int, error) {
+	return writer.Write([]byte(data))
+}
@@ -0,0 +1,149 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	// External modules
+	gin "github.com/gin-gonic/gin"
+)
+
+//
+//// SERVER LIMITS
+//
+
+func TestParseByteRate(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		fails    bool
+	}{
+		{value: "", expected: 0},
+		{value: "0", expected: 0},
+		{value: "1000", expected: 1000},
+		{value: "512K", expected: 512 << 10},
+		{value: "512k", expected: 512 << 10},
+		{value: "10M", expected: 10 << 20},
+		{value: "2G", expected: 2 << 30},
+		{value: "10M/s", expected: 10 << 20},
+		{value: "100/s", expected: 100},
+		{value: "8589934591G", expected: 8589934591 << 30},
+
+		{value: "/s", fails: true},
+		{value: "10M/S", fails: true},
+		{value: "10MB", fails: true},
+		{value: "10 M", fails: true},
+		{value: "-1", fails: true},
+		{value: "1.5M", fails: true},
+		{value: "M", fails: true},
+		{value: "fast", fails: true},
+		// Larger than an int64
+		{value: "8589934592G", fails: true},
+		// Larger than an uint64
+		{value: "17179869184G", fails: true},
+	}
+
+	for _, test := range tests {
+		rate, err := parseByteRate(test.value)
+
+		if test.fails == true {
+			if err == nil {
+				t.Errorf("parseByteRate(%q) = %d, expected an error", test.value, rate)
+			}
+		} else if err != nil || rate != test.expected {
+			t.Errorf("parseByteRate(%q) = %d, %v, expected %d", test.value, rate, err, test.expected)
+		}
+	}
+}
+
+//
+//// BANDWIDTH LIMITING
+//
+
+func TestBandwidthLimiterReserve(t *testing.T) {
+	limiter := newBandwidthLimiter(1000)
+
+	if wait := limiter.reserve(500); wait != 0 {
+		t.Errorf("first reserve() = %v, expected no wait", wait)
+	}
+
+	// The second write waits for the first one to be sent
+	if wait := limiter.reserve(500); wait < 450*time.Millisecond || wait > 500*time.Millisecond {
+		t.Errorf("second reserve() = %v, expected about 500ms", wait)
+	}
+	if delay := limiter.delay(); delay < 950*time.Millisecond || delay > time.Second {
+		t.Errorf("delay() = %v, expected about 1s", delay)
+	}
+
+	// Idle time is not saved up for later writes
+	limiter.next = time.Now().Add(-time.Hour)
+	if delay := limiter.delay(); delay != 0 {
+		t.Errorf("delay() of an idle limiter = %v, expected 0", delay)
+	}
+	if wait := limiter.reserve(500); wait != 0 {
+		t.Errorf("reserve() of an idle limiter = %v, expected no wait", wait)
+	}
+}
+
+func newTestThrottledWriter(ctx context.Context, bandwidths ...*bandwidthLimiter) (*throttledWriter, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	return &throttledWriter{ResponseWriter: c.Writer, ctx: ctx, bandwidths: bandwidths}, recorder
+}
+
+func TestThrottledWriterReservesSlowestFirst(t *testing.T) {
+	// The client limiter is busy with other downloads, the shared one is idle
+	shared := newBandwidthLimiter(100 << 20)
+	client := newBandwidthLimiter(100 << 20)
+	client.next = time.Now().Add(200 * time.Millisecond)
+
+	writer, recorder := newTestThrottledWriter(context.Background(), shared, client)
+
+	data := bytes.Repeat([]byte("x"), 1000)
+	start := time.Now()
+
+	n, err := writer.Write(data)
+	if err != nil || n != len(data) {
+		t.Fatalf("Write() = %d, %v, expected %d, nil", n, err, len(data))
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Write() took %v, expected it to wait for the client limiter", elapsed)
+	}
+	if bytes.Equal(recorder.Body.Bytes(), data) == false {
+		t.Error("Write() did not write the data")
+	}
+
+	// The shared limiter was only reserved once the chunk could be sent, so
+	// other downloads could use it in the meantime
+	if shared.next.Before(start.Add(200 * time.Millisecond)) {
+		t.Errorf("the shared limiter was reserved %v after the write started, expected after the client limiter's wait", shared.next.Sub(start))
+	}
+}
+
+func TestThrottledWriterCanceled(t *testing.T) {
+	limiter := newBandwidthLimiter(1000)
+	limiter.next = time.Now().Add(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	writer, recorder := newTestThrottledWriter(ctx, limiter)
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	n, err := writer.Write([]byte("data"))
+	if err != context.Canceled || n != 0 {
+		t.Errorf("Write() = %d, %v, expected 0, context.Canceled", n, err)
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("Write() wrote %d bytes after the request was canceled", recorder.Body.Len())
+	}
+}
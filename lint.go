@@ -0,0 +1,386 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	// External modules
+)
+
+//
+//// LINT RULES
+//
+
+const (
+	lintSeverityError   = "error"
+	lintSeverityWarning = "warning"
+	lintSeverityInfo    = "info"
+	lintSeverityIgnore  = "ignore"
+)
+
+type lintRule struct {
+	name            string
+	description     string
+	defaultSeverity string
+	check           func(pkg pkgArchive, target Target, settings TargetSettings) []string
+}
+
+type lintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type lintReport struct {
+	File     string        `json:"file"`
+	Package  string        `json:"package"`
+	Version  string        `json:"version"`
+	Findings []lintFinding `json:"findings"`
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+}
+
+// Architectures known to pacman. A target without an 'arch' setting that is
+// named after one of them is assumed to serve that architecture.
+var knownArchitectures = []string{"x86_64", "x86_64_v2", "x86_64_v3", "x86_64_v4", "i686", "pentium4", "aarch64", "armv7h", "armv6h", "arm", "riscv64", "loong64", "powerpc64le"}
+
+func targetArchitecture(target Target, settings TargetSettings) string {
+	if settings.Arch != "" {
+		return settings.Arch
+	}
+
+	for _, arch := range knownArchitectures {
+		if target.Name == arch {
+			return arch
+		}
+	}
+
+	return ""
+}
+
+// Returns an error if the package architecture is not accepted by a target
+// serving targetArch. Targets without an architecture accept any package.
+func verifyPackageArchitecture(pkgArch string, targetArch string) error {
+	if targetArch == "" || pkgArch == "any" || pkgArch == targetArch {
+		return nil
+	}
+
+	return fmt.Errorf("Package architecture '%s' does not match target architecture '%s'", pkgArch, targetArch)
+}
+
+var lintRules = []lintRule{
+	{
+		name:            "arch-mismatch",
+		description:     "Package architecture must match the target architecture or be 'any'",
+		defaultSeverity: lintSeverityError,
+		check: func(pkg pkgArchive, target Target, settings TargetSettings) []string {
+			err := verifyPackageArchitecture(pkg.field("arch"), targetArchitecture(target, settings))
+			if err != nil {
+				return []string{err.Error()}
+			}
+
+			return nil
+		},
+	},
+	{
+		name:            "empty-package",
+		description:     "Package must contain at least one file",
+		defaultSeverity: lintSeverityError,
+		check: func(pkg pkgArchive, target Target, settings TargetSettings) []string {
+			for _, file := range pkg.files {
+				if file.isDir == false && !strings.HasPrefix(file.name, ".") {
+					return nil
+				}
+			}
+
+			return []string{"Package does not contain any files"}
+		},
+	},
+	{
+		name:            "world-writable",
+		description:     "Files and directories must not be world-writable",
+		defaultSeverity: lintSeverityError,
+		check: func(pkg pkgArchive, target Target, settings TargetSettings) []string {
+			var messages []string
+
+			for _, file := range pkg.files {
+				if file.isLink == true {
+					continue
+				}
+
+				// Directories with the sticky bit set (like /tmp) are allowed
+				if file.isDir == true && file.mode&os.ModeSticky != 0 {
+					continue
+				}
+
+				if file.mode.Perm()&0002 != 0 {
+					messages = append(messages, fmt.Sprintf("'%s' is world-writable (%04o)", file.name, file.mode.Perm()))
+				}
+			}
+
+			return messages
+		},
+	},
+	{
+		name:            "missing-license",
+		description:     "Package must declare a license",
+		defaultSeverity: lintSeverityWarning,
+		check: func(pkg pkgArchive, target Target, settings TargetSettings) []string {
+			if len(pkg.fields("license")) == 0 {
+				return []string{"Package does not declare a license"}
+			}
+
+			return nil
+		},
+	},
+	{
+		name:            "usr-local",
+		description:     "Packages must not install files under /usr/local",
+		defaultSeverity: lintSeverityError,
+		check: func(pkg pkgArchive, target Target, settings TargetSettings) []string {
+			var messages []string
+
+			for _, file := range pkg.files {
+				if strings.HasPrefix(file.name, "usr/local/") {
+					messages = append(messages, fmt.Sprintf("'%s' is installed under /usr/local", file.name))
+				}
+			}
+
+			return messages
+		},
+	},
+	{
+		name:            "unknown-packager",
+		description:     "Package should have a packager set",
+		defaultSeverity: lintSeverityInfo,
+		check: func(pkg pkgArchive, target Target, settings TargetSettings) []string {
+			packager := pkg.field("packager")
+
+			if packager == "" || packager == "Unknown Packager" {
+				return []string{"Package has no packager set"}
+			}
+
+			return nil
+		},
+	},
+}
+
+func lintRuleSeverity(rule lintRule, settings TargetSettings) string {
+	if severity, ok := settings.Lint.Severities[rule.name]; ok {
+		return severity
+	}
+
+	return rule.defaultSeverity
+}
+
+func validateLintSettings(settings TargetSettings) error {
+	for ruleName, severity := range settings.Lint.Severities {
+		found := false
+		for _, rule := range lintRules {
+			if rule.name == ruleName {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			return fmt.Errorf("unknown lint rule '%s'", ruleName)
+		}
+
+		switch severity {
+		case lintSeverityError, lintSeverityWarning, lintSeverityInfo, lintSeverityIgnore:
+		default:
+			return fmt.Errorf("invalid severity '%s' for lint rule '%s'", severity, ruleName)
+		}
+	}
+
+	return nil
+}
+
+//
+//// LINT ENGINE
+//
+
+func lintPackage(path string, displayName string, target Target, settings TargetSettings) lintReport {
+	report := lintReport{
+		File:     displayName,
+		Findings: []lintFinding{},
+	}
+
+	addFinding := func(rule string, severity string, message string) {
+		report.Findings = append(report.Findings, lintFinding{
+			Rule:     rule,
+			Severity: severity,
+			Message:  message,
+		})
+
+		switch severity {
+		case lintSeverityError:
+			report.Errors++
+		case lintSeverityWarning:
+			report.Warnings++
+		}
+	}
+
+	pkg, err := readPackageArchive(path)
+	if err != nil {
+		addFinding("invalid-archive", lintSeverityError, err.Error())
+		return report
+	}
+
+	if pkg.hasPkgInfo == false {
+		addFinding("missing-pkginfo", lintSeverityError, "Package does not contain a .PKGINFO file")
+		return report
+	}
+
+	report.Package = pkg.field("pkgname")
+	report.Version = pkg.field("pkgver")
+
+	for _, rule := range lintRules {
+		severity := lintRuleSeverity(rule, settings)
+		if severity == lintSeverityIgnore {
+			continue
+		}
+
+		for _, message := range rule.check(pkg, target, settings) {
+			addFinding(rule.name, severity, message)
+		}
+	}
+
+	return report
+}
+
+func getPoolPackages(target Target) ([]string, error) {
+	files, err := ioutil.ReadDir(target.PoolDir)
+	if err != nil {
+		return nil, err
+	}
+	files = filterHiddenFilesAndDirectories(files)
+
+	var packages []string
+	for _, file := range files {
+		if file.IsDir() == false && isPackageFile(file.Name()) {
+			packages = append(packages, file.Name())
+		}
+	}
+	sort.Strings(packages)
+
+	return packages, nil
+}
+
+func targetsPkgsLint(repo Repo, targets []Target, files []string, jsonOutput bool, program Program) functionResponse {
+	var reports []lintReport
+	failed := false
+
+	for index, target := range targets {
+		settings, err := loadTargetSettings(target)
+		if err == nil {
+			err = validateLintSettings(settings)
+		}
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Invalid lint configuration for target '%s' -> %v", target.Name, err),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		packages := files
+		if len(packages) == 0 {
+			packages, err = getPoolPackages(target)
+			if err != nil {
+				return functionResponse{
+					exitCode:    1,
+					message:     "Failed to read the target's pool directory -> " + err.Error(),
+					logLevel:    "error",
+					indentLevel: program.indentLevel,
+				}
+			}
+		}
+
+		if jsonOutput == false {
+			space()
+			orange.Println(fmt.Sprintf("(%v/%v)", index+1, len(targets)))
+			showInfoSectionTitle(displayTargetTag("Linting packages", target), program.indentLevel)
+
+			if len(packages) == 0 {
+				showAttention("> No packages found", program.indentLevel+1)
+			}
+		}
+
+		for _, name := range packages {
+			report := lintPackage(filepath.Join(target.PoolDir, name), name, target, settings)
+			reports = append(reports, report)
+
+			if report.Errors > 0 {
+				failed = true
+			}
+
+			if jsonOutput == false {
+				displayLintReport(report, program)
+			}
+		}
+	}
+
+	if jsonOutput == true {
+		if reports == nil {
+			reports = []lintReport{}
+		}
+
+		output, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     "Failed to encode lint findings -> " + err.Error(),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+		fmt.Println(string(output))
+	}
+
+	if failed == true {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Lint checks found errors",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
+
+func displayLintReport(report lintReport, program Program) {
+	space()
+	showText(fmt.Sprintf("- %s", report.File), program.indentLevel+1)
+
+	if len(report.Findings) == 0 {
+		showSuccess("> No issues found", program.indentLevel+2)
+		return
+	}
+
+	for _, finding := range report.Findings {
+		message := fmt.Sprintf("> [%s] %s: %s", finding.Severity, finding.Rule, finding.Message)
+
+		switch finding.Severity {
+		case lintSeverityError:
+			showError(message, program.indentLevel+2)
+		case lintSeverityWarning:
+			showAttention(message, program.indentLevel+2)
+		default:
+			showInfo(message, program.indentLevel+2)
+		}
+	}
+}
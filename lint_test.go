@@ -0,0 +1,386 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	// External modules
+	zstd "github.com/klauspost/compress/zstd"
+	xz "github.com/ulikunitz/xz"
+)
+
+//
+//// TEST PACKAGES
+//
+
+const testPkgInfo = `# Generated by makepkg
+pkgname = foo
+pkgver = 1.0-1
+arch = x86_64
+packager = Jane Doe <jane@example.org>
+license = MIT
+license = Apache-2.0
+`
+
+// Returns a package archive (a tarball compressed with compression: 'gzip',
+// 'zstd', 'xz' or 'none') with the .PKGINFO contents (if not empty) and the
+// entries.
+func buildTestPackage(t *testing.T, compression string, pkgInfo string, entries []tar.Header) []byte {
+	t.Helper()
+
+	var archive bytes.Buffer
+	tarWriter := tar.NewWriter(&archive)
+
+	if pkgInfo != "" {
+		entries = append([]tar.Header{{Name: ".PKGINFO", Mode: 0644, Size: int64(len(pkgInfo)), Typeflag: tar.TypeReg}}, entries...)
+	}
+
+	for _, header := range entries {
+		header := header
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+
+		if err := tarWriter.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+
+		if header.Name == ".PKGINFO" {
+			tarWriter.Write([]byte(pkgInfo))
+		} else if header.Typeflag == tar.TypeReg {
+			tarWriter.Write(make([]byte, header.Size))
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var compressed bytes.Buffer
+	var writer io.WriteCloser
+
+	switch compression {
+	case "gzip":
+		writer = gzip.NewWriter(&compressed)
+	case "zstd":
+		encoder, err := zstd.NewWriter(&compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer = encoder
+	case "xz":
+		encoder, err := xz.NewWriter(&compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer = encoder
+	default:
+		return archive.Bytes()
+	}
+
+	writer.Write(archive.Bytes())
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return compressed.Bytes()
+}
+
+func writeTestPackage(t *testing.T, name string, contents []byte) string {
+	t.Helper()
+
+	path := t.TempDir() + "/" + name
+	if err := os.WriteFile(path, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+var testPackageEntries = []tar.Header{
+	{Name: "./usr/", Mode: 0755, Typeflag: tar.TypeDir},
+	{Name: "./usr/bin/", Mode: 0755, Typeflag: tar.TypeDir},
+	{Name: "./usr/bin/foo", Mode: 0755, Size: 12},
+	{Name: "./usr/bin/foo-link", Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: "foo"},
+}
+
+//
+//// PACKAGE ARCHIVES
+//
+
+func TestReadPackageArchive(t *testing.T) {
+	for _, compression := range []string{"gzip", "zstd", "xz", "none"} {
+		t.Run(compression, func(t *testing.T) {
+			path := writeTestPackage(t, "foo-1.0-1-x86_64.pkg.tar", buildTestPackage(t, compression, testPkgInfo, testPackageEntries))
+
+			pkg, err := readPackageArchive(path)
+			if err != nil {
+				t.Fatalf("readPackageArchive() error = %v", err)
+			}
+
+			if pkg.hasPkgInfo == false {
+				t.Fatal("readPackageArchive() did not find .PKGINFO")
+			}
+
+			fields := map[string]string{
+				"pkgname":  "foo",
+				"pkgver":   "1.0-1",
+				"arch":     "x86_64",
+				"packager": "Jane Doe <jane@example.org>",
+				"missing":  "",
+			}
+			for key, expected := range fields {
+				if value := pkg.field(key); value != expected {
+					t.Errorf("field(%q) = %q, expected %q", key, value, expected)
+				}
+			}
+
+			if licenses := pkg.fields("license"); reflect.DeepEqual(licenses, []string{"MIT", "Apache-2.0"}) == false {
+				t.Errorf("fields(\"license\") = %q, expected both licenses", licenses)
+			}
+
+			expectedFiles := []pkgFile{
+				{name: "usr", mode: os.ModeDir | 0755, isDir: true},
+				{name: "usr/bin", mode: os.ModeDir | 0755, isDir: true},
+				{name: "usr/bin/foo", mode: 0755, size: 12},
+				{name: "usr/bin/foo-link", mode: os.ModeSymlink | 0777, isLink: true, linkName: "foo"},
+			}
+			if reflect.DeepEqual(pkg.files, expectedFiles) == false {
+				t.Errorf("files = %+v, expected %+v", pkg.files, expectedFiles)
+			}
+		})
+	}
+}
+
+func TestReadPackageArchiveWithoutPkgInfo(t *testing.T) {
+	path := writeTestPackage(t, "foo.pkg.tar.gz", buildTestPackage(t, "gzip", "", testPackageEntries))
+
+	pkg, err := readPackageArchive(path)
+	if err != nil {
+		t.Fatalf("readPackageArchive() error = %v", err)
+	}
+
+	if pkg.hasPkgInfo == true || len(pkg.files) != len(testPackageEntries) {
+		t.Errorf("readPackageArchive() = %+v, expected no .PKGINFO and %d files", pkg, len(testPackageEntries))
+	}
+}
+
+func TestReadPackageArchiveInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"not an archive":    []byte("this is not a tarball, but it is long enough to be read as one"),
+		"truncated gzip":    buildTestPackage(t, "gzip", testPkgInfo, testPackageEntries)[:20],
+		"corrupted zstd":    append([]byte{0x28, 0xb5, 0x2f, 0xfd}, bytes.Repeat([]byte{0xff}, 32)...),
+		"xz magic only":     {0xfd, '7', 'z', 'X', 'Z', 0x00},
+		"empty gzip header": {0x1f, 0x8b},
+	}
+
+	for name, contents := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeTestPackage(t, "foo.pkg.tar", contents)
+
+			if _, err := readPackageArchive(path); err == nil {
+				t.Error("readPackageArchive() should fail")
+			}
+		})
+	}
+
+	if _, err := readPackageArchive(t.TempDir() + "/missing.pkg.tar.zst"); err == nil {
+		t.Error("readPackageArchive() should fail for a missing file")
+	}
+}
+
+func TestParsePkgInfo(t *testing.T) {
+	info := parsePkgInfo([]byte("# comment\n\npkgname = foo\n  depend = glibc  \ndepend = bash\ninvalid line\nempty =\n"))
+
+	expected := map[string][]string{
+		"pkgname": {"foo"},
+		"depend":  {"glibc", "bash"},
+		"empty":   {""},
+	}
+	if reflect.DeepEqual(info, expected) == false {
+		t.Errorf("parsePkgInfo() = %q, expected %q", info, expected)
+	}
+}
+
+func TestIsPackageFile(t *testing.T) {
+	tests := map[string]bool{
+		"foo-1.0-1-x86_64.pkg.tar.zst":     true,
+		"foo-1.0-1-x86_64.pkg.tar.xz":      true,
+		"foo-1.0-1-x86_64.pkg.tar":         true,
+		"foo-1.0-1-x86_64.pkg.tar.zst.sig": false,
+		"myrepo.db.tar.gz":                 false,
+		"notes.txt":                        false,
+	}
+
+	for name, expected := range tests {
+		if isPackageFile(name) != expected {
+			t.Errorf("isPackageFile(%q) = %v, expected %v", name, !expected, expected)
+		}
+	}
+}
+
+//
+//// LINT RULES
+//
+
+func findLintRule(t *testing.T, name string) lintRule {
+	t.Helper()
+
+	for _, rule := range lintRules {
+		if rule.name == name {
+			return rule
+		}
+	}
+
+	t.Fatalf("lint rule '%s' not found", name)
+	return lintRule{}
+}
+
+func TestLintRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		pkg      pkgArchive
+		target   Target
+		settings TargetSettings
+		findings int
+	}{
+		{name: "matching arch", rule: "arch-mismatch", pkg: pkgArchive{info: map[string][]string{"arch": {"x86_64"}}}, target: Target{Name: "x86_64"}},
+		{name: "any arch", rule: "arch-mismatch", pkg: pkgArchive{info: map[string][]string{"arch": {"any"}}}, target: Target{Name: "aarch64"}},
+		{name: "mismatching arch", rule: "arch-mismatch", pkg: pkgArchive{info: map[string][]string{"arch": {"aarch64"}}}, target: Target{Name: "x86_64"}, findings: 1},
+		{name: "arch from settings", rule: "arch-mismatch", pkg: pkgArchive{info: map[string][]string{"arch": {"x86_64"}}}, target: Target{Name: "stable"}, settings: TargetSettings{Arch: "aarch64"}, findings: 1},
+		{name: "target without arch", rule: "arch-mismatch", pkg: pkgArchive{info: map[string][]string{"arch": {"aarch64"}}}, target: Target{Name: "stable"}},
+
+		{name: "package with files", rule: "empty-package", pkg: pkgArchive{files: []pkgFile{{name: "usr", isDir: true}, {name: "usr/bin/foo"}}}},
+		{name: "only directories", rule: "empty-package", pkg: pkgArchive{files: []pkgFile{{name: "usr", isDir: true}}}, findings: 1},
+		{name: "only metadata", rule: "empty-package", pkg: pkgArchive{files: []pkgFile{{name: ".MTREE"}, {name: ".BUILDINFO"}}}, findings: 1},
+
+		{name: "regular permissions", rule: "world-writable", pkg: pkgArchive{files: []pkgFile{{name: "usr/bin/foo", mode: 0755}}}},
+		{name: "world-writable files", rule: "world-writable", pkg: pkgArchive{files: []pkgFile{{name: "a", mode: 0666}, {name: "b", mode: 0777}, {name: "c", mode: 0644}}}, findings: 2},
+		{name: "world-writable directory", rule: "world-writable", pkg: pkgArchive{files: []pkgFile{{name: "var/cache", mode: os.ModeDir | 0777, isDir: true}}}, findings: 1},
+		{name: "sticky directory", rule: "world-writable", pkg: pkgArchive{files: []pkgFile{{name: "var/tmp", mode: os.ModeDir | os.ModeSticky | 0777, isDir: true}}}},
+		{name: "symbolic link", rule: "world-writable", pkg: pkgArchive{files: []pkgFile{{name: "usr/lib/libfoo.so", mode: os.ModeSymlink | 0777, isLink: true}}}},
+
+		{name: "license", rule: "missing-license", pkg: pkgArchive{info: map[string][]string{"license": {"MIT"}}}},
+		{name: "no license", rule: "missing-license", pkg: pkgArchive{info: map[string][]string{}}, findings: 1},
+
+		{name: "files under /usr", rule: "usr-local", pkg: pkgArchive{files: []pkgFile{{name: "usr/bin/foo"}, {name: "usr/localized"}}}},
+		{name: "files under /usr/local", rule: "usr-local", pkg: pkgArchive{files: []pkgFile{{name: "usr/local/bin/foo"}, {name: "usr/local/share/foo"}}}, findings: 2},
+
+		{name: "packager", rule: "unknown-packager", pkg: pkgArchive{info: map[string][]string{"packager": {"Jane Doe <jane@example.org>"}}}},
+		{name: "no packager", rule: "unknown-packager", pkg: pkgArchive{info: map[string][]string{}}, findings: 1},
+		{name: "unknown packager", rule: "unknown-packager", pkg: pkgArchive{info: map[string][]string{"packager": {"Unknown Packager"}}}, findings: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.rule+"/"+test.name, func(t *testing.T) {
+			messages := findLintRule(t, test.rule).check(test.pkg, test.target, test.settings)
+
+			if len(messages) != test.findings {
+				t.Errorf("check() = %q, expected %d finding(s)", messages, test.findings)
+			}
+		})
+	}
+}
+
+func TestLintPackage(t *testing.T) {
+	entries := []tar.Header{
+		{Name: "usr/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "usr/local/bin/foo", Mode: 0777, Size: 4},
+	}
+	path := writeTestPackage(t, "foo-1.0-1-x86_64.pkg.tar.zst", buildTestPackage(t, "zstd", "pkgname = foo\npkgver = 1.0-1\narch = x86_64\n", entries))
+	target := Target{Name: "x86_64"}
+
+	report := lintPackage(path, "foo-1.0-1-x86_64.pkg.tar.zst", target, TargetSettings{})
+	if report.Package != "foo" || report.Version != "1.0-1" {
+		t.Errorf("lintPackage() package = %s %s, expected foo 1.0-1", report.Package, report.Version)
+	}
+	// world-writable and usr-local (errors), missing-license (warning) and
+	// unknown-packager (info)
+	if report.Errors != 2 || report.Warnings != 1 || len(report.Findings) != 4 {
+		t.Errorf("lintPackage() = %d error(s), %d warning(s), %d finding(s), expected 2, 1 and 4", report.Errors, report.Warnings, len(report.Findings))
+	}
+
+	// Severities can be overridden, or rules ignored
+	settings := TargetSettings{Lint: LintSettings{Severities: map[string]string{
+		"world-writable":   lintSeverityWarning,
+		"usr-local":        lintSeverityIgnore,
+		"unknown-packager": lintSeverityError,
+	}}}
+
+	report = lintPackage(path, "foo-1.0-1-x86_64.pkg.tar.zst", target, settings)
+	if report.Errors != 1 || report.Warnings != 2 || len(report.Findings) != 3 {
+		t.Errorf("lintPackage() with severities = %d error(s), %d warning(s), %d finding(s), expected 1, 2 and 3", report.Errors, report.Warnings, len(report.Findings))
+	}
+}
+
+func TestLintPackageInvalid(t *testing.T) {
+	tests := map[string]struct {
+		contents []byte
+		rule     string
+	}{
+		"invalid archive":  {contents: []byte{0x1f, 0x8b, 0x00}, rule: "invalid-archive"},
+		"missing .PKGINFO": {contents: buildTestPackage(t, "gzip", "", testPackageEntries), rule: "missing-pkginfo"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeTestPackage(t, "foo.pkg.tar.gz", test.contents)
+
+			report := lintPackage(path, "foo.pkg.tar.gz", Target{}, TargetSettings{})
+			if report.Errors != 1 || len(report.Findings) != 1 || report.Findings[0].Rule != test.rule {
+				t.Errorf("lintPackage() = %+v, expected a single '%s' error", report, test.rule)
+			}
+		})
+	}
+}
+
+func TestValidateLintSettings(t *testing.T) {
+	tests := []struct {
+		severities map[string]string
+		fails      bool
+	}{
+		{severities: nil},
+		{severities: map[string]string{"missing-license": lintSeverityError, "usr-local": lintSeverityIgnore}},
+		{severities: map[string]string{"no-such-rule": lintSeverityError}, fails: true},
+		{severities: map[string]string{"missing-license": "fatal"}, fails: true},
+	}
+
+	for _, test := range tests {
+		err := validateLintSettings(TargetSettings{Lint: LintSettings{Severities: test.severities}})
+		if (err != nil) != test.fails {
+			t.Errorf("validateLintSettings(%v) error = %v, expected failure: %v", test.severities, err, test.fails)
+		}
+	}
+}
+
+func TestVerifyPackageArchitecture(t *testing.T) {
+	tests := []struct {
+		pkgArch    string
+		targetArch string
+		fails      bool
+	}{
+		{pkgArch: "x86_64", targetArch: "x86_64"},
+		{pkgArch: "any", targetArch: "aarch64"},
+		{pkgArch: "aarch64", targetArch: ""},
+		{pkgArch: "aarch64", targetArch: "x86_64", fails: true},
+		{pkgArch: "", targetArch: "x86_64", fails: true},
+	}
+
+	for _, test := range tests {
+		err := verifyPackageArchitecture(test.pkgArch, test.targetArch)
+		if (err != nil) != test.fails {
+			t.Errorf("verifyPackageArchitecture(%q, %q) error = %v, expected failure: %v", test.pkgArch, test.targetArch, err, test.fails)
+		}
+	}
+}
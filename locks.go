@@ -0,0 +1,297 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//
+//// EXECUTION LOCKS
+//
+
+// Hooks of a repo (and of each target) are run by one process at a time, be it
+// the CLI or the server, so that they do not modify the same files at once.
+// The lock is a file created exclusively in the repo (or target) directory
+// that records its holder.
+
+// Time between attempts to take a lock held by another process
+const lockPollInterval = 250 * time.Millisecond
+
+type lockHolder struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	User      string    `json:"user"`
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+func (holder lockHolder) String() string {
+	if holder.PID <= 0 {
+		return holder.Command
+	}
+
+	return fmt.Sprintf("'%s' (PID %d, user %s on %s, since %s)", holder.Command, holder.PID, holder.User, holder.Host, holder.StartedAt.Local().Format(time.RFC1123))
+}
+
+// Whether the lock at path is stale: its holder is a process of this host that
+// no longer exists. Locks of other hosts (with a shared data directory) are
+// never considered stale. Lock files without a holder (left empty by a crash
+// while being written) are considered stale after a minute.
+func lockIsStale(path string, holder lockHolder) bool {
+	if holder.PID <= 0 {
+		info, err := os.Stat(path)
+		return err == nil && time.Since(info.ModTime()) > time.Minute
+	}
+
+	host, _ := os.Hostname()
+	if holder.Host != host {
+		return false
+	}
+
+	return syscall.Kill(holder.PID, 0) == syscall.ESRCH
+}
+
+func newLockHolder(command string) lockHolder {
+	host, _ := os.Hostname()
+
+	userName := "unknown"
+	if current, err := user.Current(); err == nil {
+		userName = current.Username
+	}
+
+	return lockHolder{
+		PID:       os.Getpid(),
+		Host:      host,
+		User:      userName,
+		Command:   command,
+		StartedAt: time.Now().UTC(),
+	}
+}
+
+// How to handle a lock held by someone else: fail at once, wait until it is
+// released or wait at most timeout.
+type lockMode struct {
+	wait    bool
+	timeout time.Duration
+}
+
+// Parses 'wait', 'fail' or a timeout (e.g. '5m').
+func parseLockMode(value string) (lockMode, error) {
+	switch value {
+	case "wait":
+		return lockMode{wait: true}, nil
+	case "fail":
+		return lockMode{wait: false}, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return lockMode{}, fmt.Errorf("invalid lock mode '%s' (expected 'wait', 'fail' or a timeout such as '5m')", value)
+	}
+
+	return lockMode{wait: true, timeout: timeout}, nil
+}
+
+// Returned when a lock could not be taken because it is held by someone else.
+type lockedError struct {
+	name     string
+	holder   lockHolder
+	timedOut bool
+}
+
+func (err *lockedError) Error() string {
+	if err.timedOut == true {
+		return fmt.Sprintf("timed out waiting for the lock of '%s', held by %s", err.name, err.holder)
+	}
+
+	return fmt.Sprintf("'%s' is locked by %s", err.name, err.holder)
+}
+
+type executionLock struct {
+	path     string
+	contents []byte
+}
+
+// Locks taken by this process, released by finishProgram (as deferred calls
+// do not run when the program exits)
+var heldLocks = struct {
+	mutex sync.Mutex
+	locks map[*executionLock]bool
+}{locks: make(map[*executionLock]bool)}
+
+func repoLockPath(repo Repo) string {
+	return repo.Path + "/.lock"
+}
+
+func targetLockPath(target Target) string {
+	return target.Path + "/.lock"
+}
+
+// Takes the lock at path, named name in messages. If it is held by someone
+// else, waiting is called once with the holder (unless mode is fail-fast).
+// Stale locks (of processes that no longer exist) are removed.
+func acquireLock(ctx context.Context, path string, name string, holder lockHolder, mode lockMode, waiting func(lockHolder)) (*executionLock, error) {
+	contents, err := json.Marshal(holder)
+	if err != nil {
+		return nil, err
+	}
+
+	var deadline <-chan time.Time
+	if mode.timeout > 0 {
+		timer := time.NewTimer(mode.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	notified := false
+
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.Write(contents)
+			file.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock file '%s' -> %v", path, err)
+			}
+
+			lock := &executionLock{path: path, contents: contents}
+
+			heldLocks.mutex.Lock()
+			heldLocks.locks[lock] = true
+			heldLocks.mutex.Unlock()
+
+			return lock, nil
+		} else if os.IsExist(err) == false {
+			return nil, fmt.Errorf("failed to create lock file '%s' -> %v", path, err)
+		}
+
+		current, currentContents, err := readLockHolder(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Released in the meantime
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if lockIsStale(path, current) == true {
+			// Only remove the lock if it was not replaced in the meantime
+			if _, latestContents, err := readLockHolder(path); err == nil && bytes.Equal(latestContents, currentContents) {
+				showAttention(fmt.Sprintf("=> Removing stale lock of '%s' held by %s", name, current), 0)
+				os.Remove(path)
+			}
+			continue
+		}
+
+		if mode.wait == false {
+			return nil, &lockedError{name: name, holder: current}
+		}
+
+		if notified == false && waiting != nil {
+			waiting(current)
+			notified = true
+		}
+
+		select {
+		case <-time.After(lockPollInterval):
+		case <-deadline:
+			return nil, &lockedError{name: name, holder: current, timedOut: true}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func readLockHolder(path string) (lockHolder, []byte, error) {
+	var holder lockHolder
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return holder, nil, err
+	}
+
+	if err := json.Unmarshal(contents, &holder); err != nil || holder.PID <= 0 {
+		holder = lockHolder{Command: "unknown (invalid lock file)"}
+	}
+
+	return holder, contents, nil
+}
+
+// Releases the lock, unless it was removed (as stale) and taken by someone
+// else in the meantime.
+func (lock *executionLock) release() {
+	heldLocks.mutex.Lock()
+	delete(heldLocks.locks, lock)
+	heldLocks.mutex.Unlock()
+
+	if contents, err := os.ReadFile(lock.path); err == nil && bytes.Equal(contents, lock.contents) {
+		os.Remove(lock.path)
+	}
+}
+
+func releaseHeldLocks() {
+	heldLocks.mutex.Lock()
+	locks := make([]*executionLock, 0, len(heldLocks.locks))
+	for lock := range heldLocks.locks {
+		locks = append(locks, lock)
+	}
+	heldLocks.mutex.Unlock()
+
+	for _, lock := range locks {
+		lock.release()
+	}
+}
+
+func getLockModeFromCLI(value string, program Program) (lockMode, functionResponse) {
+	mode, err := parseLockMode(value)
+	if err != nil {
+		return mode, functionResponse{
+			exitCode:    1,
+			message:     formatErrorMessage(err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return mode, functionResponse{
+		exitCode: 0,
+	}
+}
+
+// Takes a lock for the CLI, showing its holder while waiting for it.
+func acquireCLILock(path string, name string, mode lockMode, program Program) (*executionLock, functionResponse) {
+	lock, err := acquireLock(context.Background(), path, name, newLockHolder(commandLine()), mode, func(holder lockHolder) {
+		showAttention(fmt.Sprintf("Waiting for the lock of '%s', held by %s", name, holder), program.indentLevel)
+	})
+	if err != nil {
+		return nil, functionResponse{
+			exitCode:    1,
+			message:     formatErrorMessage(err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return lock, functionResponse{
+		exitCode: 0,
+	}
+}
+
+// Command recorded as the holder of the locks taken by the CLI.
+func commandLine() string {
+	return strings.Join(os.Args, " ")
+}
@@ -0,0 +1,198 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//
+//// EXECUTION LOCKS
+//
+
+func TestParseLockMode(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected lockMode
+		fails    bool
+	}{
+		{value: "wait", expected: lockMode{wait: true}},
+		{value: "fail", expected: lockMode{wait: false}},
+		{value: "5m", expected: lockMode{wait: true, timeout: 5 * time.Minute}},
+		{value: "90s", expected: lockMode{wait: true, timeout: 90 * time.Second}},
+		{value: "1h30m", expected: lockMode{wait: true, timeout: 90 * time.Minute}},
+		{value: "", fails: true},
+		{value: "0s", fails: true},
+		{value: "-5m", fails: true},
+		{value: "5", fails: true},
+		{value: "Wait", fails: true},
+		{value: "forever", fails: true},
+	}
+
+	for _, test := range tests {
+		mode, err := parseLockMode(test.value)
+
+		if test.fails == true {
+			if err == nil {
+				t.Errorf("parseLockMode(%q) = %+v, expected an error", test.value, mode)
+			}
+		} else if err != nil || mode != test.expected {
+			t.Errorf("parseLockMode(%q) = %+v, %v, expected %+v", test.value, mode, err, test.expected)
+		}
+	}
+}
+
+// Returns the PID of a process that has exited.
+func exitedProcessPID(t *testing.T) int {
+	cmd := exec.Command("/bin/true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to run /bin/true -> %v", err)
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestLockIsStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	os.WriteFile(path, []byte{}, 0644)
+
+	host, _ := os.Hostname()
+	deadPID := exitedProcessPID(t)
+
+	tests := []struct {
+		name     string
+		holder   lockHolder
+		age      time.Duration
+		expected bool
+	}{
+		{name: "running process", holder: lockHolder{PID: os.Getpid(), Host: host}},
+		{name: "exited process", holder: lockHolder{PID: deadPID, Host: host}, expected: true},
+		{name: "process of another host", holder: lockHolder{PID: deadPID, Host: host + "-other"}},
+		{name: "new lock without holder", holder: lockHolder{}, age: 10 * time.Second},
+		{name: "old lock without holder", holder: lockHolder{}, age: 2 * time.Minute, expected: true},
+	}
+
+	for _, test := range tests {
+		modTime := time.Now().Add(-test.age)
+		os.Chtimes(path, modTime, modTime)
+
+		if result := lockIsStale(path, test.holder); result != test.expected {
+			t.Errorf("%s: lockIsStale() = %v, expected %v", test.name, result, test.expected)
+		}
+	}
+
+	// A lock without holder that was released in the meantime
+	if lockIsStale(filepath.Join(t.TempDir(), ".lock"), lockHolder{}) == true {
+		t.Error("lockIsStale() of a missing lock without holder should be false")
+	}
+}
+
+func TestReadLockHolder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+
+	for _, contents := range []string{"", "{invalid", `{"pid":0,"command":"x"}`} {
+		os.WriteFile(path, []byte(contents), 0644)
+
+		holder, read, err := readLockHolder(path)
+		if err != nil || holder.PID != 0 || holder.Command != "unknown (invalid lock file)" || string(read) != contents {
+			t.Errorf("readLockHolder(%q) = %+v, %q, %v, expected an unknown holder", contents, holder, read, err)
+		}
+	}
+
+	if _, _, err := readLockHolder(path + ".missing"); errors.Is(err, os.ErrNotExist) == false {
+		t.Errorf("readLockHolder() of a missing file error = %v, expected ErrNotExist", err)
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	holder := newLockHolder("test")
+
+	lock, err := acquireLock(context.Background(), path, "target", holder, lockMode{}, nil)
+	if err != nil {
+		t.Fatalf("acquireLock() error = %v", err)
+	}
+
+	current, _, err := readLockHolder(path)
+	if err != nil || current.PID != os.Getpid() || current.Command != "test" {
+		t.Errorf("lock holder = %+v, %v, expected this process", current, err)
+	}
+
+	// Failing at once
+	var lockedErr *lockedError
+	_, err = acquireLock(context.Background(), path, "target", newLockHolder("other"), lockMode{wait: false}, nil)
+	if errors.As(err, &lockedErr) == false || lockedErr.timedOut == true || lockedErr.holder.Command != "test" {
+		t.Errorf("acquireLock() of a held lock error = %v, expected a lockedError", err)
+	}
+
+	// Waiting with a timeout
+	waited := 0
+	start := time.Now()
+	_, err = acquireLock(context.Background(), path, "target", newLockHolder("other"), lockMode{wait: true, timeout: 600 * time.Millisecond}, func(lockHolder) {
+		waited++
+	})
+	if errors.As(err, &lockedErr) == false || lockedErr.timedOut == false {
+		t.Errorf("acquireLock() with a timeout error = %v, expected a timed out lockedError", err)
+	}
+	if waited != 1 || time.Since(start) < 600*time.Millisecond {
+		t.Errorf("acquireLock() with a timeout called waiting %d time(s) and returned after %v", waited, time.Since(start))
+	}
+
+	// Waiting until canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := acquireLock(ctx, path, "target", newLockHolder("other"), lockMode{wait: true}, nil); errors.Is(err, context.DeadlineExceeded) == false {
+		t.Errorf("acquireLock() with a canceled context error = %v, expected context.DeadlineExceeded", err)
+	}
+
+	// Waiting until released
+	time.AfterFunc(300*time.Millisecond, lock.release)
+	other, err := acquireLock(context.Background(), path, "target", newLockHolder("other"), lockMode{wait: true}, nil)
+	if err != nil {
+		t.Fatalf("acquireLock() after release error = %v", err)
+	}
+
+	// Releasing a lock taken by someone else does nothing
+	lock.release()
+	if current, _, _ := readLockHolder(path); current.Command != "other" {
+		t.Errorf("lock holder after a second release = %+v, expected the other holder", current)
+	}
+
+	other.release()
+	if _, err := os.Stat(path); os.IsNotExist(err) == false {
+		t.Errorf("the lock file should be removed on release (stat error = %v)", err)
+	}
+}
+
+func TestAcquireStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+
+	stale := newLockHolder("crashed")
+	stale.PID = exitedProcessPID(t)
+	staleLock, err := acquireLock(context.Background(), path, "target", stale, lockMode{}, nil)
+	if err != nil {
+		t.Fatalf("acquireLock() error = %v", err)
+	}
+
+	lock, err := acquireLock(context.Background(), path, "target", newLockHolder("test"), lockMode{wait: false}, nil)
+	if err != nil {
+		t.Fatalf("acquireLock() of a stale lock error = %v, expected the lock to be taken", err)
+	}
+
+	// The crashed holder cannot release the new lock
+	staleLock.release()
+	if current, _, _ := readLockHolder(path); current.Command != "test" {
+		t.Errorf("lock holder = %+v, expected the new holder", current)
+	}
+
+	lock.release()
+}
@@ -0,0 +1,110 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+
+	// External modules
+	gin "github.com/gin-gonic/gin"
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+//
+//// CLIENT CERTIFICATES (mTLS)
+//
+
+// Maps the subject of client certificates (e.g. 'CN=builder-01,O=Example')
+// to the targets and actions they can use, with the same patterns as tokens.
+type apiClient struct {
+	Subject string   `toml:"subject"`
+	Targets []string `toml:"targets"`
+	Actions []string `toml:"actions"`
+	// Admin clients can also attach a terminal to running jobs
+	Admin bool `toml:"admin"`
+}
+
+type apiClientsFile struct {
+	Clients []apiClient `toml:"client"`
+}
+
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle -> %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if pool.AppendCertsFromPEM(contents) == false {
+		return nil, fmt.Errorf("no certificates found in client CA bundle '%s'", path)
+	}
+
+	return pool, nil
+}
+
+func loadAPIClients(program Program) ([]apiClient, error) {
+	var file apiClientsFile
+
+	contents, err := os.ReadFile(program.clientsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read clients file -> %v", err)
+	}
+
+	err = toml.Unmarshal(contents, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse clients file '%s' -> %v", program.clientsPath, err)
+	}
+
+	for _, client := range file.Clients {
+		if _, err := path.Match(client.Subject, ""); err != nil || client.Subject == "" {
+			return nil, fmt.Errorf("invalid subject '%s' in clients file", client.Subject)
+		}
+
+		err = validateAPIScopes(client.Targets, client.Actions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scopes for subject '%s' in clients file -> %v", client.Subject, err)
+		}
+	}
+
+	return file.Clients, nil
+}
+
+// Returns the credential of the client certificate of the request. The
+// certificate was already verified against the CA bundle during the TLS
+// handshake; its subject must also match a client in the clients file (the
+// first matching client is used).
+func getClientCertificateCredential(c *gin.Context, program Program) (apiCredential, error) {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+		return apiCredential{}, &apiRejection{status: http.StatusUnauthorized, reason: "missing client certificate"}
+	}
+
+	certificate := c.Request.TLS.VerifiedChains[0][0]
+	subject := certificate.Subject.String()
+
+	clients, err := loadAPIClients(program)
+	if err != nil {
+		return apiCredential{}, err
+	}
+
+	for _, client := range clients {
+		if matched, _ := path.Match(client.Subject, subject); matched == true {
+			return apiCredential{
+				identity: fmt.Sprintf("client certificate '%s' (serial %s)", subject, certificate.SerialNumber.Text(16)),
+				targets:  client.Targets,
+				actions:  client.Actions,
+				admin:    client.Admin,
+			}, nil
+		}
+	}
+
+	return apiCredential{}, &apiRejection{status: http.StatusForbidden, reason: fmt.Sprintf("client certificate '%s' is not mapped in the clients file", subject)}
+}
@@ -0,0 +1,135 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	// External modules
+	gin "github.com/gin-gonic/gin"
+)
+
+//
+//// NETWORK ALLOWLISTS
+//
+
+// Networks allowed to download from or use the API of a repo or target, built
+// from the 'network' section of its configuration file. The client address is
+// the one detected by gin, which only trusts the X-Forwarded-For header of the
+// trusted proxies ('--proxy' flag).
+type networkPolicy struct {
+	// All networks are allowed if empty
+	download []*net.IPNet
+	api      []*net.IPNet
+	// Set if the configuration file could not be read or has invalid networks,
+	// in which case no address is allowed
+	denied bool
+}
+
+func newNetworkPolicy(settings NetworkSettings, err error) networkPolicy {
+	if err != nil {
+		return networkPolicy{denied: true}
+	}
+
+	download, err := parseNetworks(settings.DownloadAllow)
+	if err != nil {
+		return networkPolicy{denied: true}
+	}
+
+	api, err := parseNetworks(settings.APIAllow)
+	if err != nil {
+		return networkPolicy{denied: true}
+	}
+
+	return networkPolicy{
+		download: download,
+		api:      api,
+	}
+}
+
+// Parses CIDR blocks and single IP addresses.
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, value := range values {
+		if strings.Contains(value, "/") == false {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address '%s'", value)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network '%s' -> %v", value, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func (policy networkPolicy) allows(ip net.IP, api bool) bool {
+	if policy.denied == true || ip == nil {
+		return false
+	}
+
+	networks := policy.download
+	if api == true {
+		networks = policy.api
+	}
+
+	if len(networks) == 0 {
+		return true
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+//// NETWORK ALLOWLISTS (SERVER FUNCTIONALITY)
+//
+
+// Verifies that the client address is allowed to download from (or, if api is
+// true, to use the API of) the repo and, if targetName is not empty, the
+// target. Responds with 403 and returns false otherwise.
+func authorizeAddress(c *gin.Context, index *serverIndex, repoName string, targetName string, api bool, program Program) bool {
+	if index.allowsAddress(net.ParseIP(c.ClientIP()), repoName, targetName, api) == true {
+		return true
+	}
+
+	kind := "download from"
+	if api == true {
+		kind = "use the API of"
+	}
+
+	logSecurityEvent(c, fmt.Sprintf("address %s is not allowed to %s '%s'", c.ClientIP(), kind, strings.TrimSuffix(repoName+"/"+targetName, "/")), program)
+
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"message": "Forbidden: your address is not allowed to access this resource",
+	})
+
+	return false
+}
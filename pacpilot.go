@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	// External modules
 	cobra "github.com/spf13/cobra"
@@ -21,6 +22,9 @@ import (
 //
 
 func main() {
+	// Hooks with resource limits are started through the program itself
+	runHookLimitsShim()
+
 	var program Program
 	var dataDir string
 
@@ -386,10 +390,24 @@ func main() {
 	var notCreateTempDir bool
 	var notRemoveTempDir bool
 	var notPrintOutput bool
+	var lockModeValue string
+	var hookTimeout time.Duration
+	var hookCPULimit time.Duration
+	var hookMemoryLimit string
+	var hookFilesLimit uint64
+	var hookEnvValues []string
+	var keepGoing bool
+	var repoPostOnSuccess bool
+	var parallelTargets int
+	var parallelOutput string
 
 	var repoPreHooks []string
 	var repoPostHooks []string
 
+	var targetHooksNames []string
+	var targetNames []string
+	var allTargets bool
+
 	//
 	//// REPOS
 	//
@@ -458,21 +476,97 @@ func main() {
 	var serverPort string
 	var debugMode bool
 	var trustedProxies []string
+	var tlsCertPath string
+	var tlsKeyPath string
+	var httpRedirectPort string
+	var clientCAPath string
+	var requestRate float64
+	var requestBurst int
+	var bandwidthLimit string
+	var clientBandwidthLimit string
+	var clientDownloads int
 
 	var reposServeCmd = &cobra.Command{
 		Use:   "serve",
 		Short: "Serve repos",
 		Run: func(cmd *cobra.Command, args []string) {
-			response := reposServe(serverPort, debugMode, trustedProxies, program)
+			tlsOptions := serverTLS{
+				certPath:     tlsCertPath,
+				keyPath:      tlsKeyPath,
+				redirectPort: httpRedirectPort,
+				clientCAPath: clientCAPath,
+			}
+
+			bandwidth, err := parseByteRate(bandwidthLimit)
+			var clientBandwidth int64
+			if err == nil {
+				clientBandwidth, err = parseByteRate(clientBandwidthLimit)
+			}
+			if err != nil {
+				handleFunctionResponse(functionResponse{
+					exitCode:    1,
+					message:     formatErrorMessage(err),
+					logLevel:    "error",
+					indentLevel: program.indentLevel,
+				}, true)
+			}
+
+			limits := serverLimits{
+				requestRate:     requestRate,
+				requestBurst:    requestBurst,
+				bandwidth:       bandwidth,
+				clientBandwidth: clientBandwidth,
+				clientDownloads: clientDownloads,
+			}
+
+			response := reposServe(serverPort, debugMode, trustedProxies, tlsOptions, limits, program)
 			handleFunctionResponse(response, true)
 		},
 	}
 
 	reposServeCmd.Flags().StringVarP(&serverPort, "port", "p", "8080", "Server port")
-	reposServeCmd.Flags().StringSliceVarP(&trustedProxies, "proxy", "x", nil, "Trusted proxy (by default, all proxies are trusted)")
+	reposServeCmd.Flags().StringSliceVarP(&trustedProxies, "proxy", "x", nil, "Trusted proxy, whose X-Forwarded-For header is used as the client address (by default, no proxy is trusted)")
 	reposServeCmd.Flags().BoolVarP(&debugMode, "debug", "d", false, "Debug mode")
+	reposServeCmd.Flags().StringVarP(&tlsCertPath, "tls-cert", "", "", "TLS certificate file (enables HTTPS; reloaded on SIGHUP or when changed)")
+	reposServeCmd.Flags().StringVarP(&tlsKeyPath, "tls-key", "", "", "TLS private key file")
+	reposServeCmd.Flags().StringVarP(&httpRedirectPort, "http-redirect", "", "", "Port of a plain HTTP listener redirecting to HTTPS")
+	reposServeCmd.Flags().StringVarP(&clientCAPath, "client-ca", "", "", "CA bundle for client certificates (requires them on API routes instead of tokens)")
+	reposServeCmd.Flags().Float64VarP(&requestRate, "rate-limit", "", 0, "Requests per second allowed for each client address (0 for no limit)")
+	reposServeCmd.Flags().IntVarP(&requestBurst, "rate-burst", "", 20, "Requests a client address can make at once before being rate limited")
+	reposServeCmd.Flags().StringVarP(&bandwidthLimit, "bandwidth", "", "", "Bandwidth for all file downloads, in bytes per second with an optional K, M or G suffix (e.g. '10M')")
+	reposServeCmd.Flags().StringVarP(&clientBandwidthLimit, "client-bandwidth", "", "", "Bandwidth for the file downloads of each client address (e.g. '1M')")
+	reposServeCmd.Flags().IntVarP(&clientDownloads, "max-downloads", "", 0, "Concurrent file downloads allowed for each client address (0 for no limit)")
 	reposServeCmd.Flags().SetInterspersed(false)
 
+	var clientConfigURL string
+	var clientConfigMirrorlist bool
+
+	var reposClientConfigCmd = &cobra.Command{
+		Use:   "client-config",
+		Short: "Generate the pacman.conf section (or mirrorlist) for a target",
+		Long: `The 'client-config' command generates a ready-to-use pacman.conf
+		section for a target. The Server URL is built from the 'public_urls'
+		setting of the repo's config.toml file (or from the '--url/-u' flag) and
+		the SigLevel from the repo's signing setup. When several public URLs are
+		configured, the section includes a mirrorlist, which can be printed with
+		the '--mirrorlist/-m' flag.`,
+		Example: "repos client-config --repo myrepo --target x86_64 --url https://example.com",
+		Run: func(cmd *cobra.Command, args []string) {
+			repo, selectedTargets, response := getSelectedTargetsFromCLI(repoName, targetNames, false, interactiveSelection, false, program)
+			handleFunctionResponse(response, true)
+
+			response = reposClientConfig(repo, selectedTargets, clientConfigURL, clientConfigMirrorlist, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	reposClientConfigCmd.Flags().StringVarP(&repoName, "repo", "r", "", "Repo name")
+	reposClientConfigCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target name")
+	reposClientConfigCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	reposClientConfigCmd.Flags().StringVarP(&clientConfigURL, "url", "u", "", "Server base URL (used when no public URLs are configured)")
+	reposClientConfigCmd.Flags().BoolVarP(&clientConfigMirrorlist, "mirrorlist", "m", false, "Print the mirrorlist instead of the pacman.conf section")
+	reposClientConfigCmd.Flags().SetInterspersed(false)
+
 	var reposEnableCmd = &cobra.Command{
 		Use:   "enable",
 		Short: "Enable repos",
@@ -530,7 +624,7 @@ func main() {
 	reposHooksLsCmd.Flags().SetInterspersed(false)
 
 	var reposHooksRunCmd = &cobra.Command{
-		Use:   "run",
+		Use:   "run [-- hook arguments]",
 		Short: "Run repo hook(s)",
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(repoHooksNames) == 0 {
@@ -543,10 +637,25 @@ func main() {
 				handleFunctionResponse(response, true)
 			}
 
+			lock, response := getLockModeFromCLI(lockModeValue, program)
+			handleFunctionResponse(response, true)
+
+			program.hookLimits, response = getHookLimitsFromCLI(hookTimeout, hookCPULimit, hookMemoryLimit, hookFilesLimit, program)
+			handleFunctionResponse(response, true)
+
+			hookEnv, response := getHookEnvFromCLI(hookEnvValues, program)
+			handleFunctionResponse(response, true)
+
+			// Arguments after '--' are given to the hooks
+			var hookArgs []string
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				hookArgs = args[dash:]
+			}
+
 			selectedRepos, response := getSelectedReposFromCLI(repoNames, allRepos, interactiveSelection, true, program)
 			handleFunctionResponse(response, true)
 
-			response = reposRunHooks(selectedRepos, repoHooksNames, notCreateTempDir, notRemoveTempDir, notPrintOutput, false, true, program)
+			response = reposRunHooks(selectedRepos, repoHooksNames, notCreateTempDir, notRemoveTempDir, notPrintOutput, false, true, lock, hookArgs, hookEnv, program)
 			handleFunctionResponse(response, true)
 		},
 	}
@@ -558,16 +667,18 @@ func main() {
 	reposHooksRunCmd.Flags().BoolVarP(&notCreateTempDir, "nocreatetemp", "", false, "Do not create the temporary directory before running the hook(s) (by default, it is created)")
 	reposHooksRunCmd.Flags().BoolVarP(&notRemoveTempDir, "noremovetemp", "", false, "Do not remove the temporary directory after the hook(s) has/have finished running (by default, it is removed)")
 	reposHooksRunCmd.Flags().BoolVarP(&notPrintOutput, "quiet", "q", false, "Do not print command output (silent)")
+	reposHooksRunCmd.Flags().StringVarP(&lockModeValue, "lock", "", "wait", "What to do when the repo or a target is locked by another run: 'wait', 'fail' or wait at most a timeout (e.g. '5m')")
+	reposHooksRunCmd.Flags().DurationVarP(&hookTimeout, "timeout", "", 0, "Time after which each hook is terminated (e.g. '30m'; overrides the hook manifest)")
+	reposHooksRunCmd.Flags().DurationVarP(&hookCPULimit, "cpu-limit", "", 0, "CPU time limit of each hook process (e.g. '10m'; overrides the hook manifest)")
+	reposHooksRunCmd.Flags().StringVarP(&hookMemoryLimit, "memory-limit", "", "", "Memory (address space) limit of each hook process, in bytes with an optional K, M or G suffix (e.g. '2G'; overrides the hook manifest)")
+	reposHooksRunCmd.Flags().Uint64VarP(&hookFilesLimit, "files-limit", "", 0, "Open files limit of each hook process (overrides the hook manifest)")
+	reposHooksRunCmd.Flags().StringArrayVarP(&hookEnvValues, "env", "e", nil, "Environment variable given to the hook(s), as KEY=VALUE (can be repeated)")
 	reposHooksRunCmd.Flags().SetInterspersed(false)
 
 	//
 	//// TARGETS
 	//
 
-	var targetHooksNames []string
-	var targetNames []string
-	var allTargets bool
-
 	var targetsCmd = &cobra.Command{
 		Use:   "targets",
 		Short: "Manage targets",
@@ -610,10 +721,19 @@ func main() {
 		Use:   "update",
 		Short: "Update targets",
 		Run: func(cmd *cobra.Command, args []string) {
+			lock, response := getLockModeFromCLI(lockModeValue, program)
+			handleFunctionResponse(response, true)
+
+			parallel, response := getParallelismFromCLI(parallelTargets, parallelOutput, program)
+			handleFunctionResponse(response, true)
+
+			program.hookLimits, response = getHookLimitsFromCLI(hookTimeout, hookCPULimit, hookMemoryLimit, hookFilesLimit, program)
+			handleFunctionResponse(response, true)
+
 			repo, selectedTargets, response := getSelectedTargetsFromCLI(repoName, targetNames, allTargets, interactiveSelection, true, program)
 			handleFunctionResponse(response, true)
 
-			response = targetsUpdate(repo, selectedTargets, repoPreHooks, repoPostHooks, program)
+			response = targetsUpdate(repo, selectedTargets, repoPreHooks, repoPostHooks, lock, keepGoing, repoPostOnSuccess, parallel, program)
 			handleFunctionResponse(response, true)
 		},
 	}
@@ -624,6 +744,15 @@ func main() {
 	targetsUpdateCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
 	targetsUpdateCmd.Flags().StringSliceVarP(&repoPreHooks, "repopre", "", nil, "Repo pre hook(s)")
 	targetsUpdateCmd.Flags().StringSliceVarP(&repoPostHooks, "repopost", "", nil, "Repo post hook(s)")
+	targetsUpdateCmd.Flags().BoolVarP(&keepGoing, "keep-going", "", false, "Keep running the hook(s) of the other targets when a target fails, and show a summary at the end")
+	targetsUpdateCmd.Flags().BoolVarP(&repoPostOnSuccess, "repopost-on-success", "", false, "Only run the repo post hook(s) if the hook(s) of all targets succeeded (with --keep-going)")
+	targetsUpdateCmd.Flags().IntVarP(&parallelTargets, "parallel", "", 1, "Number of targets whose hook(s) run at the same time (hooks cannot read input from the terminal when greater than 1)")
+	targetsUpdateCmd.Flags().StringVarP(&parallelOutput, "parallel-output", "", "prefix", "Output of the targets run in parallel: 'prefix' (each line prefixed with the target name) or 'buffer' (all lines of a target once it has finished)")
+	targetsUpdateCmd.Flags().StringVarP(&lockModeValue, "lock", "", "wait", "What to do when the repo or a target is locked by another run: 'wait', 'fail' or wait at most a timeout (e.g. '5m')")
+	targetsUpdateCmd.Flags().DurationVarP(&hookTimeout, "timeout", "", 0, "Time after which each hook is terminated (e.g. '30m'; overrides the hook manifest)")
+	targetsUpdateCmd.Flags().DurationVarP(&hookCPULimit, "cpu-limit", "", 0, "CPU time limit of each hook process (e.g. '10m'; overrides the hook manifest)")
+	targetsUpdateCmd.Flags().StringVarP(&hookMemoryLimit, "memory-limit", "", "", "Memory (address space) limit of each hook process, in bytes with an optional K, M or G suffix (e.g. '2G'; overrides the hook manifest)")
+	targetsUpdateCmd.Flags().Uint64VarP(&hookFilesLimit, "files-limit", "", 0, "Open files limit of each hook process (overrides the hook manifest)")
 	targetsUpdateCmd.Flags().SetInterspersed(false)
 
 	var targetsEnableCmd = &cobra.Command{
@@ -713,7 +842,7 @@ func main() {
 	targetsHooksLsCmd.Flags().SetInterspersed(false)
 
 	var targetsHooksRunCmd = &cobra.Command{
-		Use:   "run",
+		Use:   "run [-- hook arguments]",
 		Short: "Run target hook(s)",
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(targetHooksNames) == 0 {
@@ -726,10 +855,28 @@ func main() {
 				handleFunctionResponse(response, true)
 			}
 
+			lock, response := getLockModeFromCLI(lockModeValue, program)
+			handleFunctionResponse(response, true)
+
+			parallel, response := getParallelismFromCLI(parallelTargets, parallelOutput, program)
+			handleFunctionResponse(response, true)
+
+			program.hookLimits, response = getHookLimitsFromCLI(hookTimeout, hookCPULimit, hookMemoryLimit, hookFilesLimit, program)
+			handleFunctionResponse(response, true)
+
+			hookEnv, response := getHookEnvFromCLI(hookEnvValues, program)
+			handleFunctionResponse(response, true)
+
+			// Arguments after '--' are given to the hooks
+			var hookArgs []string
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				hookArgs = args[dash:]
+			}
+
 			repo, selectedTargets, response := getSelectedTargetsFromCLI(repoName, targetNames, allTargets, interactiveSelection, true, program)
 			handleFunctionResponse(response, true)
 
-			response = targetsRunHooks(repo, selectedTargets, targetHooksNames, repoPreHooks, repoPostHooks, notCreateTempDir, notRemoveTempDir, notPrintOutput, false, true, program)
+			response = targetsRunHooks(repo, selectedTargets, targetHooksNames, repoPreHooks, repoPostHooks, notCreateTempDir, notRemoveTempDir, notPrintOutput, false, true, lock, hookArgs, hookEnv, keepGoing, repoPostOnSuccess, parallel, program)
 			handleFunctionResponse(response, true)
 		},
 	}
@@ -744,11 +891,149 @@ func main() {
 	targetsHooksRunCmd.Flags().StringSliceVarP(&repoPreHooks, "repopre", "", nil, "Repo pre hook(s)")
 	targetsHooksRunCmd.Flags().StringSliceVarP(&repoPostHooks, "repopost", "", nil, "Repo post hook(s)")
 	targetsHooksRunCmd.Flags().BoolVarP(&notPrintOutput, "quiet", "q", false, "Do not print command output (silent)")
+	targetsHooksRunCmd.Flags().BoolVarP(&keepGoing, "keep-going", "", false, "Keep running the hook(s) of the other targets when a target fails, and show a summary at the end")
+	targetsHooksRunCmd.Flags().BoolVarP(&repoPostOnSuccess, "repopost-on-success", "", false, "Only run the repo post hook(s) if the hook(s) of all targets succeeded (with --keep-going)")
+	targetsHooksRunCmd.Flags().IntVarP(&parallelTargets, "parallel", "", 1, "Number of targets whose hook(s) run at the same time (hooks cannot read input from the terminal when greater than 1)")
+	targetsHooksRunCmd.Flags().StringVarP(&parallelOutput, "parallel-output", "", "prefix", "Output of the targets run in parallel: 'prefix' (each line prefixed with the target name) or 'buffer' (all lines of a target once it has finished)")
+	targetsHooksRunCmd.Flags().StringVarP(&lockModeValue, "lock", "", "wait", "What to do when the repo or a target is locked by another run: 'wait', 'fail' or wait at most a timeout (e.g. '5m')")
+	targetsHooksRunCmd.Flags().DurationVarP(&hookTimeout, "timeout", "", 0, "Time after which each hook is terminated (e.g. '30m'; overrides the hook manifest)")
+	targetsHooksRunCmd.Flags().DurationVarP(&hookCPULimit, "cpu-limit", "", 0, "CPU time limit of each hook process (e.g. '10m'; overrides the hook manifest)")
+	targetsHooksRunCmd.Flags().StringVarP(&hookMemoryLimit, "memory-limit", "", "", "Memory (address space) limit of each hook process, in bytes with an optional K, M or G suffix (e.g. '2G'; overrides the hook manifest)")
+	targetsHooksRunCmd.Flags().Uint64VarP(&hookFilesLimit, "files-limit", "", 0, "Open files limit of each hook process (overrides the hook manifest)")
+	targetsHooksRunCmd.Flags().StringArrayVarP(&hookEnvValues, "env", "e", nil, "Environment variable given to the hook(s), as KEY=VALUE (can be repeated)")
 	targetsHooksRunCmd.Flags().SetInterspersed(false)
 
+	var targetsPkgsCmd = &cobra.Command{
+		Use:   "pkgs",
+		Short: "Manage target packages",
+	}
+
+	var lintJSONOutput bool
+
+	var targetsPkgsLintCmd = &cobra.Command{
+		Use:   "lint [package...]",
+		Short: "Run lint checks on target packages",
+		Long: `The 'lint' command runs a set of checks against the packages in the
+		target's pool directory (or only against the given package files, relative
+		to the pool directory). Rule severities can be changed per target in the
+		[lint.severities] section of the target's config.toml file.`,
+		Example: "targets pkgs lint --repo myrepo --target x86_64 --json",
+		Run: func(cmd *cobra.Command, args []string) {
+			repo, selectedTargets, response := getSelectedTargetsFromCLI(repoName, targetNames, allTargets, interactiveSelection, true, program)
+			handleFunctionResponse(response, true)
+
+			response = targetsPkgsLint(repo, selectedTargets, args, lintJSONOutput, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	targetsPkgsLintCmd.Flags().StringVarP(&repoName, "repo", "r", "", "Repo name")
+	targetsPkgsLintCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s)")
+	targetsPkgsLintCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
+	targetsPkgsLintCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsPkgsLintCmd.Flags().BoolVarP(&lintJSONOutput, "json", "j", false, "Print findings as JSON")
+	targetsPkgsLintCmd.Flags().SetInterspersed(false)
+
+	var tokenName string
+	var tokenTargets []string
+	var tokenActions []string
+	var tokenAdmin bool
+
+	var tokensCmd = &cobra.Command{
+		Use:   "tokens",
+		Short: "Manage API tokens",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if dataDir == "" {
+				return errors.New("A data directory should be specified using the '-D' flag")
+			}
+
+			showAttention(salmonPink.Sprintf("Running %v using data directory at: %v", program.name, dataDir), program.indentLevel)
+			space()
+
+			program = initializeDefaultProgram(dataDir)
+
+			// Verify user data directory
+			response := verifyDataDirectory(true, program)
+			handleFunctionResponse(response, true)
+
+			return nil
+		},
+	}
+
+	var tokensCreateCmd = &cobra.Command{
+		Use:   "create",
+		Short: "Create an API token",
+		Long: `The 'create' command creates a token for the server's API and prints
+		it (only its hash is stored). The token is scoped to targets, given as
+		'<repo>/<target>' patterns, and to actions, given as the names used in
+		the API route ('upload' or the name of a hook). Both accept glob
+		patterns, such as 'myrepo/*' or '*'.`,
+		Example: "tokens create --name ci --target 'myrepo/*' --action upload --action promote",
+		Run: func(cmd *cobra.Command, args []string) {
+			response := tokensCreate(tokenName, tokenTargets, tokenActions, tokenAdmin, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	tokensCreateCmd.Flags().StringVarP(&tokenName, "name", "n", "", "Token name (description)")
+	tokensCreateCmd.Flags().StringSliceVarP(&tokenTargets, "target", "t", nil, "Target(s) allowed, as '<repo>/<target>' patterns")
+	tokensCreateCmd.Flags().StringSliceVarP(&tokenActions, "action", "c", nil, "Action(s) allowed ('upload' or hook names)")
+	tokensCreateCmd.Flags().BoolVarP(&tokenAdmin, "admin", "", false, "Allow attaching a terminal to running jobs of the allowed targets and actions")
+	tokensCreateCmd.Flags().SetInterspersed(false)
+
+	var tokensLsCmd = &cobra.Command{
+		Use:   "ls",
+		Short: "List API tokens",
+		Run: func(cmd *cobra.Command, args []string) {
+			response := tokensLs(program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	var tokensRevokeCmd = &cobra.Command{
+		Use:   "revoke <id>...",
+		Short: "Revoke API tokens",
+		Run: func(cmd *cobra.Command, args []string) {
+			response := tokensRevoke(args, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	var certsCmd = &cobra.Command{
+		Use:   "certs",
+		Short: "Manage TLS certificates",
+	}
+
+	var certHosts []string
+	var certValidDays int
+	var certPath string
+	var certKeyPath string
+
+	var certsSelfSignedCmd = &cobra.Command{
+		Use:   "selfsigned",
+		Short: "Create a self-signed certificate",
+		Long: `The 'selfsigned' command creates a self-signed certificate and its
+		private key, to be used with the '--tls-cert' and '--tls-key' flags of
+		'repos serve' in internal deployments. Clients must trust the
+		certificate explicitly.`,
+		Example: "certs selfsigned --host repo.internal --host 10.0.0.5 --cert server.crt --key server.key",
+		Run: func(cmd *cobra.Command, args []string) {
+			response := certsSelfSigned(certHosts, certValidDays, certPath, certKeyPath, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	certsSelfSignedCmd.Flags().StringSliceVarP(&certHosts, "host", "H", []string{"localhost"}, "Host name(s) or IP address(es) of the server")
+	certsSelfSignedCmd.Flags().IntVarP(&certValidDays, "days", "", 365, "Validity of the certificate in days")
+	certsSelfSignedCmd.Flags().StringVarP(&certPath, "cert", "c", "cert.pem", "Certificate file to create")
+	certsSelfSignedCmd.Flags().StringVarP(&certKeyPath, "key", "k", "key.pem", "Private key file to create")
+	certsSelfSignedCmd.Flags().SetInterspersed(false)
+
 	// Add Cobra commands
 	rootCmd.AddCommand(reposCmd)
 	rootCmd.AddCommand(targetsCmd)
+	rootCmd.AddCommand(tokensCmd)
+	rootCmd.AddCommand(certsCmd)
 	rootCmd.AddCommand(utilitiesCmd)
 	rootCmd.AddCommand(showVersionCmd)
 	rootCmd.AddCommand(userInitCmd)
@@ -772,6 +1057,7 @@ func main() {
 	reposCmd.AddCommand(reposRmCmd)
 	reposCmd.AddCommand(reposLsCmd)
 	reposCmd.AddCommand(reposServeCmd)
+	reposCmd.AddCommand(reposClientConfigCmd)
 	reposCmd.AddCommand(reposEnableCmd)
 	reposCmd.AddCommand(reposDisableCmd)
 	reposCmd.AddCommand(reposHooksCmd)
@@ -786,10 +1072,19 @@ func main() {
 	targetsCmd.AddCommand(targetsRmCmd)
 	targetsCmd.AddCommand(targetsLsCmd)
 	targetsCmd.AddCommand(targetsHooksCmd)
+	targetsCmd.AddCommand(targetsPkgsCmd)
 
 	targetsHooksCmd.AddCommand(targetsHooksRunCmd)
 	targetsHooksCmd.AddCommand(targetsHooksLsCmd)
 
+	targetsPkgsCmd.AddCommand(targetsPkgsLintCmd)
+
+	tokensCmd.AddCommand(tokensCreateCmd)
+	tokensCmd.AddCommand(tokensLsCmd)
+	tokensCmd.AddCommand(tokensRevokeCmd)
+
+	certsCmd.AddCommand(certsSelfSignedCmd)
+
 	if err := rootCmd.Execute(); err != nil {
 		showError("Error: "+err.Error(), program.indentLevel)
 		finishProgram(1)
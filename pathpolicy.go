@@ -0,0 +1,180 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	// External modules
+	gin "github.com/gin-gonic/gin"
+)
+
+//
+//// PATH CONTAINMENT
+//
+
+// Returned when a path or file name derived from request input is rejected.
+// Violations are answered with 400 and logged as security events (see
+// respondWithError).
+type pathViolation struct {
+	reason string
+}
+
+func (violation *pathViolation) Error() string {
+	return violation.reason
+}
+
+// Returns the full path of a resource requested relative to root. The path
+// must not contain parent directory references, hidden components or null
+// bytes, and must not resolve (following symbolic links) outside of root.
+func containPath(root string, resourcePath string) (string, error) {
+	if strings.ContainsRune(resourcePath, 0) {
+		return "", &pathViolation{reason: "path contains a null byte"}
+	}
+
+	for _, component := range strings.Split(resourcePath, "/") {
+		if component == ".." {
+			return "", &pathViolation{reason: fmt.Sprintf("path '%s' contains a parent directory reference", resourcePath)}
+		} else if strings.HasPrefix(component, ".") {
+			return "", &pathViolation{reason: fmt.Sprintf("path '%s' contains a hidden component", resourcePath)}
+		}
+	}
+
+	fullPath := filepath.Join(root, resourcePath)
+	if isPathInside(root, fullPath) == false {
+		return "", &pathViolation{reason: fmt.Sprintf("path '%s' is outside of the served directory", resourcePath)}
+	}
+
+	// Symbolic links inside root must not point outside of it
+	resolvedPath, err := resolvePath(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve path '%s' -> %w", resourcePath, err)
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve directory '%s' -> %w", root, err)
+	}
+
+	if isPathInside(resolvedRoot, resolvedPath) == false {
+		return "", &pathViolation{reason: fmt.Sprintf("path '%s' resolves outside of the served directory", resourcePath)}
+	}
+
+	return fullPath, nil
+}
+
+// Returns the path with its symbolic links resolved. Paths that do not exist
+// yet (e.g. upload destinations) are resolved as they would be when created:
+// through their nearest existing parent directory, or the target of a
+// dangling symbolic link.
+func resolvePath(path string) (string, error) {
+	resolvedPath, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolvedPath, nil
+	} else if errors.Is(err, fs.ErrNotExist) == false {
+		return "", err
+	}
+
+	if target, err := os.Readlink(path); err == nil {
+		if filepath.IsAbs(target) == false {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+
+		return resolvePath(target)
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+
+	resolvedParent, err := resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(resolvedParent, filepath.Base(path)), nil
+}
+
+func isPathInside(root string, path string) bool {
+	relativePath, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return relativePath != ".." && strings.HasPrefix(relativePath, "../") == false
+}
+
+// Verifies that the name sent by the client (a file name, hook name, etc.) is
+// a single, non-hidden path component without control characters.
+func validateFileName(name string) error {
+	if name == "" {
+		return &pathViolation{reason: "name is empty"}
+	}
+
+	if strings.ContainsAny(name, "/\\") {
+		return &pathViolation{reason: fmt.Sprintf("name '%s' contains a path separator", name)}
+	}
+
+	if strings.HasPrefix(name, ".") {
+		return &pathViolation{reason: fmt.Sprintf("name '%s' refers to a hidden or relative path", name)}
+	}
+
+	for _, char := range name {
+		if unicode.IsControl(char) {
+			return &pathViolation{reason: fmt.Sprintf("name %q contains a control character", name)}
+		}
+	}
+
+	return nil
+}
+
+//
+//// FILENAME POLICY
+//
+
+// Verifies that the file name matches one of the patterns allowed by the
+// target's upload policy. All (valid) file names are allowed if no pattern is
+// set.
+func checkUploadPolicy(settings UploadSettings, name string) error {
+	if len(settings.AllowedFiles) == 0 {
+		return nil
+	}
+
+	for _, pattern := range settings.AllowedFiles {
+		if matched, _ := filepath.Match(pattern, name); matched == true {
+			return nil
+		}
+	}
+
+	return &pathViolation{reason: fmt.Sprintf("file '%s' is not allowed by the target's upload policy", name)}
+}
+
+func validateUploadSettings(settings TargetSettings) error {
+	for _, pattern := range settings.Uploads.AllowedFiles {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s' in 'uploads.allowed_files' -> %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+//
+//// SECURITY EVENTS
+//
+
+// Logs a request rejected for security reasons, along with the client's
+// address.
+func logSecurityEvent(c *gin.Context, reason string, program Program) {
+	showError(fmt.Sprintf("=> Security event: rejected request '%s %s' from %s -> %s", c.Request.Method, c.Request.URL.Path, c.ClientIP(), reason), program.indentLevel)
+}
@@ -0,0 +1,220 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//
+//// PATH CONTAINMENT
+//
+
+// Returns a served directory with a file, a subdirectory and symbolic links
+// pointing inside and outside of it.
+func setupContainmentRoot(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir() + "/root"
+	outside := t.TempDir()
+
+	for _, dir := range []string{root + "/sub", outside + "/secrets"} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, file := range []string{root + "/file", root + "/sub/file", outside + "/secret"} {
+		if err := os.WriteFile(file, []byte("contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		root + "/inside-link":      "file",
+		root + "/sub/parent-link":  "../file",
+		root + "/outside-link":     outside + "/secret",
+		root + "/outside-dir":      outside + "/secrets",
+		root + "/relative-escape":  "../../" + filepath.Base(outside) + "/secret",
+		root + "/dangling-inside":  "new-file",
+		root + "/dangling-outside": outside + "/new-file",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestContainPath(t *testing.T) {
+	root := setupContainmentRoot(t)
+
+	tests := []struct {
+		path string
+		// Whether the path is rejected as a violation
+		violation bool
+	}{
+		{path: "file"},
+		{path: "sub/file"},
+		{path: "sub/../file", violation: true},
+		{path: ""},
+		{path: "new-file"},
+		{path: "sub/new-dir/new-file"},
+		{path: "inside-link"},
+		{path: "sub/parent-link"},
+		{path: "dangling-inside"},
+
+		// Parent directory references
+		{path: "..", violation: true},
+		{path: "../root/file", violation: true},
+		{path: "sub/../../outside", violation: true},
+
+		// Hidden components
+		{path: ".hidden", violation: true},
+		{path: "sub/.hidden/file", violation: true},
+		{path: ".upload-123/file", violation: true},
+
+		// Null bytes
+		{path: "file\x00.sig", violation: true},
+		{path: "\x00", violation: true},
+
+		// Symbolic links pointing outside of the root, including paths that
+		// do not exist yet
+		{path: "outside-link", violation: true},
+		{path: "outside-dir", violation: true},
+		{path: "outside-dir/secret", violation: true},
+		{path: "outside-dir/new-file", violation: true},
+		{path: "outside-dir/new-dir/new-file", violation: true},
+		{path: "relative-escape", violation: true},
+		{path: "dangling-outside", violation: true},
+	}
+
+	for _, test := range tests {
+		fullPath, err := containPath(root, test.path)
+
+		var violation *pathViolation
+		if test.violation == true {
+			if errors.As(err, &violation) == false {
+				t.Errorf("containPath(%q) = %q, %v, expected a violation", test.path, fullPath, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("containPath(%q) error = %v", test.path, err)
+		} else if fullPath != filepath.Join(root, test.path) {
+			t.Errorf("containPath(%q) = %q, expected %q", test.path, fullPath, filepath.Join(root, test.path))
+		}
+	}
+}
+
+func TestContainPathSymlinkedRoot(t *testing.T) {
+	// The root itself may be a symbolic link (e.g. a data directory on
+	// another disk)
+	root := setupContainmentRoot(t)
+	link := t.TempDir() + "/root-link"
+	if err := os.Symlink(root, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := containPath(link, "sub/file"); err != nil {
+		t.Errorf("containPath() through a linked root error = %v", err)
+	}
+
+	if _, err := containPath(link, "outside-link"); err == nil {
+		t.Error("containPath() through a linked root should reject links pointing outside")
+	}
+}
+
+func TestIsPathInside(t *testing.T) {
+	tests := []struct {
+		root     string
+		path     string
+		expected bool
+	}{
+		{"/srv/pool", "/srv/pool", true},
+		{"/srv/pool", "/srv/pool/file", true},
+		{"/srv/pool", "/srv/pool/..file", true},
+		{"/srv/pool", "/srv/pool2/file", false},
+		{"/srv/pool", "/srv", false},
+		{"/srv/pool", "/etc/passwd", false},
+	}
+
+	for _, test := range tests {
+		if result := isPathInside(test.root, test.path); result != test.expected {
+			t.Errorf("isPathInside(%q, %q) = %v, expected %v", test.root, test.path, result, test.expected)
+		}
+	}
+}
+
+func TestValidateFileName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "foo-1.0-1-x86_64.pkg.tar.zst", valid: true},
+		{name: "foo-1.0-1-x86_64.pkg.tar.zst.sig", valid: true},
+		{name: "file with spaces", valid: true},
+		{name: "名前.txt", valid: true},
+		{name: ""},
+		{name: "."},
+		{name: ".."},
+		{name: ".hidden"},
+		{name: "..foo"},
+		{name: "../foo"},
+		{name: "sub/foo"},
+		{name: "sub\\foo"},
+		{name: "/etc/passwd"},
+		{name: "foo\x00.pkg.tar.zst"},
+		{name: "foo\nbar"},
+		{name: "foo\x1b[31m"},
+		{name: "foo\u0085"},
+	}
+
+	for _, test := range tests {
+		err := validateFileName(test.name)
+
+		if test.valid == true && err != nil {
+			t.Errorf("validateFileName(%q) error = %v", test.name, err)
+		} else if test.valid == false {
+			var violation *pathViolation
+			if errors.As(err, &violation) == false {
+				t.Errorf("validateFileName(%q) = %v, expected a violation", test.name, err)
+			}
+		}
+	}
+}
+
+func TestCheckUploadPolicy(t *testing.T) {
+	settings := UploadSettings{AllowedFiles: []string{"*.pkg.tar.*", "*.sig"}}
+
+	tests := map[string]bool{
+		"foo-1.0-1-x86_64.pkg.tar.zst":     true,
+		"foo-1.0-1-x86_64.pkg.tar.zst.sig": true,
+		"notes.txt":                        false,
+		"myrepo.db":                        false,
+	}
+
+	for name, allowed := range tests {
+		err := checkUploadPolicy(settings, name)
+		if (err == nil) != allowed {
+			t.Errorf("checkUploadPolicy(%q) error = %v, expected allowed: %v", name, err, allowed)
+		}
+	}
+
+	if err := checkUploadPolicy(UploadSettings{}, "notes.txt"); err != nil {
+		t.Errorf("checkUploadPolicy() without patterns error = %v", err)
+	}
+
+	if err := validateUploadSettings(TargetSettings{Uploads: UploadSettings{AllowedFiles: []string{"[invalid"}}}); err == nil {
+		t.Error("validateUploadSettings() should reject an invalid pattern")
+	}
+}
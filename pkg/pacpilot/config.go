@@ -0,0 +1,126 @@
+// Package pacpilot provides the repo and target model used by the pacpilot
+// command, allowing other programs to list, enable and disable repos and
+// targets and to run their hooks.
+package pacpilot
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"errors"
+	"os"
+	"os/exec"
+	// External modules
+)
+
+//
+//// ERRORS
+//
+
+var (
+	ErrRepoNotFound     = errors.New("repo not found")
+	ErrTargetNotFound   = errors.New("target not found")
+	ErrHookNotFound     = errors.New("hook not found")
+	ErrAlreadyEnabled   = errors.New("already enabled")
+	ErrAlreadyDisabled  = errors.New("already disabled")
+	ErrNoDefaultShell   = errors.New("no default shell was found")
+	ErrInvalidEntryFile = errors.New("invalid custom entry configuration file")
+	ErrHookTimedOut     = errors.New("hook timed out")
+	ErrCPULimitExceeded = errors.New("hook exceeded its CPU time limit")
+	ErrInvalidHookArgs  = errors.New("invalid hook arguments")
+	ErrMissingHookEnv   = errors.New("missing required environment variable")
+)
+
+//
+//// CONFIGURATION
+//
+
+// Config holds the locations and program information used to generate repos
+// and targets and the environment of their hooks.
+type Config struct {
+	// Name of the program (exported to hooks as PROGRAM_NAME)
+	ProgramName string
+	// Path of the pacpilot executable (exported to hooks as PACPILOT_EXEC)
+	ProgramExec string
+	// Shell used to run hooks without a custom entry command
+	DefaultShell string
+	// Executable that starts hooks with CPU, memory or open files limits: it
+	// is run with the HookLimitsShimEnv variable set and should then call
+	// RunHookLimitsShim, which sets the limits before the entry command runs.
+	// If empty, the limits are set right after the hook starts, so processes
+	// started by the hook at once may not be limited.
+	HookLimitsShim string
+
+	DataDir             string
+	ReposDir            string
+	TemplatesDir        string
+	ReposTemplatesDir   string
+	TargetsTemplatesDir string
+}
+
+// NewConfig returns the default configuration for the given user data
+// directory. The default shell is 'sh' and the program executable is the
+// 'pacpilot' binary found in PATH, if any.
+func NewConfig(dataDir string) (Config, error) {
+	shell, err := exec.LookPath("sh")
+	if err != nil {
+		return Config{}, ErrNoDefaultShell
+	}
+
+	programExec, err := exec.LookPath("pacpilot")
+	if err != nil {
+		programExec, _ = os.Executable()
+	}
+
+	return Config{
+		ProgramName:         "pacpilot",
+		ProgramExec:         programExec,
+		DefaultShell:        shell,
+		DataDir:             dataDir,
+		ReposDir:            dataDir + "/repos",
+		TemplatesDir:        dataDir + "/templates",
+		ReposTemplatesDir:   dataDir + "/templates" + "/repos",
+		TargetsTemplatesDir: dataDir + "/templates" + "/targets",
+	}, nil
+}
+
+//
+//// HELPERS
+//
+
+// Returns the names of the non-hidden entries of the directory that satisfy
+// the filter (sorted by name).
+func readDirNames(dir string, filter func(os.DirEntry) bool) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Name()[0] == '.' {
+			continue
+		}
+
+		if filter == nil || filter(entry) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// Returns whether the path exists (errors other than a missing file are
+// returned).
+func pathExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,320 @@
+package pacpilot
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	// External modules
+)
+
+//
+//// HOOKS
+//
+
+// Hook is an executable in the hooks directory of a repo or target. Hooks run
+// with the default shell, unless a custom entry command is set in the manifest
+// of the hooks directory (see HookManifestName) or in the '<hook>.entry' file
+// next to it. The other fields are only set by the manifest.
+type Hook struct {
+	Name string
+	Path string
+	// Entry command and its arguments (the hook path is appended when running)
+	Entry     string
+	EntryArgs []string
+	// Whether the hook is declared in the manifest
+	Declared    bool
+	Description string
+	Limits      HookLimits
+	// Environment variables that should be set (and not empty) to run the hook
+	RequiredEnv []string
+	// Other environment variables read by the hook
+	OptionalEnv []string
+	Args        []HookArg
+	// Whether the hook may be run through the HTTP API
+	API bool
+	// Shim used to set the hook's limits (Config.HookLimitsShim)
+	limitsShim string
+}
+
+// HookOptions configures how a hook is run.
+type HookOptions struct {
+	// Environment variables added to the current environment (usually the
+	// Environment of the repo or target)
+	Env map[string]string
+	// Receives the hook's output while it runs. The output is discarded if
+	// nil (it is still available in the result).
+	Output io.Writer
+	// Connect the hook to the terminal's stdin (in raw mode), allowing
+	// interactive commands. Ignored if stdin is not a terminal.
+	Interactive bool
+	// Called with the hook's pseudo terminal once the hook starts, allowing
+	// input to be sent from elsewhere (e.g. a remote terminal). Not called if
+	// the hook is connected to stdin.
+	Attach func(terminal *Terminal)
+	// Limits overriding the hook's limits (only the non-zero ones)
+	Limits HookLimits
+	// Arguments given to the hook (validated against the declared arguments,
+	// if the hook is declared in the manifest)
+	Args []string
+}
+
+// HookResult is the result of a hook that ran until it exited.
+type HookResult struct {
+	ExitCode int
+	// Output of the hook, without ANSI escape sequences and carriage returns
+	Output string
+}
+
+// GetHooks returns the hooks in the directory (sorted by name).
+func GetHooks(config Config, hooksDir string) ([]Hook, error) {
+	names, err := readDirNames(hooksDir, func(entry os.DirEntry) bool {
+		return strings.HasSuffix(entry.Name(), ".entry") == false && entry.Name() != HookManifestName
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the hooks directory -> %w", err)
+	}
+
+	// The manifest is read once for all hooks
+	manifest, err := readHookManifest(hooksDir)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := make([]Hook, 0, len(names))
+	for _, name := range names {
+		hook, err := getHook(config, hooksDir, name, manifest)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// GetHook returns the hook with the given name, or ErrHookNotFound if it
+// does not exist.
+func GetHook(config Config, hooksDir string, name string) (Hook, error) {
+	manifest, err := readHookManifest(hooksDir)
+	if err != nil {
+		return Hook{}, err
+	}
+
+	return getHook(config, hooksDir, name, manifest)
+}
+
+// Returns the hook with the given name, with the settings of its declaration
+// in the (already read) manifest of the hooks directory.
+func getHook(config Config, hooksDir string, name string, manifest map[string]hookManifestEntry) (Hook, error) {
+	if name == "" || strings.Contains(name, "/") || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".entry") || name == HookManifestName {
+		return Hook{}, ErrHookNotFound
+	}
+
+	hook := Hook{
+		Name:       name,
+		Path:       hooksDir + "/" + name,
+		API:        true,
+		limitsShim: config.HookLimitsShim,
+	}
+
+	exists, err := pathExists(hook.Path)
+	if err != nil {
+		return Hook{}, err
+	}
+	if exists == false {
+		return Hook{}, ErrHookNotFound
+	}
+
+	// Verify if hook has custom entry command
+	contents, err := os.ReadFile(hook.Path + ".entry")
+	if os.IsNotExist(err) {
+		hook.Entry = config.DefaultShell
+	} else if err != nil {
+		return Hook{}, fmt.Errorf("failed to read custom entry configuration file -> %w", err)
+	} else {
+		entry := strings.Split(strings.Trim(string(contents), "\n"), " ")
+		if entry[0] == "" {
+			return Hook{}, ErrInvalidEntryFile
+		}
+
+		hook.Entry = entry[0]
+		hook.EntryArgs = entry[1:]
+	}
+
+	if entry, exists := manifest[name]; exists == true {
+		err = entry.apply(&hook)
+		if err != nil {
+			return Hook{}, fmt.Errorf("invalid declaration of hook '%s' in hook manifest -> %w", name, err)
+		}
+	}
+
+	return hook, nil
+}
+
+// EntryCommand returns the full command used to run the hook.
+func (hook Hook) EntryCommand() string {
+	return strings.Join(append([]string{hook.Entry}, hook.EntryArgs...), " ")
+}
+
+// RunHook runs the hook in a pseudo terminal and waits for it to exit. A
+// non-zero exit code is not an error. If the context is canceled, the hook's
+// process group is killed and the context's error is returned. If the hook
+// times out or exceeds its CPU time, ErrHookTimedOut or ErrCPULimitExceeded
+// is returned (wrapped) with the output written until then.
+func RunHook(ctx context.Context, hook Hook, options HookOptions) (HookResult, error) {
+	values, err := hook.ParseArgs(options.Args)
+	if err != nil {
+		return HookResult{}, fmt.Errorf("%w -> %v", ErrInvalidHookArgs, err)
+	}
+
+	env := os.Environ()
+	for key, value := range options.Env {
+		env = append(env, key+"="+value)
+	}
+
+	// Declared arguments are also exported as environment variables, with
+	// their default values
+	for _, arg := range hook.Args {
+		env = append(env, HookArgEnvName(arg.Name)+"="+values[arg.Name])
+	}
+
+	for _, name := range hook.RequiredEnv {
+		if lookupEnv(env, name) == "" {
+			return HookResult{}, fmt.Errorf("%w '%s'", ErrMissingHookEnv, name)
+		}
+	}
+
+	args := append(append([]string{}, hook.EntryArgs...), hook.Path)
+	args = append(args, options.Args...)
+
+	limits := hook.Limits.Merge(options.Limits)
+
+	exitCode, output, err := runInPTY(ctx, hook.Entry, args, env, options.Output, options.Interactive, options.Attach, limits, hook.limitsShim)
+	if errors.Is(err, ErrHookTimedOut) || errors.Is(err, ErrCPULimitExceeded) {
+		return HookResult{
+			ExitCode: exitCode,
+			Output:   CleanupOutput(output),
+		}, err
+	} else if err != nil {
+		return HookResult{}, err
+	}
+
+	return HookResult{
+		ExitCode: exitCode,
+		Output:   CleanupOutput(output),
+	}, nil
+}
+
+// ParseArgs validates the arguments against the arguments declared in the
+// manifest and returns the value of each declared argument (its default value
+// if it is not given, and 'true' or 'false' for boolean arguments). Any
+// arguments are accepted by hooks that are not declared in the manifest.
+func (hook Hook) ParseArgs(args []string) (map[string]string, error) {
+	values := make(map[string]string, len(hook.Args))
+
+	if hook.Declared == false {
+		return values, nil
+	}
+
+	declared := make(map[string]HookArg, len(hook.Args))
+	for _, arg := range hook.Args {
+		declared[arg.Name] = arg
+	}
+
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(args[i], "--"), "=")
+		arg, exists := declared[name]
+		if strings.HasPrefix(args[i], "--") == false || exists == false {
+			if len(hook.Args) == 0 {
+				return nil, fmt.Errorf("hook '%s' does not accept arguments", hook.Name)
+			}
+			return nil, fmt.Errorf("unknown argument '%s'", args[i])
+		}
+
+		if _, given := values[name]; given == true {
+			return nil, fmt.Errorf("argument '%s' is given more than once", name)
+		}
+
+		if hasValue == false {
+			if arg.Type == HookArgBool {
+				value = "true"
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			} else {
+				return nil, fmt.Errorf("argument '%s' has no value", name)
+			}
+		}
+
+		if err := arg.validate(value); err != nil {
+			return nil, err
+		}
+
+		values[name] = value
+	}
+
+	for _, arg := range hook.Args {
+		if _, given := values[arg.Name]; given == true {
+			continue
+		}
+
+		if arg.Required == true {
+			return nil, fmt.Errorf("argument '%s' is required", arg.Name)
+		}
+
+		values[arg.Name] = arg.Default
+		if arg.Type == HookArgBool && arg.Default == "" {
+			values[arg.Name] = "false"
+		}
+	}
+
+	return values, nil
+}
+
+// DeclaresEnv returns whether the environment variable is declared in the
+// manifest (as required or optional).
+func (hook Hook) DeclaresEnv(name string) bool {
+	return slices.Contains(hook.RequiredEnv, name) || slices.Contains(hook.OptionalEnv, name)
+}
+
+// HookArgEnvName returns the environment variable with the value of the
+// argument (e.g. HOOK_ARG_PKG_NAME for 'pkg-name').
+func HookArgEnvName(name string) string {
+	return "HOOK_ARG_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Returns the value of the variable in the environment (the last one wins, as
+// in exec.Cmd).
+func lookupEnv(env []string, name string) string {
+	value := ""
+	for _, variable := range env {
+		if key, v, _ := strings.Cut(variable, "="); key == name {
+			value = v
+		}
+	}
+
+	return value
+}
+
+var ansiEscapeSequence = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+
+// CleanupOutput removes ANSI escape sequences, carriage returns and
+// leading/trailing newlines from the output of a hook, so that it is easier to
+// store and parse (HookResult.Output is already cleaned up).
+func CleanupOutput(output string) string {
+	output = ansiEscapeSequence.ReplaceAllString(output, "")
+	output = strings.Trim(output, "\n")
+	output = strings.ReplaceAll(output, "\r", "")
+
+	return output
+}
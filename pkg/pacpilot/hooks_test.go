@@ -0,0 +1,205 @@
+package pacpilot
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+//
+//// HOOKS
+//
+
+func TestGetHooks(t *testing.T) {
+	config := Config{DefaultShell: "/bin/sh"}
+	hooksDir := t.TempDir()
+
+	for _, name := range []string{"build", "clean", "deploy", ".hidden"} {
+		os.WriteFile(hooksDir+"/"+name, []byte("echo "+name+"\n"), 0755)
+	}
+	os.WriteFile(hooksDir+"/clean.entry", []byte("/usr/bin/bash -e\n"), 0644)
+	os.WriteFile(hooksDir+"/"+HookManifestName, []byte(`
+[build]
+description = "Build a package"
+entry = "/usr/bin/zsh"
+
+[[build.args]]
+name = "pkg"
+
+[deploy]
+api = false
+
+[missing]
+description = "Declared but not in the directory"
+`), 0644)
+
+	hooks, err := GetHooks(config, hooksDir)
+	if err != nil {
+		t.Fatalf("GetHooks() error = %v", err)
+	}
+
+	expected := []Hook{
+		{Name: "build", Path: hooksDir + "/build", Entry: "/usr/bin/zsh", EntryArgs: []string{}, Declared: true, Description: "Build a package", Args: []HookArg{{Name: "pkg", Type: HookArgString}}, API: true},
+		{Name: "clean", Path: hooksDir + "/clean", Entry: "/usr/bin/bash", EntryArgs: []string{"-e"}, API: true},
+		{Name: "deploy", Path: hooksDir + "/deploy", Entry: "/bin/sh", Declared: true, API: false},
+	}
+	if reflect.DeepEqual(hooks, expected) == false {
+		t.Errorf("GetHooks() = %+v, expected %+v", hooks, expected)
+	}
+
+	// A single hook is the same as in the list
+	hook, err := GetHook(config, hooksDir, "build")
+	if err != nil || reflect.DeepEqual(hook, expected[0]) == false {
+		t.Errorf("GetHook(\"build\") = %+v, %v, expected %+v", hook, err, expected[0])
+	}
+
+	for _, name := range []string{"", "missing", ".hidden", "clean.entry", HookManifestName, "../build", "sub/build"} {
+		if _, err := GetHook(config, hooksDir, name); errors.Is(err, ErrHookNotFound) == false {
+			t.Errorf("GetHook(%q) error = %v, expected ErrHookNotFound", name, err)
+		}
+	}
+}
+
+func TestGetHooksInvalid(t *testing.T) {
+	config := Config{DefaultShell: "/bin/sh"}
+
+	hooksDir := t.TempDir()
+	os.WriteFile(hooksDir+"/build", []byte("echo build\n"), 0755)
+	os.WriteFile(hooksDir+"/"+HookManifestName, []byte("[build]\ntimeout = \"soon\"\n"), 0644)
+
+	if _, err := GetHooks(config, hooksDir); err == nil {
+		t.Error("GetHooks() should fail for an invalid declaration")
+	}
+
+	os.WriteFile(hooksDir+"/"+HookManifestName, []byte("[build\n"), 0644)
+	if _, err := GetHook(config, hooksDir, "build"); err == nil {
+		t.Error("GetHook() should fail for an invalid manifest")
+	}
+
+	os.Remove(hooksDir + "/" + HookManifestName)
+	os.WriteFile(hooksDir+"/build.entry", []byte("\n"), 0644)
+	if _, err := GetHook(config, hooksDir, "build"); errors.Is(err, ErrInvalidEntryFile) == false {
+		t.Errorf("GetHook() with an empty entry file error = %v, expected ErrInvalidEntryFile", err)
+	}
+
+	if _, err := GetHooks(config, hooksDir+"/missing"); err == nil {
+		t.Error("GetHooks() should fail for a missing directory")
+	}
+}
+
+//
+//// ARGUMENTS
+//
+
+func TestHookParseArgs(t *testing.T) {
+	hook := Hook{
+		Name:     "build",
+		Declared: true,
+		Args: []HookArg{
+			{Name: "pkg", Type: HookArgString, Required: true},
+			{Name: "jobs", Type: HookArgInt, Default: "4"},
+			{Name: "clean", Type: HookArgBool},
+			{Name: "sign", Type: HookArgBool, Default: "true"},
+			{Name: "mode", Type: HookArgString, Choices: []string{"fast", "full"}},
+		},
+	}
+
+	tests := []struct {
+		args     []string
+		expected map[string]string
+		fails    bool
+	}{
+		{
+			args:     []string{"--pkg", "foo"},
+			expected: map[string]string{"pkg": "foo", "jobs": "4", "clean": "false", "sign": "true", "mode": ""},
+		},
+		{
+			args:     []string{"--pkg=foo", "--jobs=8", "--clean", "--sign=false", "--mode", "fast"},
+			expected: map[string]string{"pkg": "foo", "jobs": "8", "clean": "true", "sign": "false", "mode": "fast"},
+		},
+		{
+			// Values may start with dashes
+			args:     []string{"--pkg", "--clean"},
+			expected: map[string]string{"pkg": "--clean", "jobs": "4", "clean": "false", "sign": "true", "mode": ""},
+		},
+		{
+			args:     []string{"--pkg="},
+			expected: map[string]string{"pkg": "", "jobs": "4", "clean": "false", "sign": "true", "mode": ""},
+		},
+		{args: nil, fails: true},
+		{args: []string{"--jobs", "2"}, fails: true},
+		{args: []string{"--pkg"}, fails: true},
+		{args: []string{"--pkg", "foo", "--pkg", "bar"}, fails: true},
+		{args: []string{"--pkg", "foo", "--unknown"}, fails: true},
+		{args: []string{"pkg", "foo"}, fails: true},
+		{args: []string{"-pkg", "foo"}, fails: true},
+		{args: []string{"--pkg", "foo", "extra"}, fails: true},
+		{args: []string{"--pkg", "foo", "--jobs", "many"}, fails: true},
+		{args: []string{"--pkg", "foo", "--clean=maybe"}, fails: true},
+		{args: []string{"--pkg", "foo", "--mode", "slow"}, fails: true},
+	}
+
+	for _, test := range tests {
+		values, err := hook.ParseArgs(test.args)
+
+		if test.fails == true {
+			if err == nil {
+				t.Errorf("ParseArgs(%q) = %v, expected an error", test.args, values)
+			}
+			continue
+		}
+
+		if err != nil || reflect.DeepEqual(values, test.expected) == false {
+			t.Errorf("ParseArgs(%q) = %v, %v, expected %v", test.args, values, err, test.expected)
+		}
+	}
+}
+
+func TestHookParseArgsUndeclared(t *testing.T) {
+	// Hooks that are not declared accept any arguments
+	hook := Hook{Name: "build"}
+	values, err := hook.ParseArgs([]string{"anything", "--goes"})
+	if err != nil || len(values) != 0 {
+		t.Errorf("ParseArgs() of an undeclared hook = %v, %v, expected no values", values, err)
+	}
+
+	// Declared hooks without arguments accept none
+	hook.Declared = true
+	if _, err := hook.ParseArgs([]string{"--pkg", "foo"}); err == nil {
+		t.Error("ParseArgs() of a hook without declared arguments should fail")
+	}
+	if values, err := hook.ParseArgs(nil); err != nil || len(values) != 0 {
+		t.Errorf("ParseArgs(nil) = %v, %v, expected no values", values, err)
+	}
+}
+
+func TestHookArgEnvName(t *testing.T) {
+	tests := map[string]string{
+		"pkg":      "HOOK_ARG_PKG",
+		"pkg-name": "HOOK_ARG_PKG_NAME",
+		"pkg_name": "HOOK_ARG_PKG_NAME",
+		"v2":       "HOOK_ARG_V2",
+	}
+
+	for name, expected := range tests {
+		if result := HookArgEnvName(name); result != expected {
+			t.Errorf("HookArgEnvName(%q) = %s, expected %s", name, result, expected)
+		}
+	}
+}
+
+func TestHookDeclaresEnv(t *testing.T) {
+	hook := Hook{RequiredEnv: []string{"GPGKEY"}, OptionalEnv: []string{"PACKAGER"}}
+
+	for name, expected := range map[string]bool{"GPGKEY": true, "PACKAGER": true, "HOME": false, "gpgkey": false} {
+		if result := hook.DeclaresEnv(name); result != expected {
+			t.Errorf("DeclaresEnv(%q) = %v, expected %v", name, result, expected)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package pacpilot
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	// External modules
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+//
+//// HOOK MANIFESTS
+//
+
+// HookManifestName is the name of the optional manifest of a hooks directory,
+// with a table of metadata and settings for each hook:
+//
+//	[build]
+//	description = "Build a package"
+//	entry = "/usr/bin/bash -e"
+//	timeout = "30m"
+//	cpu_time = "10m"
+//	memory = "2G"
+//	open_files = 1024
+//	required_env = ["GPGKEY"]
+//	optional_env = ["PACKAGER"]
+//	api = false
+//
+//	[[build.args]]
+//	name = "pkg"
+//	type = "string"
+//	required = true
+//	description = "Package to build"
+const HookManifestName = "hooks.toml"
+
+type hookManifestEntry struct {
+	Description string            `toml:"description"`
+	Entry       string            `toml:"entry"`
+	Timeout     string            `toml:"timeout"`
+	CPUTime     string            `toml:"cpu_time"`
+	Memory      string            `toml:"memory"`
+	OpenFiles   uint64            `toml:"open_files"`
+	RequiredEnv []string          `toml:"required_env"`
+	OptionalEnv []string          `toml:"optional_env"`
+	Args        []hookManifestArg `toml:"args"`
+	API         *bool             `toml:"api"`
+}
+
+type hookManifestArg struct {
+	Name        string   `toml:"name"`
+	Type        string   `toml:"type"`
+	Description string   `toml:"description"`
+	Required    bool     `toml:"required"`
+	Default     string   `toml:"default"`
+	Choices     []string `toml:"choices"`
+}
+
+// Types of hook arguments
+const (
+	HookArgString = "string"
+	HookArgInt    = "int"
+	HookArgBool   = "bool"
+)
+
+// HookArg is an argument declared in the hook manifest. Arguments are given to
+// the hook as '--<name> <value>' (or '--<name>' for boolean arguments).
+type HookArg struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+	// Value of the argument when it is not given (empty for none)
+	Default string
+	// Allowed values (empty for any value of the type)
+	Choices []string
+}
+
+var hookArgName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+var envVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// HookLimits limits the time and resources used by a hook. Zero values mean
+// no limit.
+type HookLimits struct {
+	// Time after which the hook's process group receives SIGTERM (and SIGKILL
+	// if it is still running after a grace period)
+	Timeout time.Duration
+	// CPU time of each process (RLIMIT_CPU)
+	CPUTime time.Duration
+	// Address space of each process, in bytes (RLIMIT_AS)
+	Memory uint64
+	// Open files of each process (RLIMIT_NOFILE)
+	OpenFiles uint64
+}
+
+// Merge returns the limits with the non-zero limits of overrides.
+func (limits HookLimits) Merge(overrides HookLimits) HookLimits {
+	if overrides.Timeout > 0 {
+		limits.Timeout = overrides.Timeout
+	}
+	if overrides.CPUTime > 0 {
+		limits.CPUTime = overrides.CPUTime
+	}
+	if overrides.Memory > 0 {
+		limits.Memory = overrides.Memory
+	}
+	if overrides.OpenFiles > 0 {
+		limits.OpenFiles = overrides.OpenFiles
+	}
+
+	return limits
+}
+
+// Returns the manifest entries of the hooks directory (none if it has no
+// manifest).
+func readHookManifest(hooksDir string) (map[string]hookManifestEntry, error) {
+	contents, err := os.ReadFile(hooksDir + "/" + HookManifestName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read hook manifest -> %w", err)
+	}
+
+	var manifest map[string]hookManifestEntry
+	err = toml.Unmarshal(contents, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse hook manifest '%s' -> %w", hooksDir+"/"+HookManifestName, err)
+	}
+
+	return manifest, nil
+}
+
+// Sets the manifest settings of the hook.
+func (entry hookManifestEntry) apply(hook *Hook) error {
+	hook.Declared = true
+	hook.Description = entry.Description
+
+	if strings.TrimSpace(entry.Entry) != "" {
+		fields := strings.Fields(entry.Entry)
+		hook.Entry = fields[0]
+		hook.EntryArgs = fields[1:]
+	}
+
+	var err error
+
+	if entry.Timeout != "" {
+		hook.Limits.Timeout, err = time.ParseDuration(entry.Timeout)
+		if err != nil || hook.Limits.Timeout < 0 {
+			return fmt.Errorf("invalid timeout '%s'", entry.Timeout)
+		}
+	}
+
+	if entry.CPUTime != "" {
+		hook.Limits.CPUTime, err = time.ParseDuration(entry.CPUTime)
+		if err != nil || hook.Limits.CPUTime < 0 {
+			return fmt.Errorf("invalid CPU time '%s'", entry.CPUTime)
+		}
+	}
+
+	hook.Limits.Memory, err = ParseSize(entry.Memory)
+	if err != nil {
+		return err
+	}
+
+	hook.Limits.OpenFiles = entry.OpenFiles
+
+	for _, name := range append(append([]string{}, entry.RequiredEnv...), entry.OptionalEnv...) {
+		if envVariableName.MatchString(name) == false {
+			return fmt.Errorf("invalid environment variable name '%s'", name)
+		}
+	}
+	hook.RequiredEnv = entry.RequiredEnv
+	hook.OptionalEnv = entry.OptionalEnv
+
+	names := make(map[string]bool)
+	for _, arg := range entry.Args {
+		if hookArgName.MatchString(arg.Name) == false {
+			return fmt.Errorf("invalid argument name '%s' (expected lowercase letters, digits, '-' and '_')", arg.Name)
+		}
+		// Arguments are also exported as environment variables, whose names
+		// should not collide
+		if names[HookArgEnvName(arg.Name)] == true {
+			return fmt.Errorf("argument '%s' is declared more than once", arg.Name)
+		}
+		names[HookArgEnvName(arg.Name)] = true
+
+		hookArg := HookArg{
+			Name:        arg.Name,
+			Type:        arg.Type,
+			Description: arg.Description,
+			Required:    arg.Required,
+			Default:     arg.Default,
+			Choices:     arg.Choices,
+		}
+		if hookArg.Type == "" {
+			hookArg.Type = HookArgString
+		}
+
+		if hookArg.Type != HookArgString && hookArg.Type != HookArgInt && hookArg.Type != HookArgBool {
+			return fmt.Errorf("invalid type '%s' of argument '%s' (expected '%s', '%s' or '%s')", hookArg.Type, arg.Name, HookArgString, HookArgInt, HookArgBool)
+		}
+		if hookArg.Type == HookArgBool && (hookArg.Required == true || len(hookArg.Choices) > 0) {
+			return fmt.Errorf("boolean argument '%s' cannot be required or have choices", arg.Name)
+		}
+		for _, choice := range hookArg.Choices {
+			if err := hookArg.validate(choice); err != nil {
+				return err
+			}
+		}
+		if hookArg.Default != "" {
+			if err := hookArg.validate(hookArg.Default); err != nil {
+				return fmt.Errorf("invalid default value -> %w", err)
+			}
+		}
+
+		hook.Args = append(hook.Args, hookArg)
+	}
+
+	hook.API = true
+	if entry.API != nil {
+		hook.API = *entry.API
+	}
+
+	return nil
+}
+
+// Returns an error if the value is not a valid value of the argument.
+func (arg HookArg) validate(value string) error {
+	switch arg.Type {
+	case HookArgInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("argument '%s' should be an integer (got '%s')", arg.Name, value)
+		}
+	case HookArgBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("argument '%s' should be a boolean (got '%s')", arg.Name, value)
+		}
+	}
+
+	if len(arg.Choices) > 0 && slices.Contains(arg.Choices, value) == false {
+		return fmt.Errorf("argument '%s' should be one of '%s' (got '%s')", arg.Name, strings.Join(arg.Choices, "', '"), value)
+	}
+
+	return nil
+}
+
+// ParseSize parses a number of bytes with an optional K, M or G suffix (powers
+// of 1024), e.g. '512M' or '2G'. An empty value is 0.
+func ParseSize(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := uint64(1)
+	number := strings.ToUpper(value)
+
+	switch {
+	case strings.HasSuffix(number, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(number, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(number, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		number = number[:len(number)-1]
+	}
+
+	size, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s' (expected a number of bytes, e.g. '512M' or '2G')", value)
+	}
+	if size > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("size '%s' is too large", value)
+	}
+
+	return size * multiplier, nil
+}
@@ -0,0 +1,181 @@
+package pacpilot
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//
+//// SIZES
+//
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected uint64
+		fails    bool
+	}{
+		{value: "", expected: 0},
+		{value: "0", expected: 0},
+		{value: "512", expected: 512},
+		{value: "4K", expected: 4 << 10},
+		{value: "4k", expected: 4 << 10},
+		{value: "512M", expected: 512 << 20},
+		{value: "2G", expected: 2 << 30},
+		{value: "17179869183G", expected: 17179869183 << 30},
+		{value: "17179869184G", fails: true},
+		{value: "99999999999999999G", fails: true},
+		{value: "18446744073709551616", fails: true},
+		{value: "-1M", fails: true},
+		{value: "1.5G", fails: true},
+		{value: "2T", fails: true},
+		{value: "G", fails: true},
+	}
+
+	for _, test := range tests {
+		size, err := ParseSize(test.value)
+		if test.fails == true {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, expected an error", test.value, size)
+			}
+			continue
+		}
+
+		if err != nil || size != test.expected {
+			t.Errorf("ParseSize(%q) = %d, %v, expected %d", test.value, size, err, test.expected)
+		}
+	}
+}
+
+//
+//// HOOK MANIFESTS
+//
+
+func TestReadHookManifest(t *testing.T) {
+	hooksDir := t.TempDir()
+
+	manifest, err := readHookManifest(hooksDir)
+	if err != nil || manifest != nil {
+		t.Errorf("readHookManifest() without a manifest = %v, %v, expected no entries", manifest, err)
+	}
+
+	os.WriteFile(hooksDir+"/"+HookManifestName, []byte(`
+[build]
+description = "Build a package"
+memory = "2G"
+
+[[build.args]]
+name = "pkg"
+required = true
+
+[clean]
+`), 0644)
+
+	manifest, err = readHookManifest(hooksDir)
+	if err != nil {
+		t.Fatalf("readHookManifest() error = %v", err)
+	}
+	if len(manifest) != 2 || manifest["build"].Description != "Build a package" || len(manifest["build"].Args) != 1 {
+		t.Errorf("readHookManifest() = %+v, expected the 'build' and 'clean' entries", manifest)
+	}
+
+	os.WriteFile(hooksDir+"/"+HookManifestName, []byte("[build\n"), 0644)
+	if _, err := readHookManifest(hooksDir); err == nil {
+		t.Error("readHookManifest() should fail for an invalid manifest")
+	}
+}
+
+func TestHookManifestEntryApply(t *testing.T) {
+	boolPtr := func(value bool) *bool {
+		return &value
+	}
+
+	tests := []struct {
+		name     string
+		entry    hookManifestEntry
+		expected Hook
+		fails    bool
+	}{
+		{
+			name:     "empty declaration",
+			entry:    hookManifestEntry{},
+			expected: Hook{Entry: "/bin/sh", Declared: true, API: true},
+		},
+		{
+			name: "all settings",
+			entry: hookManifestEntry{
+				Description: "Build a package",
+				Entry:       " /usr/bin/bash  -e ",
+				Timeout:     "30m",
+				CPUTime:     "10m",
+				Memory:      "2G",
+				OpenFiles:   1024,
+				RequiredEnv: []string{"GPGKEY"},
+				OptionalEnv: []string{"PACKAGER", "_OTHER"},
+				Args: []hookManifestArg{
+					{Name: "pkg", Required: true, Description: "Package to build"},
+					{Name: "jobs", Type: "int", Default: "4"},
+					{Name: "clean", Type: "bool"},
+					{Name: "mode", Choices: []string{"fast", "full"}, Default: "full"},
+				},
+				API: boolPtr(false),
+			},
+			expected: Hook{
+				Entry:       "/usr/bin/bash",
+				EntryArgs:   []string{"-e"},
+				Declared:    true,
+				Description: "Build a package",
+				Limits:      HookLimits{Timeout: 30 * time.Minute, CPUTime: 10 * time.Minute, Memory: 2 << 30, OpenFiles: 1024},
+				RequiredEnv: []string{"GPGKEY"},
+				OptionalEnv: []string{"PACKAGER", "_OTHER"},
+				Args: []HookArg{
+					{Name: "pkg", Type: HookArgString, Required: true, Description: "Package to build"},
+					{Name: "jobs", Type: HookArgInt, Default: "4"},
+					{Name: "clean", Type: HookArgBool},
+					{Name: "mode", Type: HookArgString, Choices: []string{"fast", "full"}, Default: "full"},
+				},
+				API: false,
+			},
+		},
+		{name: "invalid timeout", entry: hookManifestEntry{Timeout: "30"}, fails: true},
+		{name: "negative timeout", entry: hookManifestEntry{Timeout: "-1m"}, fails: true},
+		{name: "invalid CPU time", entry: hookManifestEntry{CPUTime: "ten minutes"}, fails: true},
+		{name: "invalid memory", entry: hookManifestEntry{Memory: "2GB"}, fails: true},
+		{name: "invalid required variable", entry: hookManifestEntry{RequiredEnv: []string{"GPG-KEY"}}, fails: true},
+		{name: "invalid optional variable", entry: hookManifestEntry{OptionalEnv: []string{"1ABC"}}, fails: true},
+		{name: "invalid argument name", entry: hookManifestEntry{Args: []hookManifestArg{{Name: "Pkg"}}}, fails: true},
+		{name: "empty argument name", entry: hookManifestEntry{Args: []hookManifestArg{{Name: ""}}}, fails: true},
+		{name: "duplicate argument", entry: hookManifestEntry{Args: []hookManifestArg{{Name: "pkg"}, {Name: "pkg"}}}, fails: true},
+		// Both are exported as HOOK_ARG_PKG_NAME
+		{name: "colliding arguments", entry: hookManifestEntry{Args: []hookManifestArg{{Name: "pkg-name"}, {Name: "pkg_name"}}}, fails: true},
+		{name: "invalid type", entry: hookManifestEntry{Args: []hookManifestArg{{Name: "pkg", Type: "list"}}}, fails: true},
+		{name: "required boolean", entry: hookManifestEntry{Args: []hookManifestArg{{Name: "clean", Type: "bool", Required: true}}}, fails: true},
+		{name: "boolean with choices", entry: hookManifestEntry{Args: []hookManifestArg{{Name: "clean", Type: "bool", Choices: []string{"true"}}}}, fails: true},
+		{name: "invalid choice", entry: hookManifestEntry{Args: []hookManifestArg{{Name: "jobs", Type: "int", Choices: []string{"1", "many"}}}}, fails: true},
+		{name: "invalid default", entry: hookManifestEntry{Args: []hookManifestArg{{Name: "jobs", Type: "int", Default: "many"}}}, fails: true},
+		{name: "default not in choices", entry: hookManifestEntry{Args: []hookManifestArg{{Name: "mode", Choices: []string{"fast", "full"}, Default: "slow"}}}, fails: true},
+	}
+
+	for _, test := range tests {
+		hook := Hook{Entry: "/bin/sh", API: true}
+		err := test.entry.apply(&hook)
+
+		if test.fails == true {
+			if err == nil {
+				t.Errorf("%s: apply() = %+v, expected an error", test.name, hook)
+			}
+			continue
+		}
+
+		if err != nil || reflect.DeepEqual(hook, test.expected) == false {
+			t.Errorf("%s: apply() = %+v, %v, expected %+v", test.name, hook, err, test.expected)
+		}
+	}
+}
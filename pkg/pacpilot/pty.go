@@ -0,0 +1,399 @@
+package pacpilot
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	// External modules
+	pty "github.com/creack/pty"
+	unix "golang.org/x/sys/unix"
+	term "golang.org/x/term"
+)
+
+//
+//// PSEUDO TERMINALS
+//
+
+// Time to wait for the remaining output after the process exits. Processes
+// started in the background by the hook may keep the pseudo terminal open.
+const ptyOutputDrainTimeout = time.Second
+
+// Time between SIGTERM and SIGKILL when a hook times out
+const hookKillGracePeriod = 10 * time.Second
+
+// Terminal is the pseudo terminal of a running hook. It can be used until
+// RunHook returns.
+type Terminal struct {
+	primary *os.File
+}
+
+// Write sends input to the hook, as if it was typed in the terminal.
+func (terminal *Terminal) Write(data []byte) (int, error) {
+	return terminal.primary.Write(data)
+}
+
+// Resize changes the size of the terminal (the hook receives SIGWINCH).
+func (terminal *Terminal) Resize(rows uint16, cols uint16) error {
+	return pty.Setsize(terminal.primary, &pty.Winsize{Rows: rows, Cols: cols})
+}
+
+// Runs the command in a new session attached to a pseudo terminal and returns
+// its exit code and output.
+func runInPTY(ctx context.Context, entry string, args []string, env []string, output io.Writer, interactive bool, attach func(*Terminal), limits HookLimits, limitsShim string) (int, string, error) {
+	cmd := exec.Command(entry, args...)
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:  true, // Start the command in a new session (and process group)
+		Setctty: true, // Set controlling terminal to the pseudo terminal
+	}
+
+	primary, secondary, err := pty.Open()
+	if err != nil {
+		return 0, "", err
+	}
+	defer primary.Close()
+
+	cmd.Stdin = secondary
+	cmd.Stdout = secondary
+	cmd.Stderr = secondary
+
+	stdinFd := int(os.Stdin.Fd())
+	interactive = interactive && term.IsTerminal(stdinFd)
+
+	if interactive == true {
+		pty.InheritSize(os.Stdin, primary)
+	} else {
+		pty.Setsize(primary, &pty.Winsize{Rows: 24, Cols: 80})
+	}
+
+	// Start the command through the limits shim, so that the limits are set
+	// before it runs (and before it can start other processes)
+	rlimits := hookRlimits(limits)
+	if len(rlimits) > 0 && limitsShim != "" && cmd.Err == nil {
+		cmd.Args = append([]string{limitsShim, cmd.Path}, cmd.Args...)
+		cmd.Path = limitsShim
+		cmd.Env = append(env[:len(env):len(env)], HookLimitsShimEnv+"="+encodeRlimits(rlimits))
+	}
+
+	err = cmd.Start()
+	secondary.Close()
+	if err != nil {
+		return 0, "", err
+	}
+
+	// Without a shim, the limits are set as soon as the command has started
+	if len(rlimits) > 0 && limitsShim == "" {
+		err = setProcessRlimits(cmd.Process.Pid, rlimits)
+		if err != nil {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			cmd.Wait()
+			return 0, "", fmt.Errorf("failed to set hook limits -> %w", err)
+		}
+	}
+
+	// Copy the output to the buffer (and the output writer). Reading fails
+	// once all processes attached to the pseudo terminal exit.
+	var buffer bytes.Buffer
+	writer := io.Writer(&buffer)
+	if output != nil {
+		writer = io.MultiWriter(&buffer, output)
+	}
+
+	outputDone := make(chan struct{})
+	go func() {
+		io.Copy(writer, primary)
+		close(outputDone)
+	}()
+
+	done := make(chan struct{})
+	var inputWaitGroup sync.WaitGroup
+
+	if interactive == false && attach != nil {
+		attach(&Terminal{primary: primary})
+	}
+
+	if interactive == true {
+		oldState, err := term.MakeRaw(stdinFd)
+		if err == nil {
+			defer term.Restore(stdinFd, oldState)
+		}
+
+		inputWaitGroup.Add(2)
+		go func() {
+			defer inputWaitGroup.Done()
+			forwardWindowSize(primary, done)
+		}()
+		go func() {
+			defer inputWaitGroup.Done()
+			forwardInput(stdinFd, primary, done)
+		}()
+	}
+
+	waitResult := make(chan error, 1)
+	go func() {
+		waitResult <- cmd.Wait()
+	}()
+
+	var timeout <-chan time.Time
+	if limits.Timeout > 0 {
+		timer := time.NewTimer(limits.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var waitErr error
+	canceled := false
+	timedOut := false
+
+	select {
+	case waitErr = <-waitResult:
+	case <-ctx.Done():
+		canceled = true
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		waitErr = <-waitResult
+	case <-timeout:
+		timedOut = true
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+
+		select {
+		case waitErr = <-waitResult:
+		case <-time.After(hookKillGracePeriod):
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			waitErr = <-waitResult
+		case <-ctx.Done():
+			canceled = true
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			waitErr = <-waitResult
+		}
+
+		// Kill the processes of the group that are still running
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	close(done)
+	inputWaitGroup.Wait()
+
+	select {
+	case <-outputDone:
+	case <-time.After(ptyOutputDrainTimeout):
+		primary.Close()
+		<-outputDone
+	}
+
+	if canceled == true {
+		return 0, buffer.String(), ctx.Err()
+	}
+
+	exitCode := 0
+	if exitError, ok := waitErr.(*exec.ExitError); ok {
+		exitCode = exitError.ExitCode()
+	} else if waitErr != nil {
+		return 0, buffer.String(), waitErr
+	}
+
+	if timedOut == true {
+		return exitCode, buffer.String(), fmt.Errorf("%w after %v", ErrHookTimedOut, limits.Timeout)
+	}
+
+	if limits.CPUTime > 0 && cpuLimitExceeded(cmd.ProcessState, limits.CPUTime) == true {
+		return exitCode, buffer.String(), fmt.Errorf("%w (%v)", ErrCPULimitExceeded, limits.CPUTime)
+	}
+
+	return exitCode, buffer.String(), nil
+}
+
+//
+//// RESOURCE LIMITS
+//
+
+// HookLimitsShimEnv is the environment variable with the limits to be set by
+// the shim that starts hooks with CPU, memory or open files limits (see
+// Config.HookLimitsShim and RunHookLimitsShim).
+const HookLimitsShimEnv = "PACPILOT_HOOK_LIMITS"
+
+type hookRlimit struct {
+	resource int
+	limit    syscall.Rlimit
+}
+
+// Names of the limited resources, in error messages
+var rlimitNames = map[int]string{
+	unix.RLIMIT_CPU:    "CPU time",
+	unix.RLIMIT_AS:     "memory",
+	unix.RLIMIT_NOFILE: "open files",
+}
+
+// Returns the resource limits to set for the hook's limits.
+func hookRlimits(limits HookLimits) []hookRlimit {
+	var rlimits []hookRlimit
+
+	if limits.CPUTime > 0 {
+		// The process receives SIGXCPU at the soft limit and SIGKILL at the
+		// hard limit
+		seconds := uint64(max(limits.CPUTime.Round(time.Second)/time.Second, 1))
+		rlimits = append(rlimits, hookRlimit{resource: unix.RLIMIT_CPU, limit: syscall.Rlimit{Cur: seconds, Max: seconds + 5}})
+	}
+
+	if limits.Memory > 0 {
+		rlimits = append(rlimits, hookRlimit{resource: unix.RLIMIT_AS, limit: syscall.Rlimit{Cur: limits.Memory, Max: limits.Memory}})
+	}
+
+	if limits.OpenFiles > 0 {
+		rlimits = append(rlimits, hookRlimit{resource: unix.RLIMIT_NOFILE, limit: syscall.Rlimit{Cur: limits.OpenFiles, Max: limits.OpenFiles}})
+	}
+
+	return rlimits
+}
+
+// Encodes the limits as 'resource:soft:hard' values separated by commas.
+func encodeRlimits(rlimits []hookRlimit) string {
+	values := make([]string, 0, len(rlimits))
+	for _, rlimit := range rlimits {
+		values = append(values, fmt.Sprintf("%d:%d:%d", rlimit.resource, rlimit.limit.Cur, rlimit.limit.Max))
+	}
+
+	return strings.Join(values, ",")
+}
+
+func decodeRlimits(value string) ([]hookRlimit, error) {
+	var rlimits []hookRlimit
+
+	for _, field := range strings.Split(value, ",") {
+		var rlimit hookRlimit
+		_, err := fmt.Sscanf(field, "%d:%d:%d", &rlimit.resource, &rlimit.limit.Cur, &rlimit.limit.Max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit '%s'", field)
+		}
+		rlimits = append(rlimits, rlimit)
+	}
+
+	return rlimits, nil
+}
+
+// RunHookLimitsShim sets the limits encoded in limits (the value of the
+// HookLimitsShimEnv variable) and executes the entry command given in args, as
+// '<entry path> <entry command> [argument...]'. Programs used as
+// Config.HookLimitsShim call it with their own arguments when the variable is
+// set. It only returns if it fails, in which case the program should exit.
+func RunHookLimitsShim(limits string, args []string) error {
+	env := slices.DeleteFunc(os.Environ(), func(variable string) bool {
+		return strings.HasPrefix(variable, HookLimitsShimEnv+"=")
+	})
+
+	rlimits, err := decodeRlimits(limits)
+	if err != nil {
+		return err
+	}
+
+	for _, rlimit := range rlimits {
+		// syscall.Setrlimit (unlike unix.Setrlimit) also prevents the runtime
+		// from restoring its original open files limit on exec
+		err = syscall.Setrlimit(rlimit.resource, &rlimit.limit)
+		if err != nil {
+			return fmt.Errorf("%s -> %w", rlimitNames[rlimit.resource], err)
+		}
+	}
+
+	if len(args) < 2 {
+		return errors.New("missing entry command")
+	}
+
+	return syscall.Exec(args[0], args[1:], env)
+}
+
+// Sets the limits of a running process. Processes it started before are not
+// limited.
+func setProcessRlimits(pid int, rlimits []hookRlimit) error {
+	for _, rlimit := range rlimits {
+		err := unix.Prlimit(pid, rlimit.resource, &unix.Rlimit{Cur: rlimit.limit.Cur, Max: rlimit.limit.Max}, nil)
+		if err != nil {
+			return fmt.Errorf("%s -> %w", rlimitNames[rlimit.resource], err)
+		}
+	}
+
+	return nil
+}
+
+// Whether the process was killed for exceeding its CPU time: by SIGXCPU, by
+// SIGKILL once its CPU time reached the limit, or (for shells) whether it
+// exited with the status of a command killed by SIGXCPU.
+func cpuLimitExceeded(state *os.ProcessState, cpuTime time.Duration) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if ok == false {
+		return false
+	}
+
+	if status.Signaled() == true {
+		switch status.Signal() {
+		case syscall.SIGXCPU:
+			return true
+		case syscall.SIGKILL:
+			return state.UserTime()+state.SystemTime() >= cpuTime
+		}
+	}
+
+	return status.Exited() == true && status.ExitStatus() == 128+int(syscall.SIGXCPU)
+}
+
+// Resizes the pseudo terminal whenever the terminal is resized.
+func forwardWindowSize(primary *os.File, done chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGWINCH)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-signals:
+			pty.InheritSize(os.Stdin, primary)
+		case <-done:
+			return
+		}
+	}
+}
+
+// Copies stdin to the pseudo terminal until done is closed. Stdin is polled,
+// so that no read is left pending (and no input is lost) after the process
+// exits.
+func forwardInput(stdinFd int, primary *os.File, done chan struct{}) {
+	fds := []unix.PollFd{{Fd: int32(stdinFd), Events: unix.POLLIN}}
+	buf := make([]byte, 4096)
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		n, err := unix.Poll(fds, 50)
+		if err == unix.EINTR || n == 0 {
+			continue
+		}
+		if err != nil || fds[0].Revents&unix.POLLIN == 0 {
+			return
+		}
+
+		read, err := unix.Read(stdinFd, buf)
+		if err != nil || read == 0 {
+			return
+		}
+
+		primary.Write(buf[:read])
+	}
+}
@@ -0,0 +1,182 @@
+package pacpilot
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+//
+//// RESOURCE LIMITS
+//
+
+// The test binary is the limits shim of the test hooks, as the pacpilot
+// program is for its hooks.
+func TestMain(m *testing.M) {
+	if limits, exists := os.LookupEnv(HookLimitsShimEnv); exists == true {
+		err := RunHookLimitsShim(limits, os.Args[1:])
+		fmt.Fprintf(os.Stderr, "Failed to set hook limits -> %v\n", err)
+		os.Exit(126)
+	}
+
+	os.Exit(m.Run())
+}
+
+// Writes an executable hook with the script to a temporary directory. The
+// hook is started through the test binary if it has limits.
+func writeTestHook(t *testing.T, script string) Hook {
+	t.Helper()
+
+	path := t.TempDir() + "/hook"
+	err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Hook{Name: "hook", Path: path, Entry: "/bin/sh", limitsShim: "/proc/self/exe"}
+}
+
+// Returns the soft limits of /proc/<pid>/limits, by name (e.g. 'Max open
+// files').
+func parseProcLimits(output string) map[string]string {
+	limits := make(map[string]string)
+	line := regexp.MustCompile(`^(Max [a-z ]+?)\s{2,}(\S+)\s+(\S+)`)
+
+	for _, text := range strings.Split(output, "\n") {
+		if match := line.FindStringSubmatch(text); match != nil {
+			limits[match[1]] = match[2]
+		}
+	}
+
+	return limits
+}
+
+func TestRunHookLimitsApplyToChildren(t *testing.T) {
+	// The hook starts a child at once, which prints its own limits
+	hook := writeTestHook(t, "cat /proc/self/limits &\nwait\n")
+
+	result, err := RunHook(context.Background(), hook, HookOptions{
+		Limits: HookLimits{
+			CPUTime:   30 * time.Second,
+			Memory:    512 << 20,
+			OpenFiles: 64,
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunHook() error = %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Fatalf("RunHook() exit code = %d, output:\n%s", result.ExitCode, result.Output)
+	}
+
+	limits := parseProcLimits(result.Output)
+	tests := map[string]string{
+		"Max cpu time":      "30",
+		"Max address space": "536870912",
+		"Max open files":    "64",
+	}
+	for name, expected := range tests {
+		if limits[name] != expected {
+			t.Errorf("child %s = %q, expected %q (output:\n%s)", name, limits[name], expected, result.Output)
+		}
+	}
+}
+
+func TestRunHookLimitsShimEnvironment(t *testing.T) {
+	hook := writeTestHook(t, "echo \"shim=${"+HookLimitsShimEnv+":-unset}\"\n")
+
+	result, err := RunHook(context.Background(), hook, HookOptions{
+		Limits: HookLimits{OpenFiles: 64},
+	})
+	if err != nil {
+		t.Fatalf("RunHook() error = %v", err)
+	}
+
+	if strings.Contains(result.Output, "shim=unset") == false {
+		t.Errorf("the shim variable should not be visible to the hook, output:\n%s", result.Output)
+	}
+}
+
+func TestRunHookLimitsWithoutShim(t *testing.T) {
+	// The limits are set on the hook's process once it has started
+	hook := writeTestHook(t, "sleep 0.2\ncat /proc/$$/limits\n")
+	hook.limitsShim = ""
+
+	result, err := RunHook(context.Background(), hook, HookOptions{
+		Limits: HookLimits{Memory: 512 << 20, OpenFiles: 64},
+	})
+	if err != nil || result.ExitCode != 0 {
+		t.Fatalf("RunHook() = %+v, %v, expected exit code 0", result, err)
+	}
+
+	limits := parseProcLimits(result.Output)
+	if limits["Max address space"] != "536870912" || limits["Max open files"] != "64" {
+		t.Errorf("hook limits = %v, expected 536870912 bytes and 64 open files (output:\n%s)", limits, result.Output)
+	}
+}
+
+func TestRunHookLimitsShimFailure(t *testing.T) {
+	// Invalid limits are rejected before any limit is set
+	if err := RunHookLimitsShim("invalid", []string{"/bin/true", "true"}); err == nil {
+		t.Error("RunHookLimitsShim() with invalid limits should fail")
+	}
+}
+
+func TestGetHookLimitsShim(t *testing.T) {
+	hooksDir := t.TempDir()
+	os.WriteFile(hooksDir+"/hook", []byte("echo hook\n"), 0755)
+
+	hook, err := GetHook(Config{DefaultShell: "/bin/sh", HookLimitsShim: "/usr/bin/shim"}, hooksDir, "hook")
+	if err != nil || hook.limitsShim != "/usr/bin/shim" {
+		t.Errorf("GetHook() = %+v, %v, expected the shim of the configuration", hook, err)
+	}
+}
+
+func TestRunHookWithoutLimits(t *testing.T) {
+	hook := writeTestHook(t, "echo hello\nexit 3\n")
+
+	result, err := RunHook(context.Background(), hook, HookOptions{})
+	if err != nil {
+		t.Fatalf("RunHook() error = %v", err)
+	}
+
+	if result.ExitCode != 3 || strings.TrimSpace(result.Output) != "hello" {
+		t.Errorf("RunHook() = %+v, expected exit code 3 and output 'hello'", result)
+	}
+}
+
+func TestRlimitsEncoding(t *testing.T) {
+	rlimits := hookRlimits(HookLimits{CPUTime: 90 * time.Second, Memory: 1 << 30, OpenFiles: 128})
+	if len(rlimits) != 3 {
+		t.Fatalf("hookRlimits() returned %d limits, expected 3", len(rlimits))
+	}
+
+	decoded, err := decodeRlimits(encodeRlimits(rlimits))
+	if err != nil {
+		t.Fatalf("decodeRlimits() error = %v", err)
+	}
+
+	for index := range rlimits {
+		if decoded[index] != rlimits[index] {
+			t.Errorf("decoded limit %d = %+v, expected %+v", index, decoded[index], rlimits[index])
+		}
+	}
+
+	// The hard CPU time limit leaves time for SIGXCPU to be handled
+	if rlimits[0].limit.Cur != 90 || rlimits[0].limit.Max != 95 {
+		t.Errorf("CPU time limit = %+v, expected 90:95", rlimits[0].limit)
+	}
+
+	if _, err := decodeRlimits("7:1"); err == nil {
+		t.Error("decodeRlimits() should fail for an invalid value")
+	}
+}
@@ -0,0 +1,195 @@
+package pacpilot
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"context"
+	"fmt"
+	"os"
+	// External modules
+)
+
+//
+//// REPOS
+//
+
+// Repo is a repository in the user data directory. A repo groups targets and
+// has its own hooks, which run before or after the hooks of its targets.
+type Repo struct {
+	Name         string
+	Path         string
+	HooksDir     string
+	TargetsDir   string
+	TempDir      string
+	DisabledPath string
+	ConfigPath   string
+	// Environment variables exported to the repo's hooks
+	Environment map[string]string
+}
+
+// NewRepo returns the repo with the given name. The repo is not required to
+// exist.
+func NewRepo(config Config, name string) Repo {
+	path := config.ReposDir + "/" + name
+
+	environment := map[string]string{
+		"PROGRAM_NAME":          config.ProgramName,
+		"DEFAULT_SHELL":         config.DefaultShell,
+		"PACPILOT_EXEC":         config.ProgramExec,
+		"PACPILOT_UTILS":        fmt.Sprintf("%v utils", config.ProgramExec),
+		"DATA_DIR":              config.DataDir,
+		"REPOS_DIR":             config.ReposDir,
+		"TEMPLATES_DIR":         config.TemplatesDir,
+		"REPOS_TEMPLATES_DIR":   config.ReposTemplatesDir,
+		"TARGETS_TEMPLATES_DIR": config.TargetsTemplatesDir,
+		"REPO_NAME":             name,
+		"REPO_DIR":              path,
+		"REPO_HOOKS_DIR":        path + "/hooks",
+		"REPO_TARGETS_DIR":      path + "/targets",
+		"REPO_TEMP_DIR":         path + "/.tmp",
+	}
+
+	return Repo{
+		Name:         name,
+		Path:         path,
+		HooksDir:     path + "/hooks",
+		TargetsDir:   path + "/targets",
+		TempDir:      path + "/.tmp",
+		DisabledPath: path + "/disabled",
+		ConfigPath:   path + "/config.toml",
+		Environment:  environment,
+	}
+}
+
+// GetRepo returns the repo with the given name, or ErrRepoNotFound if it does
+// not exist.
+func GetRepo(ctx context.Context, config Config, name string) (Repo, error) {
+	if err := ctx.Err(); err != nil {
+		return Repo{}, err
+	}
+
+	repo := NewRepo(config, name)
+
+	exists, err := repo.Exists()
+	if err != nil {
+		return Repo{}, err
+	}
+	if exists == false {
+		return Repo{}, ErrRepoNotFound
+	}
+
+	return repo, nil
+}
+
+// GetRepos returns all repos in the repos directory (sorted by name).
+func GetRepos(ctx context.Context, config Config) ([]Repo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	names, err := readDirNames(config.ReposDir, func(entry os.DirEntry) bool {
+		return entry.IsDir()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the repos directory -> %w", err)
+	}
+
+	repos := make([]Repo, len(names))
+	for i, name := range names {
+		repos[i] = NewRepo(config, name)
+	}
+
+	return repos, nil
+}
+
+// GetEnabledRepos returns all repos that are not disabled (sorted by name).
+func GetEnabledRepos(ctx context.Context, config Config) ([]Repo, error) {
+	repos, err := GetRepos(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var enabledRepos []Repo
+	for _, repo := range repos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		disabled, err := repo.IsDisabled()
+		if err != nil {
+			return nil, err
+		}
+
+		if disabled == false {
+			enabledRepos = append(enabledRepos, repo)
+		}
+	}
+
+	return enabledRepos, nil
+}
+
+// Exists returns whether the repo's directory exists.
+func (repo Repo) Exists() (bool, error) {
+	return pathExists(repo.Path)
+}
+
+// IsDisabled returns whether the repo is disabled.
+func (repo Repo) IsDisabled() (bool, error) {
+	disabled, err := pathExists(repo.DisabledPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify if repo is disabled -> %w", err)
+	}
+
+	return disabled, nil
+}
+
+// Enable enables the repo. Returns ErrAlreadyEnabled if the repo is not
+// disabled.
+func (repo Repo) Enable() error {
+	return enable(repo.DisabledPath, "repo")
+}
+
+// Disable disables the repo. Returns ErrAlreadyDisabled if the repo is
+// already disabled.
+func (repo Repo) Disable() error {
+	return disable(repo.DisabledPath, "repo")
+}
+
+// Repos and targets are disabled by the presence of a 'disabled' file in
+// their directory.
+func enable(disabledPath string, kind string) error {
+	disabled, err := pathExists(disabledPath)
+	if err != nil {
+		return fmt.Errorf("failed to verify if %s is disabled -> %w", kind, err)
+	}
+	if disabled == false {
+		return ErrAlreadyEnabled
+	}
+
+	err = os.Remove(disabledPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove disabled lock file -> %w", err)
+	}
+
+	return nil
+}
+
+func disable(disabledPath string, kind string) error {
+	disabled, err := pathExists(disabledPath)
+	if err != nil {
+		return fmt.Errorf("failed to verify if %s is enabled -> %w", kind, err)
+	}
+	if disabled == true {
+		return ErrAlreadyDisabled
+	}
+
+	file, err := os.Create(disabledPath)
+	if err != nil {
+		return fmt.Errorf("failed to create disabled lock file -> %w", err)
+	}
+
+	return file.Close()
+}
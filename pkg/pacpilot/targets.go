@@ -0,0 +1,158 @@
+package pacpilot
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"context"
+	"fmt"
+	"os"
+	// External modules
+)
+
+//
+//// TARGETS
+//
+
+// Target is a target of a repo (e.g. an architecture or a set of packages).
+// Its packages are served from the pool directory.
+type Target struct {
+	Repo         Repo
+	Name         string
+	Path         string
+	HooksDir     string
+	TempDir      string
+	PoolDir      string
+	DisabledPath string
+	ConfigPath   string
+	EventsPath   string
+	// Environment variables exported to the target's hooks
+	Environment map[string]string
+}
+
+// NewTarget returns the target of the repo with the given name. The target is
+// not required to exist.
+func NewTarget(config Config, repoName string, name string) Target {
+	repo := NewRepo(config, repoName)
+	path := repo.TargetsDir + "/" + name
+
+	environment := make(map[string]string, len(repo.Environment)+5)
+	for key, value := range repo.Environment {
+		environment[key] = value
+	}
+	environment["TARGET_NAME"] = name
+	environment["TARGET_DIR"] = path
+	environment["TARGET_HOOKS_DIR"] = path + "/hooks"
+	environment["TARGET_POOL_DIR"] = path + "/pool"
+	environment["TARGET_TEMP_DIR"] = path + "/.tmp"
+
+	return Target{
+		Repo:         repo,
+		Name:         name,
+		Path:         path,
+		HooksDir:     path + "/hooks",
+		PoolDir:      path + "/pool",
+		TempDir:      path + "/.tmp",
+		DisabledPath: path + "/disabled",
+		ConfigPath:   path + "/config.toml",
+		EventsPath:   path + "/events.json",
+		Environment:  environment,
+	}
+}
+
+// GetTarget returns the target of the repo with the given name, or
+// ErrTargetNotFound if it does not exist.
+func GetTarget(ctx context.Context, config Config, repo Repo, name string) (Target, error) {
+	if err := ctx.Err(); err != nil {
+		return Target{}, err
+	}
+
+	target := NewTarget(config, repo.Name, name)
+
+	exists, err := target.Exists()
+	if err != nil {
+		return Target{}, err
+	}
+	if exists == false {
+		return Target{}, ErrTargetNotFound
+	}
+
+	return target, nil
+}
+
+// GetRepoTargets returns all targets of the repo (sorted by name).
+func GetRepoTargets(ctx context.Context, config Config, repo Repo) ([]Target, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	names, err := readDirNames(repo.TargetsDir, func(entry os.DirEntry) bool {
+		return entry.IsDir()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the repo's targets directory -> %w", err)
+	}
+
+	targets := make([]Target, len(names))
+	for i, name := range names {
+		targets[i] = NewTarget(config, repo.Name, name)
+	}
+
+	return targets, nil
+}
+
+// GetEnabledTargets returns all targets of the repo that are not disabled
+// (sorted by name).
+func GetEnabledTargets(ctx context.Context, config Config, repo Repo) ([]Target, error) {
+	targets, err := GetRepoTargets(ctx, config, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var enabledTargets []Target
+	for _, target := range targets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		disabled, err := target.IsDisabled()
+		if err != nil {
+			return nil, err
+		}
+
+		if disabled == false {
+			enabledTargets = append(enabledTargets, target)
+		}
+	}
+
+	return enabledTargets, nil
+}
+
+// Exists returns whether the target's directory exists.
+func (target Target) Exists() (bool, error) {
+	return pathExists(target.Path)
+}
+
+// IsDisabled returns whether the target is disabled.
+func (target Target) IsDisabled() (bool, error) {
+	disabled, err := pathExists(target.DisabledPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify if target is disabled -> %w", err)
+	}
+
+	return disabled, nil
+}
+
+// Enable enables the target. Returns ErrAlreadyEnabled if the target is not
+// disabled.
+func (target Target) Enable() error {
+	return enable(target.DisabledPath, "target")
+}
+
+// Disable disables the target. Returns ErrAlreadyDisabled if the target is
+// already disabled.
+func (target Target) Disable() error {
+	return disable(target.DisabledPath, "target")
+}
@@ -0,0 +1,171 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	// External modules
+	zstd "github.com/klauspost/compress/zstd"
+	xz "github.com/ulikunitz/xz"
+)
+
+//
+//// PACKAGE ARCHIVES
+//
+
+type pkgFile struct {
+	name     string
+	mode     os.FileMode
+	size     int64
+	isDir    bool
+	isLink   bool
+	linkName string
+}
+
+type pkgArchive struct {
+	path       string
+	hasPkgInfo bool
+	info       map[string][]string
+	files      []pkgFile
+}
+
+func isPackageFile(name string) bool {
+	return strings.Contains(name, ".pkg.tar") && !strings.HasSuffix(name, ".sig")
+}
+
+func (pkg pkgArchive) field(key string) string {
+	values := pkg.info[key]
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (pkg pkgArchive) fields(key string) []string {
+	return pkg.info[key]
+}
+
+func openDecompressedReader(file *os.File) (io.Reader, func(), error) {
+	reader := bufio.NewReader(file)
+
+	// Detect the compression format using the magic bytes of the file
+	magic, err := reader.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return decoder, decoder.Close, nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		decoder, err := xz.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return decoder, func() {}, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		decoder, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return decoder, func() { decoder.Close() }, nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(reader), func() {}, nil
+	default:
+		// Uncompressed tarball
+		return reader, func() {}, nil
+	}
+}
+
+func parsePkgInfo(contents []byte) map[string][]string {
+	info := make(map[string][]string)
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if found == false {
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		info[key] = append(info[key], strings.TrimSpace(value))
+	}
+
+	return info
+}
+
+func readPackageArchive(path string) (pkgArchive, error) {
+	pkg := pkgArchive{
+		path: path,
+		info: make(map[string][]string),
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return pkg, err
+	}
+	defer file.Close()
+
+	reader, closeReader, err := openDecompressedReader(file)
+	if err != nil {
+		return pkg, fmt.Errorf("failed to decompress package -> %v", err)
+	}
+	defer closeReader()
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return pkg, fmt.Errorf("failed to read package archive -> %v", err)
+		}
+
+		name := strings.TrimPrefix(header.Name, "./")
+
+		if name == ".PKGINFO" {
+			contents, err := io.ReadAll(tarReader)
+			if err != nil {
+				return pkg, fmt.Errorf("failed to read .PKGINFO -> %v", err)
+			}
+
+			pkg.hasPkgInfo = true
+			pkg.info = parsePkgInfo(contents)
+
+			continue
+		}
+
+		pkg.files = append(pkg.files, pkgFile{
+			name:     strings.TrimSuffix(name, "/"),
+			mode:     header.FileInfo().Mode(),
+			size:     header.Size,
+			isDir:    header.Typeflag == tar.TypeDir,
+			isLink:   header.Typeflag == tar.TypeSymlink,
+			linkName: header.Linkname,
+		})
+	}
+
+	return pkg, nil
+}
@@ -0,0 +1,261 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	// External modules
+)
+
+//
+//// REPO DATABASES
+//
+
+type dbPackage struct {
+	name     string
+	version  string
+	filename string
+	desc     map[string][]string
+}
+
+func (pkg dbPackage) field(key string) string {
+	values := pkg.desc[key]
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (pkg dbPackage) fields(key string) []string {
+	return pkg.desc[key]
+}
+
+// Returns the path of the target's database (created by repo-add in the
+// pool directory and named after the repo).
+func getTargetDatabasePath(target Target) string {
+	return filepath.Join(target.PoolDir, target.Repo.Name+".db")
+}
+
+func parseDesc(contents []byte) map[string][]string {
+	desc := make(map[string][]string)
+
+	var key string
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%") && len(line) > 2 {
+			key = strings.Trim(line, "%")
+			continue
+		}
+
+		if line == "" {
+			key = ""
+			continue
+		}
+
+		if key != "" {
+			desc[key] = append(desc[key], line)
+		}
+	}
+
+	return desc
+}
+
+// Reads a repo database created by repo-add. Returns an empty map if the
+// database does not exist.
+func readRepoDatabase(path string) (map[string]dbPackage, error) {
+	packages := make(map[string]dbPackage)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return packages, nil
+	} else if err != nil {
+		return packages, err
+	}
+	defer file.Close()
+
+	reader, closeReader, err := openDecompressedReader(file)
+	if err != nil {
+		return packages, fmt.Errorf("failed to decompress database -> %v", err)
+	}
+	defer closeReader()
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return packages, fmt.Errorf("failed to read database -> %v", err)
+		}
+
+		if filepath.Base(header.Name) != "desc" {
+			continue
+		}
+
+		contents, err := io.ReadAll(tarReader)
+		if err != nil {
+			return packages, fmt.Errorf("failed to read database entry '%s' -> %v", header.Name, err)
+		}
+
+		pkg := dbPackage{desc: parseDesc(contents)}
+		pkg.name = pkg.field("NAME")
+		pkg.version = pkg.field("VERSION")
+		pkg.filename = pkg.field("FILENAME")
+
+		if pkg.name != "" {
+			packages[pkg.name] = pkg
+		}
+	}
+
+	return packages, nil
+}
+
+//
+//// VERSION COMPARISON
+//
+
+// Compares two package versions ([epoch:]pkgver[-pkgrel]) the same way as
+// pacman's vercmp. Returns -1, 0 or 1.
+func vercmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+
+	epochA, versionA, releaseA := splitVersion(a)
+	epochB, versionB, releaseB := splitVersion(b)
+
+	if result := rpmvercmp(epochA, epochB); result != 0 {
+		return result
+	}
+	if result := rpmvercmp(versionA, versionB); result != 0 {
+		return result
+	}
+	if releaseA != "" && releaseB != "" {
+		return rpmvercmp(releaseA, releaseB)
+	}
+
+	return 0
+}
+
+func splitVersion(version string) (string, string, string) {
+	epoch := "0"
+	if before, after, found := strings.Cut(version, ":"); found {
+		epoch = before
+		version = after
+	}
+
+	release := ""
+	if index := strings.LastIndex(version, "-"); index != -1 {
+		release = version[index+1:]
+		version = version[:index]
+	}
+
+	return epoch, version, release
+}
+
+func rpmvercmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+
+	isAlnum := func(r byte) bool {
+		return unicode.IsLetter(rune(r)) || unicode.IsDigit(rune(r))
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		// Skip separators
+		startI, startJ := i, j
+		for i < len(a) && !isAlnum(a[i]) {
+			i++
+		}
+		for j < len(b) && !isAlnum(b[j]) {
+			j++
+		}
+
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+
+		// A segment with more separators is newer
+		if i-startI != j-startJ {
+			if i-startI < j-startJ {
+				return -1
+			}
+			return 1
+		}
+
+		// Grab the next segment (numeric or alphabetic)
+		segStartI, segStartJ := i, j
+		isNumeric := unicode.IsDigit(rune(a[i]))
+		if isNumeric {
+			for i < len(a) && unicode.IsDigit(rune(a[i])) {
+				i++
+			}
+			for j < len(b) && unicode.IsDigit(rune(b[j])) {
+				j++
+			}
+		} else {
+			for i < len(a) && unicode.IsLetter(rune(a[i])) {
+				i++
+			}
+			for j < len(b) && unicode.IsLetter(rune(b[j])) {
+				j++
+			}
+		}
+
+		segA := a[segStartI:i]
+		segB := b[segStartJ:j]
+
+		// Numeric segments are always newer than alphabetic ones
+		if segB == "" {
+			if isNumeric {
+				return 1
+			}
+			return -1
+		}
+
+		if isNumeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+
+			if len(segA) != len(segB) {
+				if len(segA) > len(segB) {
+					return 1
+				}
+				return -1
+			}
+		}
+
+		if result := strings.Compare(segA, segB); result != 0 {
+			return result
+		}
+	}
+
+	restA := a[i:]
+	restB := b[j:]
+
+	if restA == "" && restB == "" {
+		return 0
+	}
+
+	// The version with remaining alphabetic characters is older (e.g.
+	// 1.0alpha < 1.0), while remaining numbers make it newer (1.0 < 1.0.1)
+	if (restA == "" && len(restB) > 0 && !unicode.IsLetter(rune(restB[0]))) || (len(restA) > 0 && unicode.IsLetter(rune(restA[0]))) {
+		return -1
+	}
+
+	return 1
+}
@@ -0,0 +1,157 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"testing"
+)
+
+//
+//// VERSION COMPARISON
+//
+
+// Cases of pacman's vercmp tests (test/util/vercmptest.sh). Each case is also
+// checked in reverse.
+var vercmpTests = []struct {
+	a        string
+	b        string
+	expected int
+}{
+	// All similar length, no pkgrel
+	{"1.5.0", "1.5.0", 0},
+	{"1.5.1", "1.5.0", 1},
+
+	// Mixed length
+	{"1.5.1", "1.5", 1},
+
+	// With pkgrel, simple
+	{"1.5.0-1", "1.5.0-1", 0},
+	{"1.5.0-1", "1.5.0-2", -1},
+	{"1.5.0-1", "1.5.1-1", -1},
+	{"1.5.0-2", "1.5.1-1", -1},
+
+	// With pkgrel, mixed lengths
+	{"1.5-1", "1.5.1-1", -1},
+	{"1.5-2", "1.5.1-1", -1},
+	{"1.5-2", "1.5.1-2", -1},
+
+	// Mixed pkgrel inclusion
+	{"1.5", "1.5-1", 0},
+	{"1.5-1", "1.5", 0},
+	{"1.1-1", "1.1", 0},
+	{"1.0-1", "1.1", -1},
+	{"1.1-1", "1.0", 1},
+
+	// Alphanumeric versions
+	{"1.5b-1", "1.5-1", -1},
+	{"1.5b", "1.5", -1},
+	{"1.5b-1", "1.5", -1},
+	{"1.5b", "1.5.1", -1},
+
+	// From the manpage
+	{"1.0a", "1.0alpha", -1},
+	{"1.0alpha", "1.0b", -1},
+	{"1.0b", "1.0beta", -1},
+	{"1.0beta", "1.0rc", -1},
+	{"1.0rc", "1.0", -1},
+
+	// Alpha-dotted versions
+	{"1.5.a", "1.5", 1},
+	{"1.5.b", "1.5.a", 1},
+	{"1.5.1", "1.5.b", 1},
+
+	// Alpha dots and dashes
+	{"1.5.b-1", "1.5.b", 0},
+	{"1.5-1", "1.5.b", -1},
+
+	// Same or similar content, differing separators
+	{"2.0", "2_0", 0},
+	{"2.0_a", "2_0.a", 0},
+	{"2.0a", "2.0.a", -1},
+	{"2___a", "2_a", 1},
+
+	// Epoch included version comparisons
+	{"0:1.0", "0:1.0", 0},
+	{"0:1.0", "0:1.1", -1},
+	{"1:1.0", "0:1.0", 1},
+	{"1:1.0", "0:1.1", 1},
+	{"1:1.0", "2:1.1", -1},
+
+	// Epoch and sometimes present pkgrel
+	{"1:1.0", "0:1.0-1", 1},
+	{"1:1.0-1", "0:1.1-1", 1},
+
+	// Epoch included on one version
+	{"0:1.0", "1.0", 0},
+	{"0:1.0", "1.1", -1},
+	{"0:1.1", "1.0", 1},
+	{"1:1.0", "1.0", 1},
+	{"1:1.0", "1.1", 1},
+	{"1:1.1", "1.1", 1},
+
+	// Leading zeros and long numbers
+	{"1.002", "1.2", 0},
+	{"1.10", "1.9", 1},
+	{"20240101", "9999999", 1},
+	{"1.0.99999999999999999999", "1.0.100000000000000000000", -1},
+}
+
+func TestVercmp(t *testing.T) {
+	for _, test := range vercmpTests {
+		if result := vercmp(test.a, test.b); result != test.expected {
+			t.Errorf("vercmp(%q, %q) = %d, expected %d", test.a, test.b, result, test.expected)
+		}
+
+		if result := vercmp(test.b, test.a); result != -test.expected {
+			t.Errorf("vercmp(%q, %q) = %d, expected %d", test.b, test.a, result, -test.expected)
+		}
+	}
+}
+
+func TestRpmvercmp(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.0", -1},
+		{"1.0a", "1.0", -1},
+		{"a", "1", -1},
+		{"", "", 0},
+		{"", "1", -1},
+		{"1", "", 1},
+		{"1+2", "1.2", 0},
+	}
+
+	for _, test := range tests {
+		if result := rpmvercmp(test.a, test.b); result != test.expected {
+			t.Errorf("rpmvercmp(%q, %q) = %d, expected %d", test.a, test.b, result, test.expected)
+		}
+	}
+}
+
+func TestSplitVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		epoch   string
+		pkgver  string
+		pkgrel  string
+	}{
+		{"1.0", "0", "1.0", ""},
+		{"1.0-2", "0", "1.0", "2"},
+		{"2:1.0-2", "2", "1.0", "2"},
+		{"1:1.0", "1", "1.0", ""},
+		{"1.0-rc1-3", "0", "1.0-rc1", "3"},
+	}
+
+	for _, test := range tests {
+		epoch, pkgver, pkgrel := splitVersion(test.version)
+		if epoch != test.epoch || pkgver != test.pkgver || pkgrel != test.pkgrel {
+			t.Errorf("splitVersion(%q) = %q, %q, %q, expected %q, %q, %q", test.version, epoch, pkgver, pkgrel, test.epoch, test.pkgver, test.pkgrel)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// External modules
+	gin "github.com/gin-gonic/gin"
+)
+
+//
+//// PACKAGE DETAILS
+//
+
+type archivedPackage struct {
+	path    string
+	version string
+}
+
+// Splits a package filename (<name>-<pkgver>-<pkgrel>-<arch>.pkg.tar.*) into
+// the package name, version and architecture.
+func parsePackageFilename(filename string) (string, string, string, bool) {
+	index := strings.Index(filename, ".pkg.tar")
+	if index == -1 {
+		return "", "", "", false
+	}
+
+	parts := strings.Split(filename[:index], "-")
+	if len(parts) < 4 {
+		return "", "", "", false
+	}
+
+	name := strings.Join(parts[:len(parts)-3], "-")
+	version := parts[len(parts)-3] + "-" + parts[len(parts)-2]
+	arch := parts[len(parts)-1]
+
+	return name, version, arch, true
+}
+
+// Removes the version constraint from a dependency or provision (e.g.
+// 'glibc>=2.38' -> 'glibc').
+func stripVersionConstraint(dependency string) string {
+	if index := strings.IndexAny(dependency, "<>="); index != -1 {
+		return dependency[:index]
+	}
+
+	return dependency
+}
+
+// Maps package names and provisions to the package files of the target that
+// provide them. The target's database is used if available, since it also
+// contains the provisions of each package.
+func getTargetProviders(packages []string, dbPackages map[string]dbPackage) map[string]string {
+	providers := make(map[string]string)
+	versions := make(map[string]string)
+
+	for _, path := range packages {
+		name, version, _, ok := parsePackageFilename(filepath.Base(path))
+		if ok == false {
+			continue
+		}
+
+		if previous, exists := versions[name]; exists == false || vercmp(version, previous) > 0 {
+			versions[name] = version
+			providers[name] = path
+		}
+	}
+
+	for _, pkg := range dbPackages {
+		if pkg.filename == "" {
+			continue
+		}
+
+		providers[pkg.name] = pkg.filename
+		for _, provision := range pkg.fields("PROVIDES") {
+			name := stripVersionConstraint(provision)
+			if _, exists := providers[name]; exists == false {
+				providers[name] = pkg.filename
+			}
+		}
+	}
+
+	return providers
+}
+
+// Returns the other versions of the package (built for the same architecture)
+// found in the target's pool directory that are older than the given version
+// (newest first).
+func getArchivedPackageVersions(packages []string, name string, version string, arch string) []archivedPackage {
+	var archived []archivedPackage
+
+	for _, path := range packages {
+		pkgName, pkgVersion, pkgArch, ok := parsePackageFilename(filepath.Base(path))
+		if ok == false || pkgName != name || pkgArch != arch || vercmp(pkgVersion, version) >= 0 {
+			continue
+		}
+
+		archived = append(archived, archivedPackage{
+			path:    path,
+			version: pkgVersion,
+		})
+	}
+
+	sort.SliceStable(archived, func(i, j int) bool {
+		return vercmp(archived[i].version, archived[j].version) > 0
+	})
+
+	return archived
+}
+
+func getPackageSignatureStatus(target Target, path string, dbPackages map[string]dbPackage) string {
+	if _, err := os.Stat(filepath.Join(target.PoolDir, path+".sig")); err == nil {
+		return "Signed (detached signature)"
+	}
+
+	for _, pkg := range dbPackages {
+		if pkg.filename == filepath.Base(path) && pkg.field("PGPSIG") != "" {
+			return "Signed (signature in database)"
+		}
+	}
+
+	return "Not signed"
+}
+
+// Serves an HTML page with the details of a package file in the target's
+// pool directory, rendered from its .PKGINFO.
+func servePackageDetails(c *gin.Context, target Target, resourcePath string, index *serverIndex) {
+	resourcePath = strings.TrimPrefix(resourcePath, "/")
+	fullPath, err := containPath(target.PoolDir, resourcePath)
+	if err != nil {
+		respondWithError(c, err, index.program)
+		return
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() || isPackageFile(info.Name()) == false {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "The requested package could not be found.",
+		})
+		return
+	}
+
+	pkg, err := readPackageArchive(fullPath)
+	if err != nil || pkg.hasPkgInfo == false {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "The requested file is not a valid package.",
+		})
+		return
+	}
+
+	// Failing to read the database only affects the links, so the page is
+	// still rendered
+	dbPackages, _ := readRepoDatabase(getTargetDatabasePath(target))
+	packages := index.getPoolPackages(target)
+	providers := getTargetProviders(packages, dbPackages)
+
+	treeURL := "/repos/" + target.Repo.Name + "/" + target.Name + "/tree/"
+	pkgURL := "/repos/" + target.Repo.Name + "/" + target.Name + "/pkg/"
+
+	escapeList := func(values []string) string {
+		if len(values) == 0 {
+			return "-"
+		}
+
+		escaped := make([]string, len(values))
+		for i, value := range values {
+			escaped[i] = html.EscapeString(value)
+		}
+
+		return strings.Join(escaped, ", ")
+	}
+
+	var depends []string
+	for _, dependency := range pkg.fields("depend") {
+		if provider, exists := providers[stripVersionConstraint(dependency)]; exists == true {
+			depends = append(depends, fmt.Sprintf("<a href=\"%s%s\">%s</a>", pkgURL, provider, html.EscapeString(dependency)))
+		} else {
+			depends = append(depends, html.EscapeString(dependency))
+		}
+	}
+	dependsData := "-"
+	if len(depends) > 0 {
+		dependsData = strings.Join(depends, ", ")
+	}
+
+	installSize := "-"
+	if size, err := strconv.ParseInt(pkg.field("size"), 10, 64); err == nil {
+		installSize = formatBytes(size)
+	}
+
+	buildDate := "-"
+	if timestamp, err := strconv.ParseInt(pkg.field("builddate"), 10, 64); err == nil {
+		buildDate = time.Unix(timestamp, 0).UTC().Format(http.TimeFormat)
+	}
+
+	projectURL := "-"
+	if url := pkg.field("url"); url != "" {
+		projectURL = fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(url), html.EscapeString(url))
+	}
+
+	fields := [][2]string{
+		{"Name", html.EscapeString(pkg.field("pkgname"))},
+		{"Version", html.EscapeString(pkg.field("pkgver"))},
+		{"Description", html.EscapeString(pkg.field("pkgdesc"))},
+		{"Architecture", html.EscapeString(pkg.field("arch"))},
+		{"URL", projectURL},
+		{"Licenses", escapeList(pkg.fields("license"))},
+		{"Depends On", dependsData},
+		{"Provides", escapeList(pkg.fields("provides"))},
+		{"Package Size", formatBytes(info.Size())},
+		{"Installed Size", installSize},
+		{"Packager", html.EscapeString(pkg.field("packager"))},
+		{"Build Date", buildDate},
+		{"Signature", getPackageSignatureStatus(target, resourcePath, dbPackages)},
+	}
+
+	// Create an HTML response
+	c.Header("Content-Type", "text/html")
+	c.Writer.Write([]byte("<h1> " + html.EscapeString(pkg.field("pkgname")+" "+pkg.field("pkgver")) + " </h1>"))
+	c.Writer.Write([]byte("<p><a href=\"" + treeURL + resourcePath + "\">Download</a> | <a href=\"" + treeURL + "\">Back to " + target.Repo.Name + "/" + target.Name + "</a></p>"))
+
+	c.Writer.Write([]byte("<table>\n"))
+	for _, field := range fields {
+		c.Writer.Write([]byte("<tr><th align=\"left\">" + field[0] + ":</th><td>" + field[1] + "</td></tr>\n"))
+	}
+	c.Writer.Write([]byte("</table>\n"))
+
+	c.Writer.Write([]byte("<h2> Files </h2>"))
+	c.Writer.Write([]byte("<pre>\n"))
+	for _, file := range pkg.files {
+		// Skip the package metadata files (.BUILDINFO, .MTREE, .INSTALL, ...)
+		if strings.Contains(file.name, "/") == false && strings.HasPrefix(file.name, ".") {
+			continue
+		}
+
+		name := html.EscapeString(file.name)
+		if file.isDir == true {
+			name += "/"
+		} else if file.isLink == true {
+			name += " -> " + html.EscapeString(file.linkName)
+		}
+		c.Writer.Write([]byte(name + "\n"))
+	}
+	c.Writer.Write([]byte("</pre>\n"))
+
+	archived := getArchivedPackageVersions(packages, pkg.field("pkgname"), pkg.field("pkgver"), pkg.field("arch"))
+	if len(archived) > 0 {
+		c.Writer.Write([]byte("<h2> Archived Versions </h2>"))
+		c.Writer.Write([]byte("<ul>\n"))
+		for _, archivedPkg := range archived {
+			c.Writer.Write([]byte(fmt.Sprintf("<li><a href=\"%s%s\">%s</a> (<a href=\"%s%s\">download</a>)</li>\n",
+				pkgURL, archivedPkg.path, html.EscapeString(archivedPkg.version), treeURL, archivedPkg.path)))
+		}
+		c.Writer.Write([]byte("</ul>\n"))
+	}
+}
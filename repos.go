@@ -6,13 +6,15 @@ package main
 
 import (
 	// Modules in GOROOT
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"strings"
 
 	// External modules
 	survey "github.com/AlecAivazis/survey/v2"
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
 	copy "github.com/otiai10/copy"
 )
 
@@ -20,15 +22,7 @@ import (
 //// REPOS
 //
 
-type Repo struct {
-	name         string
-	path         string
-	hooksDir     string
-	targetsDir   string
-	tempDir      string
-	disabledPath string
-	environment  map[string]string
-}
+type Repo = pacpilot.Repo
 
 func getSelectedReposFromCLI(repoNames []string, allRepos bool, interactiveSelection bool, multiple bool, program Program) ([]Repo, functionResponse) {
 	var selectedRepos []Repo
@@ -48,7 +42,7 @@ func getSelectedReposFromCLI(repoNames []string, allRepos bool, interactiveSelec
 
 		availableReposStrings := make([]string, len(availableRepos))
 		for i, element := range availableRepos {
-			availableReposStrings[i] = element.name
+			availableReposStrings[i] = element.Name
 		}
 
 		var selectedIndices []int
@@ -124,26 +118,19 @@ func getSelectedReposFromCLI(repoNames []string, allRepos bool, interactiveSelec
 }
 
 func displayRepoTag(msg string, repo Repo) string {
-	return fmt.Sprintf(msg) + fmt.Sprintf(" (") + salmonPink.Sprintf(repo.name) + fmt.Sprintf(")")
+	return fmt.Sprintf(msg) + fmt.Sprintf(" (") + salmonPink.Sprintf(repo.Name) + fmt.Sprintf(")")
 }
 
 func getRepos(program Program) ([]Repo, functionResponse) {
-	repoNames, err := ioutil.ReadDir(program.reposDir)
+	repos, err := pacpilot.GetRepos(context.Background(), getLibraryConfig(program))
 	if err != nil {
 		return []Repo{}, functionResponse{
 			exitCode:    1,
-			message:     fmt.Sprintf("Error reading the repos directory -> " + err.Error()),
+			message:     formatErrorMessage(err),
 			logLevel:    "error",
 			indentLevel: program.indentLevel,
 		}
 	}
-	repoNames = filterHiddenFilesAndDirectories(repoNames)
-
-	// Generate a repos array
-	repos := make([]Repo, len(repoNames))
-	for i, element := range repoNames {
-		repos[i] = generateRepoObj(element.Name(), program)
-	}
 
 	if len(repos) == 0 {
 		return repos, functionResponse{
@@ -159,74 +146,35 @@ func getRepos(program Program) ([]Repo, functionResponse) {
 	}
 }
 
-func getEnabledRepos(program Program) []Repo {
-	availableRepos, response := getRepos(program)
-	handleFunctionResponse(response, true)
-
-	var enabledRepos []Repo
-	for _, repo := range availableRepos {
-		repoDisabled, response := isRepoDisabled(repo, program)
-		handleFunctionResponse(response, true)
-
-		if repoDisabled == false {
-			enabledRepos = append(enabledRepos, repo)
-		}
-	}
-
-	return enabledRepos
+// Returns the enabled repos. Having no repos is not an error.
+func getEnabledRepos(program Program) ([]Repo, error) {
+	return pacpilot.GetEnabledRepos(context.Background(), getLibraryConfig(program))
 }
 
 func generateRepoObj(repo string, program Program) Repo {
-	defaultRepoEnv := map[string]string{
-		"PROGRAM_NAME":          program.name,
-		"DEFAULT_SHELL":         program.defaultShell,
-		"PACPILOT_EXEC":         program.exec,
-		"PACPILOT_UTILS":        fmt.Sprintf("%v utils", program.exec),
-		"DATA_DIR":              program.dataDir,
-		"REPOS_DIR":             program.reposDir,
-		"TEMPLATES_DIR":         program.templatesDir,
-		"REPOS_TEMPLATES_DIR":   program.reposTemplatesDir,
-		"TARGETS_TEMPLATES_DIR": program.targetsTemplatesDir,
-		"REPO_NAME":             repo,
-		"REPO_DIR":              program.reposDir + "/" + repo,
-		"REPO_HOOKS_DIR":        program.reposDir + "/" + repo + "/hooks",
-		"REPO_TARGETS_DIR":      program.reposDir + "/" + repo + "/targets",
-		"REPO_TEMP_DIR":         program.reposDir + "/" + repo + "/.tmp",
-	}
-
-	return Repo{
-		name:         repo,
-		path:         program.reposDir + "/" + repo,
-		hooksDir:     program.reposDir + "/" + repo + "/hooks",
-		targetsDir:   program.reposDir + "/" + repo + "/targets",
-		tempDir:      program.reposDir + "/" + repo + "/.tmp",
-		disabledPath: program.reposDir + "/" + repo + "/disabled",
-		environment:  defaultRepoEnv,
-	}
+	return pacpilot.NewRepo(getLibraryConfig(program), repo)
 }
 
 func isRepoDisabled(repo Repo, program Program) (bool, functionResponse) {
-	if _, err := os.Stat(repo.disabledPath); os.IsNotExist(err) {
-		return false, functionResponse{
-			exitCode:    0,
-			indentLevel: program.indentLevel + 1,
-		}
-	} else if err != nil {
+	disabled, err := repo.IsDisabled()
+	if err != nil {
 		return false, functionResponse{
 			exitCode:    1,
-			message:     "Failed to verify if repo is disabled -> " + err.Error(),
+			message:     formatErrorMessage(err),
 			logLevel:    "error",
 			indentLevel: program.indentLevel + 1,
 		}
-	} else {
-		return true, functionResponse{
-			exitCode: 0,
-		}
+	}
+
+	return disabled, functionResponse{
+		exitCode:    0,
+		indentLevel: program.indentLevel + 1,
 	}
 }
 
 func enableRepo(repo Repo, program Program) functionResponse {
-	if _, err := os.Stat(repo.disabledPath); os.IsNotExist(err) {
+	err := repo.Enable()
+	if errors.Is(err, pacpilot.ErrAlreadyEnabled) {
 		return functionResponse{
 			exitCode:    0,
 			message:     "Repo already enabled",
@@ -236,27 +184,17 @@ func enableRepo(repo Repo, program Program) functionResponse {
 	} else if err != nil {
 		return functionResponse{
 			exitCode:    1,
-			message:     "Failed to verify if repo is disabled -> " + err.Error(),
+			message:     formatErrorMessage(err),
 			logLevel:    "error",
 			indentLevel: program.indentLevel + 1,
 		}
-	} else {
-		err = os.Remove(repo.disabledPath)
-		if err != nil {
-			return functionResponse{
-				exitCode:    1,
-				message:     "Failed to remove disabled lock file -> " + err.Error(),
-				logLevel:    "error",
-				indentLevel: program.indentLevel + 1,
-			}
-		} else {
-			return functionResponse{
-				exitCode:    0,
-				message:     "Finished",
-				logLevel:    "success",
-				indentLevel: program.indentLevel + 1,
-			}
-		}
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
 	}
 }
 
@@ -278,38 +216,28 @@ func reposEnable(repos []Repo, program Program) functionResponse {
 }
 
 func disableRepo(repo Repo, program Program) functionResponse {
-	if _, err := os.Stat(repo.disabledPath); os.IsNotExist(err) {
-		file, err := os.Create(repo.disabledPath)
-		defer file.Close()
-		if err != nil {
-			return functionResponse{
-				exitCode:    1,
-				message:     "Failed to create disabled lock file -> " + err.Error(),
-				logLevel:    "error",
-				indentLevel: program.indentLevel + 1,
-			}
-		} else {
-			return functionResponse{
-				exitCode:    0,
-				message:     "Finished",
-				logLevel:    "success",
-				indentLevel: program.indentLevel + 1,
-			}
+	err := repo.Disable()
+	if errors.Is(err, pacpilot.ErrAlreadyDisabled) {
+		return functionResponse{
+			exitCode:    0,
+			message:     "Repo already disabled",
+			logLevel:    "attention",
+			indentLevel: program.indentLevel + 1,
 		}
 	} else if err != nil {
 		return functionResponse{
 			exitCode:    1,
-			message:     "Failed to verify if repo is enabled -> " + err.Error(),
+			message:     formatErrorMessage(err),
 			logLevel:    "error",
 			indentLevel: program.indentLevel + 1,
 		}
-	} else {
-		return functionResponse{
-			exitCode:    0,
-			message:     "Repo already disabled",
-			logLevel:    "attention",
-			indentLevel: program.indentLevel + 1,
-		}
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
 	}
 }
 
@@ -344,7 +272,7 @@ func verifyReposDirectories(repos []Repo, program Program) functionResponse {
 	if len(failedRepos) > 0 {
 		message := "The following repo(s) was/were not found:\n"
 		for _, repo := range failedRepos {
-			message = message + fmt.Sprintf("\n    - %s", repo.name)
+			message = message + fmt.Sprintf("\n    - %s", repo.Name)
 		}
 		response = functionResponse{
 			exitCode:    1,
@@ -362,7 +290,7 @@ func verifyReposDirectories(repos []Repo, program Program) functionResponse {
 }
 
 func verifyRepoDirectory(repo Repo, program Program) functionResponse {
-	if _, err := os.Stat(repo.path); os.IsNotExist(err) {
+	if exists, _ := repo.Exists(); exists == false {
 		return functionResponse{
 			exitCode: 1,
 		}
@@ -392,7 +320,7 @@ func removeRepoTempDirectory(repo Repo, showRepoName bool, notRemoveTempDir bool
 		return
 	}
 
-	if _, err := os.Stat(repo.tempDir); os.IsNotExist(err) {
+	if _, err := os.Stat(repo.TempDir); os.IsNotExist(err) {
 		response = functionResponse{
 			exitCode:    0,
 			logLevel:    "attention",
@@ -400,7 +328,7 @@ func removeRepoTempDirectory(repo Repo, showRepoName bool, notRemoveTempDir bool
 			indentLevel: program.indentLevel + 1,
 		}
 	} else {
-		err = os.RemoveAll(repo.tempDir)
+		err = os.RemoveAll(repo.TempDir)
 		if err != nil {
 			response = functionResponse{
 				exitCode:    1,
@@ -441,7 +369,7 @@ func setupRepoTempDirectory(repo Repo, showRepoName bool, notCreateTempDir bool,
 		return
 	}
 
-	if _, err := os.Stat(repo.tempDir); err == nil {
+	if _, err := os.Stat(repo.TempDir); err == nil {
 		response = functionResponse{
 			exitCode:    0,
 			message:     "Temporary directory already exists. Recreating it...",
@@ -450,7 +378,7 @@ func setupRepoTempDirectory(repo Repo, showRepoName bool, notCreateTempDir bool,
 		}
 		handleFunctionResponse(response, false)
 
-		err = os.RemoveAll(repo.tempDir)
+		err = os.RemoveAll(repo.TempDir)
 		if err != nil {
 			response = functionResponse{
 				exitCode:    1,
@@ -464,7 +392,7 @@ func setupRepoTempDirectory(repo Repo, showRepoName bool, notCreateTempDir bool,
 	}
 
 	perm := os.FileMode(0755)
-	err := os.Mkdir(repo.tempDir, perm)
+	err := os.Mkdir(repo.TempDir, perm)
 	if err != nil {
 		response = functionResponse{
 			exitCode:    1,
@@ -559,7 +487,7 @@ func reposCreate(program Program) functionResponse {
 	if response.exitCode == 0 {
 		return functionResponse{
 			exitCode:    1,
-			message:     fmt.Sprintf("Repo '%s' already exists", repo.name),
+			message:     fmt.Sprintf("Repo '%s' already exists", repo.Name),
 			logLevel:    "attention",
 			indentLevel: program.indentLevel + 1,
 		}
@@ -568,7 +496,7 @@ func reposCreate(program Program) functionResponse {
 	// Create repo directory
 	space()
 	showInfoSectionTitle("Creating repo directory", program.indentLevel+1)
-	err = os.Mkdir(repo.path, 0755)
+	err = os.Mkdir(repo.Path, 0755)
 	if err != nil {
 		return functionResponse{
 			exitCode:    1,
@@ -578,7 +506,7 @@ func reposCreate(program Program) functionResponse {
 		}
 	}
 
-	err = os.Mkdir(repo.targetsDir, 0755)
+	err = os.Mkdir(repo.TargetsDir, 0755)
 	if err != nil {
 		return functionResponse{
 			exitCode:    1,
@@ -605,10 +533,10 @@ func reposCreate(program Program) functionResponse {
 			PreserveOwner: true,
 		}
 
-		err = copy.Copy(repoTemplateDir, repo.path, copyOptions)
+		err = copy.Copy(repoTemplateDir, repo.Path, copyOptions)
 		if err != nil {
 			// Remove repo directory
-			_ = os.RemoveAll(repo.path)
+			_ = os.RemoveAll(repo.Path)
 
 			return functionResponse{
 				exitCode:    1,
@@ -630,8 +558,8 @@ func reposCreate(program Program) functionResponse {
 	// Run post_create hook (if any)
 	space()
 	showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf("post_create")+lightGray.Sprintf(" hook"), program.indentLevel+1)
-	if _, err := os.Stat(repo.hooksDir + "/post_create"); err == nil {
-		_, response := runHook(repo.hooksDir+"/post_create", repo.environment, true, true, true, true, true, incrementProgramIndentLevel(program, 1))
+	if _, err := os.Stat(repo.HooksDir + "/post_create"); err == nil {
+		_, response := runHook(repo.HooksDir+"/post_create", repo.Environment, nil, true, true, true, true, true, incrementProgramIndentLevel(program, 1))
 
 		if response.exitCode != 0 {
 			handleFunctionResponse(response, false)
@@ -640,7 +568,7 @@ func reposCreate(program Program) functionResponse {
 
 			// Remove repo directory
 			showInfoSectionTitle(lightGray.Sprintf("Removing repo directory"), program.indentLevel+1)
-			err = os.RemoveAll(repo.path)
+			err = os.RemoveAll(repo.Path)
 
 			if err != nil {
 				response = functionResponse{
@@ -720,7 +648,7 @@ func reposRm(repos []Repo, program Program) functionResponse {
 			for _, target := range targets {
 				showInfoSectionTitle(displayTargetTag(lightGray.Sprintf("Running ")+gray.Sprintf("pre_rm")+lightGray.Sprintf(" hook"), target), program.indentLevel+1)
 
-				if _, err := os.Stat(target.hooksDir + "/pre_rm"); os.IsNotExist(err) {
+				if _, err := os.Stat(target.HooksDir + "/pre_rm"); os.IsNotExist(err) {
 					response = functionResponse{
 						exitCode:    0,
 						message:     "Hook not found",
@@ -731,7 +659,7 @@ func reposRm(repos []Repo, program Program) functionResponse {
 				} else {
 					program := incrementProgramIndentLevel(program, 1)
 
-					_, response := runHook(target.hooksDir+"/pre_rm", target.environment, true, true, true, true, true, program)
+					_, response := runHook(target.HooksDir+"/pre_rm", target.Environment, nil, true, true, true, true, true, program)
 					response.indentLevel = program.indentLevel + 2
 					handleFunctionResponse(response, true)
 				}
@@ -753,7 +681,7 @@ func reposRm(repos []Repo, program Program) functionResponse {
 
 		showInfoSectionTitle(displayRepoTag(lightGray.Sprintf("Running ")+gray.Sprintf("pre_rm")+lightGray.Sprintf(" hook"), repo), program.indentLevel+1)
 
-		if _, err := os.Stat(repo.hooksDir + "/pre_rm"); os.IsNotExist(err) {
+		if _, err := os.Stat(repo.HooksDir + "/pre_rm"); os.IsNotExist(err) {
 			response = functionResponse{
 				exitCode:    0,
 				message:     "Hook not found",
@@ -764,14 +692,14 @@ func reposRm(repos []Repo, program Program) functionResponse {
 		} else {
 			program = incrementProgramIndentLevel(program, 1)
 
-			_, response := runHook(repo.hooksDir+"/pre_rm", repo.environment, true, true, true, true, true, program)
+			_, response := runHook(repo.HooksDir+"/pre_rm", repo.Environment, nil, true, true, true, true, true, program)
 			response.indentLevel = program.indentLevel + 2
 			handleFunctionResponse(response, true)
 		}
 
 		space()
 
-		err := os.RemoveAll(repo.path)
+		err := os.RemoveAll(repo.Path)
 		if err != nil {
 			response = functionResponse{
 				exitCode:    1,
@@ -810,7 +738,7 @@ func reposLs(program Program) functionResponse {
 
 	for _, repo := range repos {
 		// Get optional description (if hook exists)
-		repoDescription, response := runHook(repo.hooksDir+"/ls", repo.environment, false, false, false, false, false, program)
+		repoDescription, response := runHook(repo.HooksDir+"/ls", repo.Environment, nil, false, false, false, false, false, program)
 		repoDescriptionString := repoDescription.Output
 
 		var description string
@@ -830,7 +758,7 @@ func reposLs(program Program) functionResponse {
 			description += fmt.Sprintf("[%s]", red.Sprintf("disabled"))
 		}
 
-		showText(fmt.Sprintf(" - %s %s", repo.name, description), program.indentLevel+1)
+		showText(fmt.Sprintf(" - %s %s", repo.Name, description), program.indentLevel+1)
 	}
 
 	return functionResponse{
@@ -838,7 +766,7 @@ func reposLs(program Program) functionResponse {
 	}
 }
 
-func reposRunHooks(repos []Repo, hooks []string, notCreateTempDir bool, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, notPrintAlerts bool, program Program) functionResponse {
+func reposRunHooks(repos []Repo, hooks []string, notCreateTempDir bool, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, notPrintAlerts bool, lock lockMode, hookArgs []string, hookEnv map[string]string, program Program) functionResponse {
 	for index, repo := range repos {
 		space()
 		space()
@@ -868,6 +796,14 @@ func reposRunHooks(repos []Repo, hooks []string, notCreateTempDir bool, notRemov
 
 		program = incrementProgramIndentLevel(program, 1)
 
+		repoLock, response := acquireCLILock(repoLockPath(repo), repo.Name, lock, program)
+		if response.exitCode != 0 {
+			handleFunctionResponse(response, false)
+
+			space()
+			finishProgram(response.exitCode)
+		}
+
 		setupRepoTempDirectory(repo, false, notCreateTempDir, program)
 
 		response = func(repo Repo, hooks []string, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, program Program) functionResponse {
@@ -878,7 +814,7 @@ func reposRunHooks(repos []Repo, hooks []string, notCreateTempDir bool, notRemov
 				showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook"), program.indentLevel)
 
 				// Run hook
-				if _, err := os.Stat(repo.hooksDir + "/" + hook); os.IsNotExist(err) {
+				if _, err := os.Stat(repo.HooksDir + "/" + hook); os.IsNotExist(err) {
 					response = functionResponse{
 						exitCode:    1,
 						message:     fmt.Sprintf("No '%v' hook found", hook),
@@ -887,7 +823,7 @@ func reposRunHooks(repos []Repo, hooks []string, notCreateTempDir bool, notRemov
 					}
 					handleFunctionResponse(response, false)
 				} else {
-					_, hookResponse := runHook(repo.hooksDir+"/"+hook, repo.environment, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
+					_, hookResponse := runHook(repo.HooksDir+"/"+hook, mergeEnvironment(repo.Environment, hookEnv), hookArgs, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
 
 					if hookResponse.exitCode != 0 {
 						hookResponse.indentLevel = program.indentLevel + 1
@@ -909,6 +845,12 @@ func reposRunHooks(repos []Repo, hooks []string, notCreateTempDir bool, notRemov
 			}
 		}(repo, hooks, notRemoveTempDir, notPrintOutput, notPrintEntryCmd, program)
 
+		// Repo hooks may have changed the targets' databases
+		targets, _ := getRepoTargets(repo, program)
+		recordTargetsDatabaseEvents(targets, program)
+
+		repoLock.release()
+
 		if response.exitCode != 0 {
 			handleFunctionResponse(response, false)
 
@@ -943,50 +885,22 @@ func reposHooksLs(repos []Repo, program Program) functionResponse {
 		orange.Println(fmt.Sprintf("(%v/%v)", index+1, len(repos)))
 		showInfoSectionTitle(displayRepoTag("Listing hooks", repo), program.indentLevel)
 
-		hooks, err := ioutil.ReadDir(repo.hooksDir)
+		hooks, err := pacpilot.GetHooks(getLibraryConfig(program), repo.HooksDir)
 		if err != nil {
 			return functionResponse{
 				exitCode:    1,
-				message:     fmt.Sprintf("Error reading the hooks directory -> " + err.Error()),
+				message:     formatErrorMessage(err),
 				logLevel:    "error",
 				indentLevel: program.indentLevel + 1,
 			}
 		}
 
-		// Filter out .entry files
-		filteredHooks := make([]os.FileInfo, 0)
-		for _, element := range hooks {
-			if !strings.HasSuffix(element.Name(), ".entry") {
-				filteredHooks = append(filteredHooks, element)
-			}
-		}
-		filteredHooks = filterHiddenFilesAndDirectories(filteredHooks)
-
-		if len(filteredHooks) == 0 {
+		if len(hooks) == 0 {
 			showAttention("No hooks found", program.indentLevel)
 		}
 
-		for _, element := range filteredHooks {
-			// Verify if hook has custom entry command
-			var entryCommand string
-			customEntryFilePath := repo.hooksDir + "/" + element.Name() + ".entry"
-			if _, err = os.Stat(customEntryFilePath); err == nil {
-				contents, err := ioutil.ReadFile(customEntryFilePath)
-				if err != nil {
-					return functionResponse{
-						exitCode:    1,
-						message:     fmt.Sprintf("Failed to read custom entry configuration file for hook " + element.Name() + " -> " + err.Error()),
-						logLevel:    "error",
-						indentLevel: program.indentLevel,
-					}
-				}
-				entryCommand = string(contents)
-				entryCommand = strings.TrimLeft(entryCommand, "\n")
-				entryCommand = strings.TrimRight(entryCommand, "\n")
-			} else {
-				entryCommand = program.defaultShell
-			}
-			showText(fmt.Sprintf("- %s (%s)", element.Name(), coral.Sprintf(entryCommand)), program.indentLevel+1)
+		for _, hook := range hooks {
+			showHook(hook, program)
 		}
 	}
 
@@ -6,15 +6,17 @@ package main
 
 import (
 	// Modules in GOROOT
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 
 	// External modules
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
 	gin "github.com/gin-gonic/gin"
 )
 
@@ -22,7 +24,20 @@ import (
 //// REPOS (SERVER FUNCTIONALITY)
 //
 
-func reposServe(serverPort string, debugMode bool, trustedProxies []string, program Program) functionResponse {
+func reposServe(serverPort string, debugMode bool, trustedProxies []string, tlsOptions serverTLS, limits serverLimits, program Program) functionResponse {
+	err := validateServerTLS(tlsOptions)
+	if err == nil {
+		err = validateServerLimits(limits)
+	}
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     formatErrorMessage(err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
 	//
 	//// PRINT SERVER INFORMATION
 	//
@@ -31,13 +46,56 @@ func reposServe(serverPort string, debugMode bool, trustedProxies []string, prog
 	fmt.Println(blue.Sprintf("Starting server"))
 	fmt.Println(fmt.Sprintf("Port: %s", paleLime.Sprintf(serverPort)))
 	fmt.Println(fmt.Sprintf("Debug mode: %s", paleLime.Sprintf(strconv.FormatBool(debugMode))))
+	if tlsOptions.enabled() == true {
+		fmt.Println(fmt.Sprintf("TLS certificate: %s", paleLime.Sprintf(tlsOptions.certPath)))
+		if tlsOptions.redirectPort != "" {
+			fmt.Println(fmt.Sprintf("HTTP redirect port: %s", paleLime.Sprintf(tlsOptions.redirectPort)))
+		}
+		if tlsOptions.clientCAPath != "" {
+			fmt.Println(fmt.Sprintf("Client CA bundle (mTLS): %s", paleLime.Sprintf(tlsOptions.clientCAPath)))
+		}
+	} else {
+		showAttention("TLS: disabled", program.indentLevel)
+	}
 	fmt.Println("Trusted proxies:")
 	if len(trustedProxies) > 0 {
 		for _, proxy := range trustedProxies {
 			fmt.Println(fmt.Sprintf("  - %s", paleLime.Sprintf(proxy)))
 		}
 	} else {
-		showAttention("  - All proxies are trusted", program.indentLevel)
+		fmt.Println(fmt.Sprintf("  - %s", paleLime.Sprintf("none (the client address is the address of the connection)")))
+	}
+	if limits.requestRate > 0 {
+		fmt.Println(fmt.Sprintf("Rate limit: %s", paleLime.Sprintf("%g requests/s per client address (burst of %d)", limits.requestRate, limits.requestBurst)))
+	} else {
+		showAttention("Rate limit: disabled", program.indentLevel)
+	}
+	if limits.bandwidth > 0 {
+		fmt.Println(fmt.Sprintf("Bandwidth limit: %s", paleLime.Sprintf("%s/s", formatBytes(limits.bandwidth))))
+	}
+	if limits.clientBandwidth > 0 {
+		fmt.Println(fmt.Sprintf("Bandwidth limit per client: %s", paleLime.Sprintf("%s/s", formatBytes(limits.clientBandwidth))))
+	}
+	if limits.clientDownloads > 0 {
+		fmt.Println(fmt.Sprintf("Concurrent downloads per client: %s", paleLime.Sprintf(strconv.Itoa(limits.clientDownloads))))
+	}
+	if tlsOptions.clientCAPath != "" {
+		if clients, err := loadAPIClients(program); err != nil {
+			showError(fmt.Sprintf("API clients: %v", err), program.indentLevel)
+		} else {
+			fmt.Println(fmt.Sprintf("API clients: %s (tokens are not used in mTLS mode)", paleLime.Sprintf(strconv.Itoa(len(clients)))))
+		}
+	} else if tokens, err := loadTokens(program); err != nil {
+		showError(fmt.Sprintf("API tokens: %v", err), program.indentLevel)
+	} else if len(tokens) == 0 {
+		showAttention("API tokens: none (the API cannot be used until a token is created with 'tokens create')", program.indentLevel)
+	} else {
+		fmt.Println(fmt.Sprintf("API tokens: %s", paleLime.Sprintf(strconv.Itoa(len(tokens)))))
+	}
+	if userCount, err := newHtpasswdFile(program.htpasswdPath).count(); err != nil {
+		showError(fmt.Sprintf("Users (htpasswd): %v", err), program.indentLevel)
+	} else {
+		fmt.Println(fmt.Sprintf("Users (htpasswd): %s", paleLime.Sprintf(strconv.Itoa(userCount))))
 	}
 	space()
 
@@ -51,27 +109,117 @@ func reposServe(serverPort string, debugMode bool, trustedProxies []string, prog
 
 	router := gin.Default()
 
-	router.SetTrustedProxies(trustedProxies)
+	// Without trusted proxies, the X-Forwarded-For header is ignored (the
+	// network allowlists and rate limits rely on the client address)
+	err = router.SetTrustedProxies(trustedProxies)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Invalid trusted proxy -> %v", err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	if limits.requestRate > 0 {
+		router.Use(rateLimit(newRateLimiter(limits.requestRate, limits.requestBurst), program))
+	}
 
 	// Set a lower memory limit for multipart forms (default is 32 MiB)
 	router.MaxMultipartMemory = 8 << 20 // 8 MiB
 
+	// All POST routes (the API) require a token or, in mTLS mode, a client
+	// certificate
+	router.Use(requireAPICredential(tlsOptions.clientCAPath != "", program))
+
+	//
+	//// SERVER INDEX
+	//
+
+	index, err := newServerIndex(program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to index the repos directory -> %v", err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	//
+	//// USERS (PRIVATE REPOS AND TARGETS)
+	//
+
+	users := newHtpasswdFile(program.htpasswdPath)
+
+	//
+	//// DOWNLOAD LIMITS
+	//
+
+	downloads := newDownloadLimiter(limits)
+
+	//
+	//// HOOK JOBS
+	//
+
+	jobs, err := newJobManager(program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to load the job history -> %v", err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	//
+	//// CHECKSUM CACHE
+	//
+
+	checksums, err := newChecksumCache(program.cacheDir + "/checksums.json")
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to load checksum cache -> %v", err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	// Hash the files of all enabled targets in the background
+	go func() {
+		for _, repo := range index.getEnabledRepos() {
+			for _, target := range index.getEnabledTargets(repo.Name) {
+				checksums.warm(target.PoolDir)
+			}
+		}
+	}()
+
 	//
 	//// GIN ROUTES
 	//
 
 	router.GET("/", func(c *gin.Context) {
 		// Get enabled repos
-		repos := getEnabledRepos(program)
+		repos := index.getEnabledRepos()
 
 		// Create an HTML response
 		c.Header("Content-Type", "text/html")
 		c.Writer.Write([]byte("<h1> Index of " + "/" + " </h1>"))
 		c.Writer.Write([]byte("<pre>\n"))
 
+		client := getAccessClient(c, users, program)
+		clientIP := net.ParseIP(c.ClientIP())
+
 		for _, repo := range repos {
+			// Private repos (and repos that the client's address cannot
+			// download from) are hidden from clients that cannot read them
+			if index.canRead(client, repo.Name, "") == false || index.allowsAddress(clientIP, repo.Name, "", false) == false {
+				continue
+			}
+
 			// For each repo, create a link and append to the response
-			c.Writer.Write([]byte("<a href=\"" + "/repos/" + repo.name + "\">" + repo.name + "</a>\n"))
+			c.Writer.Write([]byte("<a href=\"" + "/repos/" + repo.Name + "\">" + repo.Name + "</a>\n"))
 		}
 
 		c.Writer.Write([]byte("</pre>\n"))
@@ -85,51 +233,71 @@ func reposServe(serverPort string, debugMode bool, trustedProxies []string, prog
 	})
 
 	router.GET("/repos/:repo", func(c *gin.Context) {
-		repoName := c.Param("repo")
-		repo := generateRepoObj(repoName, program)
-
 		// Verify if repo exists or is enabled
-		repoVerify := verifyRepoDirectory(repo, program)
-		status, response := isRepoDisabled(repo, program)
-		handleFunctionResponse(response, true)
+		repo, err := index.getRepo(c.Param("repo"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
 
-		if repoVerify.exitCode != 0 || status == true {
-			c.JSON(http.StatusNotFound, gin.H{
-				"message": "The requested repo could not be found.",
-			})
+		// Verify if the client can read the repo
+		if authorizeRead(c, index, users, repo.Name, "", program) == false {
 			return
 		}
 
 		// Get enabled targets
-		targets := getEnabledTargets(repo, program)
+		targets := index.getEnabledTargets(repo.Name)
+		client := getAccessClient(c, users, program)
+		clientIP := net.ParseIP(c.ClientIP())
 
 		// Create an HTML response
 		c.Header("Content-Type", "text/html")
-		c.Writer.Write([]byte("<h1> Index of " + "/repos/" + repo.name + " </h1>"))
+		c.Writer.Write([]byte("<h1> Index of " + "/repos/" + repo.Name + " </h1>"))
 		c.Writer.Write([]byte("<pre>\n"))
 		c.Writer.Write([]byte("<a href=\"" + "../" + "\">" + "../" + "</a>\n"))
 
 		for _, target := range targets {
+			// Private targets (and targets that the client's address cannot
+			// download from) are hidden from clients that cannot read them
+			if index.canRead(client, repo.Name, target.Name) == false || index.allowsAddress(clientIP, repo.Name, target.Name, false) == false {
+				continue
+			}
+
 			// For each target, create a link and append to the response
-			c.Writer.Write([]byte("<a href=\"" + "/repos/" + repo.name + "/" + target.name + "/tree" + "\">" + target.name + "</a>\n"))
+			c.Writer.Write([]byte("<a href=\"" + "/repos/" + repo.Name + "/" + target.Name + "/tree" + "\">" + target.Name + "</a>\n"))
 		}
 
 		c.Writer.Write([]byte("</pre>\n"))
 	})
 
 	router.GET("/repos/:repo/:target", func(c *gin.Context) {
-		repoName := c.Param("repo")
-		repo := generateRepoObj(repoName, program)
-		targetName := c.Param("target")
-		target := generateTargetObj(repoName, targetName, program)
+		// Verify if repo exists or is enabled
+		repo, err := index.getRepo(c.Param("repo"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
+
+		// Verify if target exists or is enabled
+		target, err := index.getTarget(repo.Name, c.Param("target"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
+
+		// Verify if the client can read the target
+		if authorizeRead(c, index, users, repo.Name, target.Name, program) == false {
+			return
+		}
 
 		// Create an HTML response
 		c.Header("Content-Type", "text/html")
-		c.Writer.Write([]byte("<h1> Index of /repos/" + repo.name + "/" + target.name + "</h1>"))
+		c.Writer.Write([]byte("<h1> Index of /repos/" + repo.Name + "/" + target.Name + "</h1>"))
 		c.Writer.Write([]byte("<p>These are the available subdirectories for targets:</p>"))
 		c.Writer.Write([]byte("<ul>"))
-		c.Writer.Write([]byte("<li>" + "<a href=\"" + "/repos/" + repo.name + "/" + target.name + "/tree" + "\">" + "tree" + "</a>" + "</li>"))
-		c.Writer.Write([]byte("<li>" + "<a href=\"" + "/repos/" + repo.name + "/" + target.name + "/api" + "\">" + "api" + "</a>" + " (requires an action as a subdirectory, e.g., `api/upload`)</li>"))
+		c.Writer.Write([]byte("<li>" + "<a href=\"" + "/repos/" + repo.Name + "/" + target.Name + "/tree" + "\">" + "tree" + "</a>" + "</li>"))
+		c.Writer.Write([]byte("<li>" + "<a href=\"" + "/repos/" + repo.Name + "/" + target.Name + "/api" + "\">" + "api" + "</a>" + " (requires an action as a subdirectory, e.g., `api/upload`)</li>"))
+		c.Writer.Write([]byte("<li>" + "<a href=\"" + "/repos/" + repo.Name + "/" + target.Name + "/pacman.conf" + "\">" + "pacman.conf" + "</a>" + " (client configuration)</li>"))
 		c.Writer.Write([]byte("</ul>"))
 	})
 
@@ -152,33 +320,35 @@ func reposServe(serverPort string, debugMode bool, trustedProxies []string, prog
 	})
 
 	router.POST("/repos/:repo/:target/api/:action", func(c *gin.Context) {
-		repoName := c.Param("repo")
-		repo := generateRepoObj(repoName, program)
-		targetName := c.Param("target")
-		target := generateTargetObj(repoName, targetName, program)
 		action := c.Param("action")
 
-		// Verify if repo exists or is enabled
-		repoVerify := verifyRepoDirectory(repo, program)
-		status, response := isRepoDisabled(repo, program)
-		handleFunctionResponse(response, true)
+		// The action is used as a hook name
+		if err := validateFileName(action); err != nil {
+			respondWithError(c, err, program)
+			return
+		}
 
-		if repoVerify.exitCode != 0 || status == true {
-			c.JSON(http.StatusNotFound, gin.H{
-				"message": "The requested repo could not be found.",
-			})
+		// Verify if repo exists or is enabled
+		repo, err := index.getRepo(c.Param("repo"))
+		if err != nil {
+			respondWithError(c, err, program)
 			return
 		}
 
 		// Verify if target exists or is enabled
-		targetVerify := verifyTargetDirectory(target, program)
-		status, response = isTargetDisabled(target, program)
-		handleFunctionResponse(response, true)
+		target, err := index.getTarget(repo.Name, c.Param("target"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
 
-		if targetVerify.exitCode != 0 || status == true {
-			c.JSON(http.StatusNotFound, gin.H{
-				"message": "The requested target could not be found.",
-			})
+		// Verify if the client's address can use the API of the target
+		if authorizeAddress(c, index, repo.Name, target.Name, true, program) == false {
+			return
+		}
+
+		// Verify if the client is allowed to run the action on the target
+		if authorizeAPIRequest(c, target, action, program) == false {
 			return
 		}
 
@@ -196,10 +366,124 @@ func reposServe(serverPort string, debugMode bool, trustedProxies []string, prog
 			}
 			files := form.File["upload[]"]
 
-			for _, file := range files {
-				showAttention(fmt.Sprintf("=> Uploading file '%s' to '%s'", file.Filename, target.poolDir+"/"+file.Filename), program.indentLevel)
+			settings, err := loadTargetSettings(target)
+			if err == nil {
+				err = validateLintSettings(settings)
+			}
+			if err == nil {
+				err = validateUploadSettings(settings)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "Invalid target configuration -> " + err.Error(),
+				})
+				return
+			}
+
+			// Verify all file names before saving any file
+			destinationPaths := make([]string, len(files))
+			for i, file := range files {
+				err := validateFileName(file.Filename)
+				if err == nil {
+					err = checkUploadPolicy(settings.Uploads, file.Filename)
+				}
+				if err == nil {
+					destinationPaths[i], err = containPath(target.PoolDir, file.Filename)
+				}
+				if err != nil {
+					respondWithError(c, err, program)
+					return
+				}
+			}
+
+			targetArch := targetArchitecture(target, settings)
+
+			// Files are saved to a staging directory and only moved into the
+			// pool once all of them have been verified, so that a rejected
+			// file does not leave the others (or its signature) in the pool
+			stagingDir, err := os.MkdirTemp(target.PoolDir, ".upload-")
+			if err != nil {
+				respondWithError(c, fmt.Errorf("failed to create staging directory -> %v", err), program)
+				return
+			}
+			defer os.RemoveAll(stagingDir)
+
+			stagingPaths := make([]string, len(files))
+			for i, file := range files {
+				stagingPaths[i] = stagingDir + "/" + file.Filename
+
+				err := c.SaveUploadedFile(file, stagingPaths[i])
+				if err != nil {
+					respondWithError(c, fmt.Errorf("failed to save file '%s' -> %v", file.Filename, err), program)
+					return
+				}
+			}
+
+			// Packages are verified when the target has an architecture or the
+			// upload gate is enabled
+			var reports []lintReport
+			for i, file := range files {
+				if isPackageFile(file.Filename) == false {
+					continue
+				}
+
+				if targetArch != "" {
+					pkg, err := readPackageArchive(stagingPaths[i])
+					if err == nil {
+						err = verifyPackageArchitecture(pkg.field("arch"), targetArch)
+					}
+					if err != nil {
+						showAttention(fmt.Sprintf("=> Rejected file '%s' -> %v", file.Filename, err), program.indentLevel)
+
+						c.JSON(http.StatusBadRequest, gin.H{
+							"message": fmt.Sprintf("File '%s' was rejected -> %v", file.Filename, err),
+						})
+						return
+					}
+				}
+
+				if settings.Lint.UploadGate == true {
+					reports = append(reports, lintPackage(stagingPaths[i], file.Filename, target, settings))
+				}
+			}
+
+			rejectedFiles := 0
+			for _, report := range reports {
+				if report.Errors > 0 {
+					rejectedFiles++
+
+					showAttention(fmt.Sprintf("=> Rejected file '%s' (%d lint error(s))", report.File, report.Errors), program.indentLevel)
+				}
+			}
+
+			if rejectedFiles > 0 {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{
+					"message": fmt.Sprintf("%d file(s) failed lint checks. No files were uploaded.", rejectedFiles),
+					"lint":    reports,
+				})
+				return
+			}
+
+			for i, file := range files {
+				showAttention(fmt.Sprintf("=> Uploading file '%s' to '%s'", file.Filename, destinationPaths[i]), program.indentLevel)
 
-				c.SaveUploadedFile(file, target.poolDir+"/"+file.Filename)
+				err := os.Rename(stagingPaths[i], destinationPaths[i])
+				if err != nil {
+					respondWithError(c, fmt.Errorf("failed to move file '%s' into the pool -> %v", file.Filename, err), program)
+					return
+				}
+			}
+
+			if settings.Lint.UploadGate == true {
+				if reports == nil {
+					reports = []lintReport{}
+				}
+
+				c.JSON(http.StatusOK, gin.H{
+					"message": fmt.Sprintf("%d file(s) uploaded.", len(files)),
+					"lint":    reports,
+				})
+				return
 			}
 
 			c.JSON(http.StatusOK, gin.H{
@@ -207,65 +491,154 @@ func reposServe(serverPort string, debugMode bool, trustedProxies []string, prog
 			})
 			return
 		} else {
-			// Run hook
-			if _, err := os.Stat(target.hooksDir + "/" + action); os.IsNotExist(err) {
+			hook, err := pacpilot.GetHook(getLibraryConfig(program), target.HooksDir, action)
+			if errors.Is(err, pacpilot.ErrHookNotFound) {
 				c.JSON(http.StatusNotFound, gin.H{
 					"message": fmt.Sprintf("Hook '%s' not found", action),
 				})
-			} else {
-				completedCmd, hookResponse := runHook(target.hooksDir+"/"+action, target.environment, false, false, false, false, false, program)
-
-				if hookResponse.exitCode != 0 {
-					c.JSON(http.StatusInternalServerError, gin.H{
-						"message": fmt.Sprintf("Hook finished with the following exit code: %v", hookResponse.exitCode),
-						"command": gin.H{
-							"exitCode": hookResponse.exitCode,
-							"output":   completedCmd.Output,
-						},
-					})
-				} else {
-					c.JSON(http.StatusOK, gin.H{
-						"message": "Hook finished running successfully",
-						"command": gin.H{
-							"exitCode": hookResponse.exitCode,
-							"output":   completedCmd.Output,
-						},
-					})
-				}
+				return
+			} else if err != nil {
+				respondWithError(c, err, program)
+				return
 			}
+
+			if hook.API == false {
+				rejectAPIRequest(c, http.StatusForbidden, fmt.Sprintf("hook '%s' of '%s/%s' is not available through the API", action, target.Repo.Name, target.Name), program)
+				return
+			}
+
+			lock, err := parseLockMode(c.DefaultQuery("lock", "wait"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": formatErrorMessage(err),
+				})
+				return
+			}
+
+			args, env, err := parseHookRequest(c, hook)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": formatErrorMessage(err),
+				})
+				return
+			}
+
+			// Run the hook in the background, as it may take longer than
+			// clients (and proxies) wait for a response
+			credential, _ := c.Get("apiCredential")
+			job, err := jobs.start(target, hook, args, env, credential.(apiCredential).identity, lock)
+			var lockedErr *lockedError
+			if errors.As(err, &lockedErr) {
+				c.JSON(http.StatusConflict, gin.H{
+					"message": formatErrorMessage(err),
+					"lock":    lockedErr.holder,
+				})
+				return
+			} else if err != nil {
+				respondWithError(c, err, program)
+				return
+			}
+
+			message := "Hook started"
+			if job.Status == jobWaiting {
+				message = "Hook waiting for the target lock"
+			}
+
+			c.Header("Location", "/jobs/"+job.ID)
+			c.JSON(http.StatusAccepted, gin.H{
+				"message": message,
+				"job":     job,
+			})
 		}
 	})
 
+	router.GET("/jobs/:id", func(c *gin.Context) {
+		job, ok := getAuthorizedJob(c, jobs, index, program)
+		if ok == false {
+			return
+		}
+
+		c.JSON(http.StatusOK, job)
+	})
+
+	router.GET("/jobs/:id/stream", func(c *gin.Context) {
+		ansiMode := c.DefaultQuery("ansi", ansiKeep)
+		if err := validateANSIMode(ansiMode); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": formatErrorMessage(err),
+			})
+			return
+		}
+
+		job, ok := getAuthorizedJob(c, jobs, index, program)
+		if ok == false {
+			return
+		}
+
+		streamJob(c, jobs, job, ansiMode)
+	})
+
+	router.GET("/jobs/:id/terminal", func(c *gin.Context) {
+		job, ok := getAuthorizedJob(c, jobs, index, program)
+		if ok == false {
+			return
+		}
+
+		attachTerminal(c, jobs, job, program)
+	})
+
+	router.GET("/terminal", func(c *gin.Context) {
+		c.Header("Content-Type", "text/html")
+		c.Writer.Write([]byte(terminalPage))
+	})
+
+	router.DELETE("/jobs/:id", func(c *gin.Context) {
+		job, ok := getAuthorizedJob(c, jobs, index, program)
+		if ok == false {
+			return
+		}
+
+		err := jobs.cancel(job.ID)
+		if errors.Is(err, errJobFinished) {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": "The job has already finished.",
+				"job":     job,
+			})
+			return
+		} else if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
+
+		credential, _ := c.Get("apiCredential")
+		showAttention(fmt.Sprintf("=> Job %s: canceled by %s", job.ID, credential.(apiCredential).identity), program.indentLevel)
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "Job canceled",
+		})
+	})
+
 	router.GET("/repos/:repo/:target/tree/*filepath", func(c *gin.Context) {
-		repoName := c.Param("repo")
-		repo := generateRepoObj(repoName, program)
-		targetName := c.Param("target")
-		target := generateTargetObj(repoName, targetName, program)
 		resourcePath := c.Param("filepath")
 		//resourcePath = strings.TrimPrefix(resourcePath, "/")
 		resourcePath = strings.TrimSuffix(resourcePath, "/")
 
 		// Verify if repo exists or is enabled
-		repoVerify := verifyRepoDirectory(repo, program)
-		status, response := isRepoDisabled(repo, program)
-		handleFunctionResponse(response, true)
-
-		if repoVerify.exitCode != 0 || status == true {
-			c.JSON(http.StatusNotFound, gin.H{
-				"message": "The requested repo could not be found.",
-			})
+		repo, err := index.getRepo(c.Param("repo"))
+		if err != nil {
+			respondWithError(c, err, program)
 			return
 		}
 
 		// Verify if target exists or is enabled
-		targetVerify := verifyTargetDirectory(target, program)
-		status, response = isTargetDisabled(target, program)
-		handleFunctionResponse(response, true)
+		target, err := index.getTarget(repo.Name, c.Param("target"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
 
-		if targetVerify.exitCode != 0 || status == true {
-			c.JSON(http.StatusNotFound, gin.H{
-				"message": "The requested target could not be found.",
-			})
+		// Verify if the client can read the target
+		if authorizeRead(c, index, users, repo.Name, target.Name, program) == false {
 			return
 		}
 
@@ -273,86 +646,395 @@ func reposServe(serverPort string, debugMode bool, trustedProxies []string, prog
 		////
 		//
 
-		// Join with the base directory to get full file path
-		fullPath := filepath.Join(target.poolDir, resourcePath)
+		serveTargetResource(c, target, resourcePath, "/repos/"+repo.Name+"/"+target.Name+"/tree", index, checksums, downloads)
+	})
+
+	router.GET("/repos/:repo/:target/pkg/*filepath", func(c *gin.Context) {
+		resourcePath := c.Param("filepath")
 
-		// Check if the path is a directory or a file
-		info, err := os.Stat(fullPath)
+		// Verify if repo exists or is enabled
+		repo, err := index.getRepo(c.Param("repo"))
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{
-				"message": "The requested resource could not be found.",
-			})
+			respondWithError(c, err, program)
+			return
+		}
+
+		// Verify if target exists or is enabled
+		target, err := index.getTarget(repo.Name, c.Param("target"))
+		if err != nil {
+			respondWithError(c, err, program)
 			return
 		}
 
-		if info.IsDir() {
-			// If it's a directory, generate a directory listing
-			files, err := ioutil.ReadDir(fullPath)
+		// Verify if the client can read the target
+		if authorizeRead(c, index, users, repo.Name, target.Name, program) == false {
+			return
+		}
+
+		servePackageDetails(c, target, resourcePath, index)
+	})
+
+	// Serves an Atom feed with the package events of the given targets. The
+	// events are recorded when the targets' databases change (after hooks run
+	// or when the index sees a change in the pool), not when the feed is read.
+	serveTargetsFeed := func(c *gin.Context, repo Repo, targets []Target, title string, feedPath string, pagePath string) {
+		var events []packageEvent
+
+		for _, target := range targets {
+			targetEvents, _, err := loadTargetEvents(target)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{
-					"message": "Internal Server Error: failed to read directory",
+					"message": "Failed to read package events.",
 				})
 				return
 			}
 
-			// Create an HTML response
-			c.Header("Content-Type", "text/html")
-			c.Writer.Write([]byte("<h1> Index of " + "/repos/" + repo.name + "/" + target.name + resourcePath + " </h1>"))
-			c.Writer.Write([]byte("<pre>\n"))
-			c.Writer.Write([]byte("<a href=\"" + "../" + "\">" + "../" + "</a>\n"))
-
-			for _, file := range files {
-				fileName := strings.ReplaceAll(file.Name(), " ", "%20")
-				modTime := file.ModTime().Format(http.TimeFormat) // Format as HTTP date
-
-				var fileInfoMD5 string
-				var fileInfoSHA256 string
-				var size string
-
-				if file.IsDir() {
-					size = "-" // Use '-' for directories
-					fileInfoMD5 = "-"
-					fileInfoSHA256 = "-"
-
-					linkData := fmt.Sprintf("<a href=\"/repos/%s/%s/tree%s/%s\">%s</a>",
-						repo.name, target.name, resourcePath, fileName, file.Name())
-					fileData := fmt.Sprintf("<p>     %s     %s</p>", modTime, size)
-					//fileData = strings.ReplaceAll(fileData, " ", "&nbsp;")
-					linedata := linkData + fileData + "\n"
-					c.Writer.Write([]byte(linedata))
-				} else {
-					size = formatBytes(file.Size())
+			events = append(events, targetEvents.Events...)
+		}
 
-					fileInfoMD5, err = calculateMD5(fullPath + "/" + fileName)
-					if err != nil {
-						fileInfoMD5 = "<i>Failed to calculate</i>"
-					}
+		baseURL := getRequestBaseURL(c)
+		if settings, err := loadRepoSettings(repo); err == nil && len(settings.PublicURLs) > 0 {
+			baseURL = strings.TrimSuffix(settings.PublicURLs[0], "/")
+		}
 
-					fileInfoSHA256, err = calculateSHA256(fullPath + "/" + fileName)
-					if err != nil {
-						fileInfoSHA256 = "<i>Failed to calculate</i>"
-					}
+		feed, err := generateAtomFeed(title, feedPath, pagePath, baseURL, events)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to generate feed.",
+			})
+			return
+		}
 
-					linkData := fmt.Sprintf("<a href=\"/repos/%s/%s/tree%s/%s\">%s</a>",
-						repo.name, target.name, resourcePath, fileName, file.Name())
-					fileData := fmt.Sprintf("<p>     %s     %s</p><p>     <b>MD5:</b>%s</p><p>     <b>SHA256:</b>%s</p>", modTime, size, fileInfoMD5, fileInfoSHA256)
-					//fileData = strings.ReplaceAll(fileData, " ", "&nbsp;")
-					linedata := linkData + fileData + "\n"
-					c.Writer.Write([]byte(linedata))
-				}
+		c.Data(http.StatusOK, "application/atom+xml; charset=utf-8", feed)
+	}
+
+	router.GET("/repos/:repo/feed.atom", func(c *gin.Context) {
+		// Verify if repo exists or is enabled
+		repo, err := index.getRepo(c.Param("repo"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
+
+		// Verify if the client can read the repo
+		if authorizeRead(c, index, users, repo.Name, "", program) == false {
+			return
+		}
+
+		// Private targets (and targets that the client's address cannot
+		// download from) are left out of the feed for clients that cannot read them
+		client := getAccessClient(c, users, program)
+		clientIP := net.ParseIP(c.ClientIP())
+
+		var targets []Target
+		for _, target := range index.getEnabledTargets(repo.Name) {
+			if index.canRead(client, repo.Name, target.Name) == true && index.allowsAddress(clientIP, repo.Name, target.Name, false) == true {
+				targets = append(targets, target)
 			}
+		}
 
-			c.Writer.Write([]byte("</pre>\n"))
-		} else {
-			// If it's a file, serve the file
-			c.File(fullPath)
+		serveTargetsFeed(c, repo, targets, fmt.Sprintf("Package updates in %s", repo.Name), "/repos/"+repo.Name+"/feed.atom", "/repos/"+repo.Name)
+	})
+
+	router.GET("/repos/:repo/:target/feed.atom", func(c *gin.Context) {
+		// Verify if repo exists or is enabled
+		repo, err := index.getRepo(c.Param("repo"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
+
+		// Verify if target exists or is enabled
+		target, err := index.getTarget(repo.Name, c.Param("target"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
 		}
+
+		// Verify if the client can read the target
+		if authorizeRead(c, index, users, repo.Name, target.Name, program) == false {
+			return
+		}
+
+		serveTargetsFeed(c, repo, []Target{target}, fmt.Sprintf("Package updates in %s/%s", repo.Name, target.Name), "/repos/"+repo.Name+"/"+target.Name+"/feed.atom", "/repos/"+repo.Name+"/"+target.Name+"/tree/")
+	})
+
+	clientConfigHandler := func(c *gin.Context) {
+		// Verify if repo exists or is enabled
+		repo, err := index.getRepo(c.Param("repo"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
+
+		// Verify if target exists or is enabled
+		target, err := index.getTarget(repo.Name, c.Param("target"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
+
+		// Verify if the client can read the target
+		if authorizeRead(c, index, users, repo.Name, target.Name, program) == false {
+			return
+		}
+
+		config, err := generateClientConfig(repo, target, []string{getRequestBaseURL(c)})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to generate client configuration -> " + err.Error(),
+			})
+			return
+		}
+
+		if strings.HasSuffix(c.Request.URL.Path, "/mirrorlist") {
+			if config.mirrorlist == "" {
+				c.JSON(http.StatusNotFound, gin.H{
+					"message": "A mirrorlist is only available when several public URLs are configured.",
+				})
+				return
+			}
+
+			c.String(http.StatusOK, config.mirrorlist)
+			return
+		}
+
+		c.String(http.StatusOK, config.pacmanConf)
+	}
+
+	router.GET("/repos/:repo/:target/pacman.conf", clientConfigHandler)
+	router.GET("/repos/:repo/:target/mirrorlist", clientConfigHandler)
+
+	router.GET("/:repo/os/:arch/*filepath", func(c *gin.Context) {
+		arch := c.Param("arch")
+		resourcePath := c.Param("filepath")
+		resourcePath = strings.TrimSuffix(resourcePath, "/")
+
+		// Verify if repo exists or is enabled
+		repo, err := index.getRepo(c.Param("repo"))
+		if err != nil {
+			respondWithError(c, err, program)
+			return
+		}
+
+		// Find the enabled target serving the requested architecture
+		target, found := index.getTargetByArch(repo.Name, arch)
+		if found == false {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "No target was found for the requested architecture.",
+			})
+			return
+		}
+
+		// Verify if the client can read the target
+		if authorizeRead(c, index, users, repo.Name, target.Name, program) == false {
+			return
+		}
+
+		serveTargetResource(c, target, resourcePath, "/"+repo.Name+"/os/"+arch, index, checksums, downloads)
 	})
 
 	// Listen and serve
-	router.Run(fmt.Sprintf(":%s", serverPort))
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", serverPort),
+		Handler: router,
+	}
+
+	if tlsOptions.enabled() == false {
+		err = server.ListenAndServe()
+	} else {
+		reloader, err := newCertificateReloader(tlsOptions.certPath, tlsOptions.keyPath, program)
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     formatErrorMessage(err),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.getCertificate,
+		}
+
+		// Client certificates are optional during the handshake, so that
+		// packages can still be downloaded anonymously, and required by the
+		// API routes
+		if tlsOptions.clientCAPath != "" {
+			clientCAs, err := loadClientCAs(tlsOptions.clientCAPath)
+			if err != nil {
+				return functionResponse{
+					exitCode:    1,
+					message:     formatErrorMessage(err),
+					logLevel:    "error",
+					indentLevel: program.indentLevel,
+				}
+			}
+
+			server.TLSConfig.ClientCAs = clientCAs
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		// The server stops if any of the listeners fails
+		listenErrors := make(chan error, 2)
+
+		if tlsOptions.redirectPort != "" {
+			go func() {
+				listenErrors <- http.ListenAndServe(fmt.Sprintf(":%s", tlsOptions.redirectPort), redirectToHTTPS(serverPort))
+			}()
+		}
+
+		go func() {
+			listenErrors <- server.ListenAndServeTLS("", "")
+		}()
+
+		err = <-listenErrors
+	}
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to start server -> %v", err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
 
 	return functionResponse{
 		exitCode: 0,
 	}
 }
+
+// Serves a file from the target's pool directory or, if the resource is a
+// directory, an HTML listing with links relative to baseURL. The pool
+// contents are read from the server index, and checksums are read from the
+// cache and shown as pending until they are calculated.
+func serveTargetResource(c *gin.Context, target Target, resourcePath string, baseURL string, index *serverIndex, checksums *checksumCache, downloads *downloadLimiter) {
+	// Join with the base directory to get full file path
+	fullPath, err := containPath(target.PoolDir, resourcePath)
+	if err != nil {
+		respondWithError(c, err, index.program)
+		return
+	}
+
+	// Check if the path is a directory or a file
+	info, files, found := index.getPoolEntry(target, resourcePath)
+	if found == false {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "The requested resource could not be found.",
+		})
+		return
+	}
+
+	if info == nil {
+		// If it's a directory, generate a directory listing
+
+		// Create an HTML response
+		c.Header("Content-Type", "text/html")
+		c.Writer.Write([]byte("<h1> Index of " + baseURL + resourcePath + " </h1>"))
+		c.Writer.Write([]byte("<pre>\n"))
+		c.Writer.Write([]byte("<a href=\"" + "../" + "\">" + "../" + "</a>\n"))
+
+		for _, file := range files {
+			fileName := strings.ReplaceAll(file.Name(), " ", "%20")
+			modTime := file.ModTime().Format(http.TimeFormat) // Format as HTTP date
+
+			var fileInfoMD5 string
+			var fileInfoSHA256 string
+			var size string
+
+			if file.IsDir() {
+				size = "-" // Use '-' for directories
+				fileInfoMD5 = "-"
+				fileInfoSHA256 = "-"
+
+				linkData := fmt.Sprintf("<a href=\"%s%s/%s\">%s</a>",
+					baseURL, resourcePath, fileName, file.Name())
+				fileData := fmt.Sprintf("<p>     %s     %s</p>", modTime, size)
+				//fileData = strings.ReplaceAll(fileData, " ", "&nbsp;")
+				linedata := linkData + fileData + "\n"
+				c.Writer.Write([]byte(linedata))
+			} else {
+				size = formatBytes(file.Size())
+
+				entry, found := checksums.lookup(fullPath+"/"+file.Name(), file)
+				if found == true && entry.Failed == true {
+					fileInfoMD5 = "<i>Failed to calculate</i>"
+					fileInfoSHA256 = "<i>Failed to calculate</i>"
+				} else if found == true {
+					fileInfoMD5 = entry.MD5
+					fileInfoSHA256 = entry.SHA256
+				} else {
+					fileInfoMD5 = "<i>pending</i>"
+					fileInfoSHA256 = "<i>pending</i>"
+				}
+
+				linkData := fmt.Sprintf("<a href=\"%s%s/%s\">%s</a>",
+					baseURL, resourcePath, fileName, file.Name())
+
+				// Link package files to their detail page
+				if isPackageFile(file.Name()) == true {
+					linkData += fmt.Sprintf(" <a href=\"/repos/%s/%s/pkg%s/%s\">[details]</a>",
+						target.Repo.Name, target.Name, resourcePath, fileName)
+				}
+
+				fileData := fmt.Sprintf("<p>     %s     %s</p><p>     <b>MD5:</b>%s</p><p>     <b>SHA256:</b>%s</p>", modTime, size, fileInfoMD5, fileInfoSHA256)
+				//fileData = strings.ReplaceAll(fileData, " ", "&nbsp;")
+				linedata := linkData + fileData + "\n"
+				c.Writer.Write([]byte(linedata))
+			}
+		}
+
+		c.Writer.Write([]byte("</pre>\n"))
+	} else {
+		// If it's a file, serve the file (within the download limits)
+		downloads.serveFile(c, fullPath, index.program)
+	}
+}
+
+// Returns the base URL used by the client to reach the server, taking into
+// account the X-Forwarded-Proto header set by reverse proxies.
+func getRequestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	} else if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	return scheme + "://" + c.Request.Host
+}
+
+// Writes the JSON response for an error returned while handling a request.
+// Rejected paths are logged as security events, and errors other than
+// missing resources are logged and hidden from the client.
+func respondWithError(c *gin.Context, err error, program Program) {
+	var violation *pathViolation
+
+	switch {
+	case errors.As(err, &violation):
+		logSecurityEvent(c, violation.reason, program)
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Bad Request: " + violation.reason,
+		})
+	case errors.Is(err, errRepoNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "The requested repo could not be found.",
+		})
+	case errors.Is(err, errTargetNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "The requested target could not be found.",
+		})
+	case errors.Is(err, errJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "The requested job could not be found.",
+		})
+	default:
+		showError(fmt.Sprintf("=> Failed to handle request '%s %s' -> %v", c.Request.Method, c.Request.URL.Path, err), program.indentLevel)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Internal Server Error: failed to process the request",
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	// External modules
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+//
+//// REPO SETTINGS (config.toml)
+//
+
+type RepoSettings struct {
+	PublicURLs []string        `toml:"public_urls"`
+	Signing    SigningSettings `toml:"signing"`
+	Access     AccessSettings  `toml:"access"`
+	Network    NetworkSettings `toml:"network"`
+}
+
+// Signing settings are pointers so that a missing value can be told apart
+// from an explicit 'false' (in which case the pool contents are inspected).
+type SigningSettings struct {
+	Packages *bool `toml:"packages"`
+	Database *bool `toml:"database"`
+}
+
+// Private repos and targets can only be read by the users of the htpasswd
+// file (or only by the listed users, if any).
+type AccessSettings struct {
+	Private bool     `toml:"private"`
+	Users   []string `toml:"users"`
+}
+
+// Networks (CIDR blocks or single addresses) allowed to download from a repo
+// or target and to use its API. All addresses are allowed if a list is empty.
+type NetworkSettings struct {
+	DownloadAllow []string `toml:"download_allow"`
+	APIAllow      []string `toml:"api_allow"`
+}
+
+func loadRepoSettings(repo Repo) (RepoSettings, error) {
+	var settings RepoSettings
+
+	contents, err := os.ReadFile(repo.ConfigPath)
+	if os.IsNotExist(err) {
+		// The configuration file is optional
+		return settings, nil
+	} else if err != nil {
+		return settings, fmt.Errorf("failed to read repo configuration file -> %v", err)
+	}
+
+	err = toml.Unmarshal(contents, &settings)
+	if err != nil {
+		return settings, fmt.Errorf("failed to parse repo configuration file '%s' -> %v", repo.ConfigPath, err)
+	}
+
+	return settings, nil
+}
+
+//
+//// TARGET SETTINGS (config.toml)
+//
+
+type TargetSettings struct {
+	Arch    string          `toml:"arch"`
+	Lint    LintSettings    `toml:"lint"`
+	Uploads UploadSettings  `toml:"uploads"`
+	Access  AccessSettings  `toml:"access"`
+	Network NetworkSettings `toml:"network"`
+}
+
+type LintSettings struct {
+	UploadGate bool              `toml:"upload_gate"`
+	Severities map[string]string `toml:"severities"`
+}
+
+// File name patterns (e.g. '*.pkg.tar.*') accepted by the upload API. Any
+// file name is accepted if empty.
+type UploadSettings struct {
+	AllowedFiles []string `toml:"allowed_files"`
+}
+
+func loadTargetSettings(target Target) (TargetSettings, error) {
+	var settings TargetSettings
+
+	contents, err := os.ReadFile(target.ConfigPath)
+	if os.IsNotExist(err) {
+		// The configuration file is optional
+		return settings, nil
+	} else if err != nil {
+		return settings, fmt.Errorf("failed to read target configuration file -> %v", err)
+	}
+
+	err = toml.Unmarshal(contents, &settings)
+	if err != nil {
+		return settings, fmt.Errorf("failed to parse target configuration file '%s' -> %v", target.ConfigPath, err)
+	}
+
+	if settings.Arch != "" && slices.Contains(knownArchitectures, settings.Arch) == false {
+		return settings, fmt.Errorf("invalid architecture '%s' in target configuration file '%s' (expected one of: %s)", settings.Arch, target.ConfigPath, strings.Join(knownArchitectures, ", "))
+	}
+
+	return settings, nil
+}
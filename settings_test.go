@@ -0,0 +1,55 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//
+//// TARGET SETTINGS (config.toml)
+//
+
+func TestLoadTargetSettings(t *testing.T) {
+	tests := []struct {
+		config string
+		arch   string
+		fails  bool
+	}{
+		{config: "", arch: ""},
+		{config: "arch = \"x86_64\"\n", arch: "x86_64"},
+		{config: "arch = \"aarch64\"\n", arch: "aarch64"},
+		{config: "arch = \"x86_64_v3\"\n", arch: "x86_64_v3"},
+		{config: "arch = \"amd64\"\n", fails: true},
+		{config: "arch = \"X86_64\"\n", fails: true},
+		{config: "arch = \"any\"\n", fails: true},
+		{config: "arch = [\"x86_64\"]\n", fails: true},
+		{config: "arch = \"x86_64\n", fails: true},
+	}
+
+	for _, test := range tests {
+		target := Target{ConfigPath: filepath.Join(t.TempDir(), "config.toml")}
+		os.WriteFile(target.ConfigPath, []byte(test.config), 0644)
+
+		settings, err := loadTargetSettings(target)
+
+		if test.fails == true {
+			if err == nil {
+				t.Errorf("loadTargetSettings(%q) = %+v, expected an error", test.config, settings)
+			}
+		} else if err != nil || settings.Arch != test.arch {
+			t.Errorf("loadTargetSettings(%q) arch = %q, %v, expected %q", test.config, settings.Arch, err, test.arch)
+		}
+	}
+
+	// The configuration file is optional
+	settings, err := loadTargetSettings(Target{ConfigPath: filepath.Join(t.TempDir(), "config.toml")})
+	if err != nil || settings.Arch != "" {
+		t.Errorf("loadTargetSettings() without a file = %+v, %v, expected empty settings", settings, err)
+	}
+}
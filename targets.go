@@ -6,13 +6,15 @@ package main
 
 import (
 	// Modules in GOROOT
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"strings"
 
 	// External modules
 	survey "github.com/AlecAivazis/survey/v2"
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
 	copy "github.com/otiai10/copy"
 )
 
@@ -20,16 +22,7 @@ import (
 //// TARGETS
 //
 
-type Target struct {
-	repo         Repo
-	name         string
-	path         string
-	hooksDir     string
-	tempDir      string
-	poolDir      string
-	disabledPath string
-	environment  map[string]string
-}
+type Target = pacpilot.Target
 
 func getSelectedTargetsFromCLI(repoName string, targetNames []string, allTargets bool, interactiveSelection bool, multiple bool, program Program) (Repo, []Target, functionResponse) {
 	var selectedRepo Repo
@@ -59,7 +52,7 @@ func getSelectedTargetsFromCLI(repoName string, targetNames []string, allTargets
 
 		availableReposStrings := make([]string, len(availableRepos))
 		for i, element := range availableRepos {
-			availableReposStrings[i] = element.name
+			availableReposStrings[i] = element.Name
 		}
 
 		var selectedRepoIndex int
@@ -87,7 +80,7 @@ func getSelectedTargetsFromCLI(repoName string, targetNames []string, allTargets
 
 		availableTargetsStrings := make([]string, len(availableTargets))
 		for i, element := range availableTargets {
-			availableTargetsStrings[i] = element.name
+			availableTargetsStrings[i] = element.Name
 		}
 
 		var selectedTargetsIndices []int
@@ -148,7 +141,7 @@ func getSelectedTargetsFromCLI(repoName string, targetNames []string, allTargets
 		if response.exitCode != 0 {
 			return Repo{}, []Target{}, functionResponse{
 				exitCode:    1,
-				message:     fmt.Sprintf("Repo '%s' not found", repo.name),
+				message:     fmt.Sprintf("Repo '%s' not found", repo.Name),
 				logLevel:    "error",
 				indentLevel: program.indentLevel,
 			}
@@ -162,7 +155,7 @@ func getSelectedTargetsFromCLI(repoName string, targetNames []string, allTargets
 		} else {
 			selectedTargets = make([]Target, len(targetNames))
 			for i, element := range targetNames {
-				selectedTargets[i] = generateTargetObj(repo.name, element, program)
+				selectedTargets[i] = generateTargetObj(repo.Name, element, program)
 			}
 
 			// Verify targets
@@ -175,26 +168,19 @@ func getSelectedTargetsFromCLI(repoName string, targetNames []string, allTargets
 }
 
 func displayTargetTag(msg string, target Target) string {
-	return fmt.Sprintf(msg) + fmt.Sprintf(" (") + salmonPink.Sprintf(target.repo.name) + fmt.Sprintf("/") + green.Sprintf(target.name) + fmt.Sprintf(")")
+	return fmt.Sprintf(msg) + fmt.Sprintf(" (") + salmonPink.Sprintf(target.Repo.Name) + fmt.Sprintf("/") + green.Sprintf(target.Name) + fmt.Sprintf(")")
 }
 
 func getRepoTargets(repo Repo, program Program) ([]Target, functionResponse) {
-	targetNames, err := ioutil.ReadDir(repo.targetsDir)
+	targets, err := pacpilot.GetRepoTargets(context.Background(), getLibraryConfig(program), repo)
 	if err != nil {
 		return []Target{}, functionResponse{
 			exitCode:    1,
-			message:     fmt.Sprintf("Failed to read the repo's targets directory -> " + err.Error()),
+			message:     formatErrorMessage(err),
 			logLevel:    "error",
 			indentLevel: program.indentLevel,
 		}
 	}
-	targetNames = filterHiddenFilesAndDirectories(targetNames)
-
-	// Generate an array of targets
-	targets := make([]Target, len(targetNames))
-	for i, element := range targetNames {
-		targets[i] = generateTargetObj(repo.name, element.Name(), program)
-	}
 
 	if len(targets) == 0 {
 		return targets, functionResponse{
@@ -210,80 +196,35 @@ func getRepoTargets(repo Repo, program Program) ([]Target, functionResponse) {
 	}
 }
 
-func getEnabledTargets(repo Repo, program Program) []Target {
-	availableTargets, response := getRepoTargets(repo, program)
-	handleFunctionResponse(response, true)
-
-	var enabledTargets []Target
-	for _, target := range availableTargets {
-		targetDisabled, response := isTargetDisabled(target, program)
-		handleFunctionResponse(response, true)
-
-		if targetDisabled == false {
-			enabledTargets = append(enabledTargets, target)
-		}
-	}
-
-	return enabledTargets
+// Returns the enabled targets of the repo. Having no targets is not an error.
+func getEnabledTargets(repo Repo, program Program) ([]Target, error) {
+	return pacpilot.GetEnabledTargets(context.Background(), getLibraryConfig(program), repo)
 }
 
 func generateTargetObj(repo string, target string, program Program) Target {
-	defaultTargetEnv := map[string]string{
-		"PROGRAM_NAME":          program.name,
-		"DEFAULT_SHELL":         program.defaultShell,
-		"PACPILOT_EXEC":         program.exec,
-		"PACPILOT_UTILS":        fmt.Sprintf("%v utils", program.exec),
-		"DATA_DIR":              program.dataDir,
-		"REPOS_DIR":             program.reposDir,
-		"TEMPLATES_DIR":         program.templatesDir,
-		"REPOS_TEMPLATES_DIR":   program.reposTemplatesDir,
-		"TARGETS_TEMPLATES_DIR": program.targetsTemplatesDir,
-		"REPO_NAME":             repo,
-		"REPO_DIR":              program.reposDir + "/" + repo,
-		"REPO_HOOKS_DIR":        program.reposDir + "/" + repo + "/hooks",
-		"REPO_TARGETS_DIR":      program.reposDir + "/" + repo + "/targets",
-		"REPO_TEMP_DIR":         program.reposDir + "/" + repo + "/.tmp",
-		"TARGET_NAME":           target,
-		"TARGET_DIR":            program.reposDir + "/" + repo + "/targets" + "/" + target,
-		"TARGET_HOOKS_DIR":      program.reposDir + "/" + repo + "/targets" + "/" + target + "/hooks",
-		"TARGET_POOL_DIR":       program.reposDir + "/" + repo + "/targets" + "/" + target + "/pool",
-		"TARGET_TEMP_DIR":       program.reposDir + "/" + repo + "/targets" + "/" + target + "/.tmp",
-	}
-
-	return Target{
-		repo:         generateRepoObj(repo, program),
-		name:         target,
-		path:         program.reposDir + "/" + repo + "/targets" + "/" + target,
-		hooksDir:     program.reposDir + "/" + repo + "/targets" + "/" + target + "/hooks",
-		poolDir:      program.reposDir + "/" + repo + "/targets" + "/" + target + "/pool",
-		tempDir:      program.reposDir + "/" + repo + "/targets" + "/" + target + "/.tmp",
-		disabledPath: program.reposDir + "/" + repo + "/targets" + "/" + target + "/disabled",
-		environment:  defaultTargetEnv,
-	}
+	return pacpilot.NewTarget(getLibraryConfig(program), repo, target)
 }
 
 func isTargetDisabled(target Target, program Program) (bool, functionResponse) {
-	if _, err := os.Stat(target.disabledPath); os.IsNotExist(err) {
-		return false, functionResponse{
-			exitCode:    0,
-			indentLevel: program.indentLevel + 1,
-		}
-	} else if err != nil {
+	disabled, err := target.IsDisabled()
+	if err != nil {
 		return false, functionResponse{
 			exitCode:    1,
-			message:     "Failed to verify if target is disabled -> " + err.Error(),
+			message:     formatErrorMessage(err),
 			logLevel:    "error",
 			indentLevel: program.indentLevel + 1,
 		}
-	} else {
-		return true, functionResponse{
-			exitCode: 0,
-		}
+	}
+
+	return disabled, functionResponse{
+		exitCode:    0,
+		indentLevel: program.indentLevel + 1,
 	}
 }
 
 func enableTarget(target Target, program Program) functionResponse {
-	if _, err := os.Stat(target.disabledPath); os.IsNotExist(err) {
+	err := target.Enable()
+	if errors.Is(err, pacpilot.ErrAlreadyEnabled) {
 		return functionResponse{
 			exitCode:    0,
 			message:     "Target already enabled",
@@ -293,27 +234,17 @@ func enableTarget(target Target, program Program) functionResponse {
 	} else if err != nil {
 		return functionResponse{
 			exitCode:    1,
-			message:     "Failed to verify if target is disabled -> " + err.Error(),
+			message:     formatErrorMessage(err),
 			logLevel:    "error",
 			indentLevel: program.indentLevel + 1,
 		}
-	} else {
-		err = os.Remove(target.disabledPath)
-		if err != nil {
-			return functionResponse{
-				exitCode:    1,
-				message:     "Failed to remove disabled lock file -> " + err.Error(),
-				logLevel:    "error",
-				indentLevel: program.indentLevel + 1,
-			}
-		} else {
-			return functionResponse{
-				exitCode:    0,
-				message:     "Finished",
-				logLevel:    "success",
-				indentLevel: program.indentLevel + 1,
-			}
-		}
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
 	}
 }
 
@@ -335,38 +266,28 @@ func targetsEnable(repo Repo, targets []Target, program Program) functionRespons
 }
 
 func disableTarget(target Target, program Program) functionResponse {
-	if _, err := os.Stat(target.disabledPath); os.IsNotExist(err) {
-		file, err := os.Create(target.disabledPath)
-		defer file.Close()
-		if err != nil {
-			return functionResponse{
-				exitCode:    1,
-				message:     "Failed to create disabled lock file -> " + err.Error(),
-				logLevel:    "error",
-				indentLevel: program.indentLevel + 1,
-			}
-		} else {
-			return functionResponse{
-				exitCode:    0,
-				message:     "Finished",
-				logLevel:    "success",
-				indentLevel: program.indentLevel + 1,
-			}
+	err := target.Disable()
+	if errors.Is(err, pacpilot.ErrAlreadyDisabled) {
+		return functionResponse{
+			exitCode:    0,
+			message:     "Target already disabled",
+			logLevel:    "attention",
+			indentLevel: program.indentLevel + 1,
 		}
 	} else if err != nil {
 		return functionResponse{
 			exitCode:    1,
-			message:     "Failed to verify if target is enabled -> " + err.Error(),
+			message:     formatErrorMessage(err),
 			logLevel:    "error",
 			indentLevel: program.indentLevel + 1,
 		}
-	} else {
-		return functionResponse{
-			exitCode:    0,
-			message:     "Target already disabled",
-			logLevel:    "attention",
-			indentLevel: program.indentLevel + 1,
-		}
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
 	}
 }
 
@@ -401,7 +322,7 @@ func verifyTargetsDirectories(targets []Target, program Program) functionRespons
 	if len(failedTargets) > 0 {
 		message := "The following target(s) was/were not found:\n"
 		for _, target := range failedTargets {
-			message = message + fmt.Sprintf("\n    - %s", target.name)
+			message = message + fmt.Sprintf("\n    - %s", target.Name)
 		}
 		response = functionResponse{
 			exitCode:    1,
@@ -419,7 +340,7 @@ func verifyTargetsDirectories(targets []Target, program Program) functionRespons
 }
 
 func verifyTargetDirectory(target Target, program Program) functionResponse {
-	if _, err := os.Stat(target.path); os.IsNotExist(err) {
+	if exists, _ := target.Exists(); exists == false {
 		return functionResponse{
 			exitCode: 1,
 		}
@@ -429,106 +350,91 @@ func verifyTargetDirectory(target Target, program Program) functionResponse {
 	}
 }
 
-func removeTargetTempDirectory(target Target, notRemoveTempDir bool, program Program) {
-	var response functionResponse
-
-	showInfoSectionTitle(fmt.Sprintf("Removing temporary directory"), program.indentLevel)
+// Removes the temporary directory of the target, unless notRemoveTempDir is
+// set. Messages are written to output and the result is returned.
+func removeTargetTempDirectory(target Target, notRemoveTempDir bool, output hookOutput) functionResponse {
+	output.show(lightGray, "Removing temporary directory", 0)
 
 	if notRemoveTempDir == true {
-		response = functionResponse{
+		return functionResponse{
 			exitCode:    0,
 			message:     "Skipping",
 			logLevel:    "attention",
-			indentLevel: program.indentLevel + 1,
+			indentLevel: 1,
 		}
-		handleFunctionResponse(response, false)
-		return
 	}
 
-	if _, err := os.Stat(target.tempDir); os.IsNotExist(err) {
-		response = functionResponse{
+	if _, err := os.Stat(target.TempDir); os.IsNotExist(err) {
+		return functionResponse{
 			exitCode:    0,
 			logLevel:    "attention",
 			message:     fmt.Sprintf("Temporary directory not found"),
-			indentLevel: program.indentLevel + 1,
+			indentLevel: 1,
 		}
-	} else {
-		err = os.RemoveAll(target.tempDir)
-		if err != nil {
-			response = functionResponse{
-				exitCode:    1,
-				message:     "Failed to remove temporary directory -> " + err.Error(),
-				logLevel:    "error",
-				indentLevel: program.indentLevel + 1,
-			}
-		} else {
-			response = functionResponse{
-				exitCode:    0,
-				message:     "Finished",
-				logLevel:    "success",
-				indentLevel: program.indentLevel + 1,
-			}
+	}
+
+	err := os.RemoveAll(target.TempDir)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Failed to remove temporary directory -> " + err.Error(),
+			logLevel:    "error",
+			indentLevel: 1,
 		}
 	}
 
-	handleFunctionResponse(response, true)
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: 1,
+	}
 }
 
-func setupTargetTempDirectory(target Target, notCreateTempDir bool, program Program) {
-	var response functionResponse
-
-	showInfoSectionTitle(lightGray.Sprintf("Setting up temporary directory"), program.indentLevel)
+// Creates the temporary directory of the target (recreating it if it already
+// exists), unless notCreateTempDir is set. Messages are written to output and
+// errors are returned (without being shown).
+func setupTargetTempDirectory(target Target, notCreateTempDir bool, output hookOutput) functionResponse {
+	output.show(lightGray, "Setting up temporary directory", 0)
 
 	if notCreateTempDir == true {
-		response = functionResponse{
-			exitCode:    0,
-			message:     "Skipping",
-			logLevel:    "attention",
-			indentLevel: program.indentLevel + 1,
+		output.show(orange, "> Skipping", 1)
+
+		return functionResponse{
+			exitCode: 0,
 		}
-		handleFunctionResponse(response, false)
-		return
 	}
 
-	if _, err := os.Stat(target.tempDir); err == nil {
-		response = functionResponse{
-			exitCode:    0,
-			message:     "Temporary directory already exists. Recreating it...",
-			logLevel:    "attention",
-			indentLevel: program.indentLevel + 2,
-		}
-		handleFunctionResponse(response, false)
+	if _, err := os.Stat(target.TempDir); err == nil {
+		output.show(orange, "> Temporary directory already exists. Recreating it...", 1)
 
-		err = os.RemoveAll(target.tempDir)
+		err = os.RemoveAll(target.TempDir)
 		if err != nil {
-			response = functionResponse{
+			return functionResponse{
 				exitCode:    1,
 				message:     fmt.Sprintf("Failed to recreate temporary directory -> '%v'", err.Error()),
-				indentLevel: program.indentLevel + 3,
+				logLevel:    "error",
+				indentLevel: 1,
 			}
-
-			handleFunctionResponse(response, true)
 		}
 	}
 
 	perm := os.FileMode(0755)
-	err := os.Mkdir(target.tempDir, perm)
+	err := os.Mkdir(target.TempDir, perm)
 	if err != nil {
-		response = functionResponse{
+		return functionResponse{
 			exitCode:    1,
 			message:     fmt.Sprintf("Failed to create temporary directory -> '%v'", err.Error()),
-			indentLevel: program.indentLevel + 1,
-		}
-	} else {
-		response = functionResponse{
-			exitCode:    0,
-			logLevel:    "success",
-			message:     "Finished",
-			indentLevel: program.indentLevel + 1,
+			logLevel:    "error",
+			indentLevel: 1,
 		}
 	}
 
-	handleFunctionResponse(response, true)
+	output.show(blue, "> Finished", 1)
+
+	return functionResponse{
+		exitCode: 0,
+	}
 }
 
 func targetsCreate(program Program) functionResponse {
@@ -543,7 +449,7 @@ func targetsCreate(program Program) functionResponse {
 
 	availableReposStrings := make([]string, len(availableRepos))
 	for i, element := range availableRepos {
-		availableReposStrings[i] = element.name
+		availableReposStrings[i] = element.Name
 	}
 
 	var selectedIndex int
@@ -588,7 +494,7 @@ func targetsCreate(program Program) functionResponse {
 	}
 
 	// Generate a target object
-	target := generateTargetObj(selectedRepo.name, targetName, program)
+	target := generateTargetObj(selectedRepo.Name, targetName, program)
 
 	// Ask for a target template
 	availableTemplates, err := ioutil.ReadDir(program.targetsTemplatesDir)
@@ -642,7 +548,7 @@ func targetsCreate(program Program) functionResponse {
 	if response.exitCode == 0 {
 		return functionResponse{
 			exitCode:    1,
-			message:     fmt.Sprintf("Target '%s' already exists", target.name),
+			message:     fmt.Sprintf("Target '%s' already exists", target.Name),
 			logLevel:    "attention",
 			indentLevel: program.indentLevel + 1,
 		}
@@ -651,7 +557,7 @@ func targetsCreate(program Program) functionResponse {
 	// Create target directory
 	space()
 	showInfoSectionTitle("Creating target directory", program.indentLevel+1)
-	err = os.Mkdir(target.path, 0755)
+	err = os.Mkdir(target.Path, 0755)
 	if err != nil {
 		return functionResponse{
 			exitCode:    1,
@@ -672,7 +578,7 @@ func targetsCreate(program Program) functionResponse {
 	// Create target's pool directory
 	space()
 	showInfoSectionTitle("Creating target's pool directory", program.indentLevel+1)
-	err = os.Mkdir(target.poolDir, 0755)
+	err = os.Mkdir(target.PoolDir, 0755)
 	if err != nil {
 		return functionResponse{
 			exitCode:    1,
@@ -699,10 +605,10 @@ func targetsCreate(program Program) functionResponse {
 			PreserveOwner: true,
 		}
 
-		err = copy.Copy(targetTemplateDir, target.path, copyOptions)
+		err = copy.Copy(targetTemplateDir, target.Path, copyOptions)
 		if err != nil {
 			// Remove target directory
-			_ = os.RemoveAll(target.path)
+			_ = os.RemoveAll(target.Path)
 
 			return functionResponse{
 				exitCode:    1,
@@ -724,8 +630,8 @@ func targetsCreate(program Program) functionResponse {
 	// Run post_create hook (if any)
 	space()
 	showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf("post_create")+lightGray.Sprintf(" hook"), program.indentLevel+1)
-	if _, err := os.Stat(target.hooksDir + "/post_create"); err == nil {
-		_, response := runHook(target.hooksDir+"/post_create", target.environment, true, true, true, true, true, incrementProgramIndentLevel(program, 1))
+	if _, err := os.Stat(target.HooksDir + "/post_create"); err == nil {
+		_, response := runHook(target.HooksDir+"/post_create", target.Environment, nil, true, true, true, true, true, incrementProgramIndentLevel(program, 1))
 
 		if response.exitCode != 0 {
 			handleFunctionResponse(response, false)
@@ -734,7 +640,7 @@ func targetsCreate(program Program) functionResponse {
 
 			// Remove target directory
 			showInfoSectionTitle(lightGray.Sprintf("Removing target directory"), program.indentLevel+1)
-			err = os.RemoveAll(target.path)
+			err = os.RemoveAll(target.Path)
 
 			if err != nil {
 				response = functionResponse{
@@ -793,7 +699,7 @@ func targetsRm(repo Repo, targets []Target, program Program) functionResponse {
 
 		showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf("pre_rm")+lightGray.Sprintf(" hook"), program.indentLevel+1)
 
-		if _, err := os.Stat(target.hooksDir + "/pre_rm"); os.IsNotExist(err) {
+		if _, err := os.Stat(target.HooksDir + "/pre_rm"); os.IsNotExist(err) {
 			response := functionResponse{
 				exitCode:    0,
 				message:     "Hook not found",
@@ -804,14 +710,14 @@ func targetsRm(repo Repo, targets []Target, program Program) functionResponse {
 		} else {
 			program = incrementProgramIndentLevel(program, 1)
 
-			_, response := runHook(target.hooksDir+"/pre_rm", target.environment, true, true, true, true, true, program)
+			_, response := runHook(target.HooksDir+"/pre_rm", target.Environment, nil, true, true, true, true, true, program)
 			response.indentLevel = program.indentLevel + 2
 			handleFunctionResponse(response, true)
 		}
 
 		space()
 
-		err := os.RemoveAll(target.path)
+		err := os.RemoveAll(target.Path)
 		if err != nil {
 			response = functionResponse{
 				exitCode:    1,
@@ -853,7 +759,7 @@ func targetsLs(repos []Repo, program Program) functionResponse {
 
 		for _, target := range targets {
 			// Get optional description (if hook exists)
-			targetDescription, response := runHook(target.hooksDir+"/ls", target.environment, false, false, false, false, false, program)
+			targetDescription, response := runHook(target.HooksDir+"/ls", target.Environment, nil, false, false, false, false, false, program)
 			targetDescriptionString := targetDescription.Output
 
 			var description string
@@ -866,6 +772,20 @@ func targetsLs(repos []Repo, program Program) functionResponse {
 				}
 			}
 
+			settings, err := loadTargetSettings(target)
+			if err != nil {
+				return functionResponse{
+					exitCode:    1,
+					message:     err.Error(),
+					logLevel:    "error",
+					indentLevel: program.indentLevel + 1,
+				}
+			}
+
+			if arch := targetArchitecture(target, settings); arch != "" {
+				description += fmt.Sprintf("[%s] ", paleLime.Sprintf(arch))
+			}
+
 			isTargetDisabled, response := isTargetDisabled(target, program)
 			handleFunctionResponse(response, true)
 
@@ -873,7 +793,7 @@ func targetsLs(repos []Repo, program Program) functionResponse {
 				description += fmt.Sprintf("[%s]", red.Sprintf("disabled"))
 			}
 
-			showText(fmt.Sprintf(" - %s %s", target.name, description), program.indentLevel+1)
+			showText(fmt.Sprintf(" - %s %s", target.Name, description), program.indentLevel+1)
 		}
 	}
 
@@ -882,16 +802,20 @@ func targetsLs(repos []Repo, program Program) functionResponse {
 	}
 }
 
-func targetsUpdate(repo Repo, targets []Target, repoPreHooks []string, repoPostHooks []string, program Program) functionResponse {
+func targetsUpdate(repo Repo, targets []Target, repoPreHooks []string, repoPostHooks []string, lock lockMode, keepGoing bool, postOnSuccess bool, parallel parallelism, program Program) functionResponse {
 	hook := "update"
-	response := targetsRunHooks(repo, targets, []string{hook}, repoPreHooks, repoPostHooks, false, false, false, false, false, program)
+	response := targetsRunHooks(repo, targets, []string{hook}, repoPreHooks, repoPostHooks, false, false, false, false, false, lock, nil, nil, keepGoing, postOnSuccess, parallel, program)
 
 	return response
 }
 
-func targetsRunHooks(repo Repo, targets []Target, hooks []string, repoPreHooks []string, repoPostHooks []string, notCreateTempDir bool, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, notPrintAlerts bool, program Program) functionResponse {
+func targetsRunHooks(repo Repo, targets []Target, hooks []string, repoPreHooks []string, repoPostHooks []string, notCreateTempDir bool, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, notPrintAlerts bool, lock lockMode, hookArgs []string, hookEnv map[string]string, keepGoing bool, postOnSuccess bool, parallel parallelism, program Program) functionResponse {
 	var response functionResponse
 
+	// Results of the target and repo post hooks, shown at the end if the run
+	// continues after failures or runs targets in parallel
+	summary := &hookSummary{}
+
 	isRepoDisabled, response := isRepoDisabled(repo, program)
 	if response.exitCode != 0 {
 		response.indentLevel = program.indentLevel + 1
@@ -916,10 +840,26 @@ func targetsRunHooks(repo Repo, targets []Target, hooks []string, repoPreHooks [
 	space()
 	space()
 
+	// The repo lock is held from the repo pre hooks until the repo post hooks,
+	// so that the repo hooks of another run cannot run in between (e.g. change
+	// the repo while its targets are updated). Repo locks are always taken
+	// before target locks.
+	repoLock, response := acquireCLILock(repoLockPath(repo), repo.Name, lock, program)
+	if response.exitCode != 0 {
+		handleFunctionResponse(response, false)
+
+		space()
+		removeRepoTempDirectory(repo, true, false, program)
+
+		space()
+
+		finishProgram(response.exitCode)
+	}
+
 	for _, hook := range repoPreHooks {
 		showInfoSectionTitle(displayRepoTag(lightGray.Sprintf("Running ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook"), repo), program.indentLevel)
 
-		if _, err := os.Stat(repo.hooksDir + "/" + hook); os.IsNotExist(err) {
+		if _, err := os.Stat(repo.HooksDir + "/" + hook); os.IsNotExist(err) {
 			response := functionResponse{
 				exitCode:    0,
 				message:     "Hook not found",
@@ -928,7 +868,7 @@ func targetsRunHooks(repo Repo, targets []Target, hooks []string, repoPreHooks [
 			}
 			handleFunctionResponse(response, true)
 		} else {
-			_, response := runHook(repo.hooksDir+"/"+hook, repo.environment, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
+			_, response := runHook(repo.HooksDir+"/"+hook, mergeEnvironment(repo.Environment, hookEnv), nil, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
 			response.indentLevel = program.indentLevel + 1
 
 			handleFunctionResponse(response, false)
@@ -947,125 +887,65 @@ func targetsRunHooks(repo Repo, targets []Target, hooks []string, repoPreHooks [
 		space()
 	}
 
-	for index, target := range targets {
-		orange.Println(fmt.Sprintf("(%v/%v)", index+1, len(targets)))
-
-		showInfoSectionTitle(displayTargetTag("Running hook(s)", target), program.indentLevel)
-
-		targetDisabled, response := isTargetDisabled(target, program)
-		if response.exitCode != 0 {
-			response.indentLevel = program.indentLevel + 1
+	response = runHooksOfTargets(repo, targets, hooks, notCreateTempDir, notRemoveTempDir, notPrintOutput, notPrintEntryCmd, lock, hookArgs, hookEnv, keepGoing, parallel, summary, program)
+	if response.exitCode != 0 {
+		repoLock.release()
 
-			return response
-		}
+		removeRepoTempDirectory(repo, true, notRemoveTempDir, program)
 
-		if targetDisabled == true {
-			response := functionResponse{
-				exitCode:    0,
-				message:     "Target is disabled",
-				logLevel:    "attention",
-				indentLevel: program.indentLevel + 1,
-			}
-			handleFunctionResponse(response, false)
-
-			space()
+		if parallel.targets > 1 {
 			space()
-
-			continue
+			showHookSummary(summary, program)
 		}
-
-		program = incrementProgramIndentLevel(program, 1)
-
 		space()
 
-		setupTargetTempDirectory(target, notCreateTempDir, program)
-
-		response = func(repo Repo, target Target, hooks []string, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, program Program) functionResponse {
-			for _, hook := range hooks {
-				space()
-				space()
-
-				showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook"), program.indentLevel)
-
-				// Run hook
-				if _, err := os.Stat(target.hooksDir + "/" + hook); os.IsNotExist(err) {
-					response = functionResponse{
-						exitCode:    1,
-						message:     fmt.Sprintf("No '%v' hook found", hook),
-						logLevel:    "error",
-						indentLevel: program.indentLevel + 1,
-					}
-					handleFunctionResponse(response, false)
-				} else {
-					_, hookResponse := runHook(target.hooksDir+"/"+hook, target.environment, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
-
-					if hookResponse.exitCode != 0 {
-						hookResponse.indentLevel = program.indentLevel + 1
-						return hookResponse
-					} else {
-						response = functionResponse{
-							exitCode:    0,
-							message:     "Finished",
-							logLevel:    "success",
-							indentLevel: program.indentLevel + 1,
-						}
-						handleFunctionResponse(response, false)
-					}
-				}
-			}
-
-			return functionResponse{
-				exitCode: response.exitCode,
-			}
-		}(repo, target, hooks, notRemoveTempDir, notPrintOutput, notPrintEntryCmd, program)
-
-		if response.exitCode != 0 {
-			handleFunctionResponse(response, false)
-
-			space()
-			space()
-
-			removeTargetTempDirectory(target, notRemoveTempDir, program)
-
-			space()
-
-			removeRepoTempDirectory(repo, true, notRemoveTempDir, decrementProgramIndentLevel(program, 1))
-
-			space()
-			finishProgram(response.exitCode)
-		} else {
-			handleFunctionResponse(response, false)
-		}
-
-		space()
-		space()
+		return response
+	}
 
-		removeTargetTempDirectory(target, notRemoveTempDir, program)
+	if keepGoing == true && postOnSuccess == true && summary.failures() > 0 && len(repoPostHooks) > 0 {
+		showAttention(displayRepoTag("Skipping repo post hook(s), as target hook(s) failed", repo), program.indentLevel)
 
-		program = decrementProgramIndentLevel(program, 1)
+		summary.skip(repo.Name+" (repo)", repoPostHooks)
+		repoPostHooks = nil
 
 		space()
 		space()
 	}
 
-	for _, hook := range repoPostHooks {
+	for index, hook := range repoPostHooks {
 		showInfoSectionTitle(displayRepoTag(lightGray.Sprintf("Running ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook"), repo), program.indentLevel)
 
-		if _, err := os.Stat(repo.hooksDir + "/" + hook); os.IsNotExist(err) {
+		if _, err := os.Stat(repo.HooksDir + "/" + hook); os.IsNotExist(err) {
 			response = functionResponse{
 				exitCode:    1,
 				message:     "Hook not found",
 				logLevel:    "error",
 				indentLevel: program.indentLevel + 1,
 			}
+
+			if keepGoing == true {
+				handleFunctionResponse(response, false)
+
+				summary.add(repo.Name+" (repo)", hook, hookRun{status: hookNotFound})
+				summary.skip(repo.Name+" (repo)", repoPostHooks[index+1:])
+				break
+			}
+
 			handleFunctionResponse(response, true)
 		} else {
-			_, response := runHook(repo.hooksDir+"/"+hook, repo.environment, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
+			run, response := runHook(repo.HooksDir+"/"+hook, mergeEnvironment(repo.Environment, hookEnv), nil, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
 			response.indentLevel = program.indentLevel + 1
 
 			handleFunctionResponse(response, false)
 
-			if response.exitCode != 0 {
+			summary.add(repo.Name+" (repo)", hook, run)
+
+			if response.exitCode != 0 && keepGoing == true {
+				summary.skip(repo.Name+" (repo)", repoPostHooks[index+1:])
+
+				space()
+				break
+			} else if response.exitCode != 0 {
 				space()
 				removeRepoTempDirectory(repo, true, false, program)
 
@@ -1078,8 +958,30 @@ func targetsRunHooks(repo Repo, targets []Target, hooks []string, repoPreHooks [
 		space()
 	}
 
+	// Repo post hooks may have changed the targets' databases
+	if len(repoPostHooks) > 0 {
+		recordTargetsDatabaseEvents(targets, program)
+	}
+
+	repoLock.release()
+
 	removeRepoTempDirectory(repo, true, notRemoveTempDir, program)
 
+	if keepGoing == true || parallel.targets > 1 {
+		space()
+		showHookSummary(summary, program)
+		space()
+
+		if failures := summary.failures(); failures > 0 {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("%d hook(s) failed", failures),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+	}
+
 	return functionResponse{
 		exitCode: 0,
 	}
@@ -1092,50 +994,22 @@ func targetsHooksLs(repo Repo, targets []Target, program Program) functionRespon
 		orange.Println(fmt.Sprintf("(%v/%v)", index+1, len(targets)))
 		showInfoSectionTitle(displayTargetTag("Listing hooks", target), program.indentLevel)
 
-		hooks, err := ioutil.ReadDir(target.hooksDir)
+		hooks, err := pacpilot.GetHooks(getLibraryConfig(program), target.HooksDir)
 		if err != nil {
 			return functionResponse{
 				exitCode:    1,
-				message:     fmt.Sprintf("Error reading the hooks directory -> " + err.Error()),
+				message:     formatErrorMessage(err),
 				logLevel:    "error",
 				indentLevel: program.indentLevel + 1,
 			}
 		}
 
-		// Filter out .entry files
-		filteredHooks := make([]os.FileInfo, 0)
-		for _, element := range hooks {
-			if !strings.HasSuffix(element.Name(), ".entry") {
-				filteredHooks = append(filteredHooks, element)
-			}
-		}
-		filteredHooks = filterHiddenFilesAndDirectories(filteredHooks)
-
-		if len(filteredHooks) == 0 {
+		if len(hooks) == 0 {
 			showAttention("No hooks found", program.indentLevel)
 		}
 
-		for _, element := range filteredHooks {
-			// Verify if hook has custom entry command
-			var entryCommand string
-			customEntryFilePath := target.hooksDir + "/" + element.Name() + ".entry"
-			if _, err = os.Stat(customEntryFilePath); err == nil {
-				contents, err := ioutil.ReadFile(customEntryFilePath)
-				if err != nil {
-					return functionResponse{
-						exitCode:    1,
-						message:     fmt.Sprintf("Failed to read custom entry configuration file for hook " + element.Name() + " -> " + err.Error()),
-						logLevel:    "error",
-						indentLevel: program.indentLevel,
-					}
-				}
-				entryCommand = string(contents)
-				entryCommand = strings.TrimLeft(entryCommand, "\n")
-				entryCommand = strings.TrimRight(entryCommand, "\n")
-			} else {
-				entryCommand = program.defaultShell
-			}
-			showText(fmt.Sprintf("- %s (%s)", element.Name(), coral.Sprintf(entryCommand)), program.indentLevel+1)
+		for _, hook := range hooks {
+			showHook(hook, program)
 		}
 	}
 
@@ -6,16 +6,19 @@ package main
 
 import (
 	// Modules in GOROOT
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	// External modules
 	survey "github.com/AlecAivazis/survey/v2"
-	ptywrapper "github.com/fearlessdots/ptywrapper"
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
 	terminal "golang.org/x/crypto/ssh/terminal"
-	//pty "github.com/creack/pty" => indirectly imported by the 'ptywrapper' module
 )
 
 //
@@ -74,82 +77,113 @@ func getTerminalDimensions(program Program) (terminalDimensions, functionRespons
 //// COMMAND EXECUTION
 //
 
-func runHook(hookPath string, env map[string]string, printOutput bool, printFinished bool, showRulers bool, printEntryCmd bool, printAlerts bool, program Program) (ptywrapper.Command, functionResponse) {
-	// Verify if hook exists
-	if _, err := os.Stat(hookPath); os.IsNotExist(err) {
-		return ptywrapper.Command{}, functionResponse{
-			exitCode:    1,
-			message:     "Hook not found",
-			logLevel:    "attention",
-			indentLevel: program.indentLevel,
-		}
-	}
+// Outcomes of hooks run by the command
+const (
+	hookSucceeded        = "succeeded"
+	hookFailed           = "failed"
+	hookTimedOut         = "timed out"
+	hookCPULimitExceeded = "CPU limit exceeded"
+	hookNotFound         = "not found"
+	hookSkipped          = "skipped"
+	hookLocked           = "locked"
+	hookInterrupted      = "interrupted"
+)
 
-	// Get the current environment
-	currentEnv := os.Environ()
+// Result of a hook run by the command.
+type hookRun struct {
+	pacpilot.HookResult
+	// Whether the hook ran until it exited (otherwise, the exit code is not
+	// set)
+	exited   bool
+	status   string
+	duration time.Duration
+}
 
-	// Modify the environment variables
-	if env != nil {
-		for key, value := range env {
-			currentEnv = append(currentEnv, key+"="+value)
-		}
+// Returns the run of a hook from the result and error of pacpilot.RunHook.
+func newHookRun(result pacpilot.HookResult, err error, duration time.Duration) hookRun {
+	run := hookRun{
+		HookResult: result,
+		duration:   duration,
 	}
 
-	// Verify if hook has custom entry command
-	var entryCommand string
-	var entryArgs []string
+	switch {
+	case errors.Is(err, pacpilot.ErrHookTimedOut):
+		run.status = hookTimedOut
+	case errors.Is(err, pacpilot.ErrCPULimitExceeded):
+		run.status = hookCPULimitExceeded
+	case errors.Is(err, context.Canceled):
+		run.status = hookInterrupted
+	case err != nil:
+		run.status = hookFailed
+	case result.ExitCode != 0:
+		run.exited = true
+		run.status = hookFailed
+	default:
+		run.exited = true
+		run.status = hookSucceeded
+	}
 
-	customEntryFilePath := hookPath + ".entry"
-	if _, err := os.Stat(customEntryFilePath); err == nil {
-		contents, err := ioutil.ReadFile(customEntryFilePath)
-		if err != nil {
-			return ptywrapper.Command{}, functionResponse{
-				exitCode:    1,
-				message:     fmt.Sprintf("Failed to read custom entry configuration file -> " + err.Error()),
-				logLevel:    "error",
-				indentLevel: program.indentLevel,
-			}
-		}
-		entryCommandString := string(contents)
-		entryCommandString = strings.TrimLeft(entryCommandString, "\n")
-		entryCommandString = strings.TrimRight(entryCommandString, "\n")
-		entryCommandSlice := strings.Split(entryCommandString, " ")
+	return run
+}
 
-		if len(entryCommandSlice) > 1 {
-			entryCommand = entryCommandSlice[0]
-			entryArgs = append(entryCommandSlice[1:], hookPath)
-		} else {
-			entryCommand = entryCommandSlice[0]
-			entryArgs = []string{hookPath}
+func runHook(hookPath string, env map[string]string, args []string, printOutput bool, printFinished bool, showRulers bool, printEntryCmd bool, printAlerts bool, program Program) (hookRun, functionResponse) {
+	// Verify if hook exists and read its entry command
+	hook, err := pacpilot.GetHook(getLibraryConfig(program), filepath.Dir(hookPath), filepath.Base(hookPath))
+	if errors.Is(err, pacpilot.ErrHookNotFound) {
+		return hookRun{status: hookNotFound}, functionResponse{
+			exitCode:    1,
+			message:     "Hook not found",
+			logLevel:    "attention",
+			indentLevel: program.indentLevel,
+		}
+	} else if err != nil {
+		return hookRun{status: hookFailed}, functionResponse{
+			exitCode:    1,
+			message:     formatErrorMessage(err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
 		}
-	} else {
-		entryCommand = program.defaultShell
-		entryArgs = []string{hookPath}
 	}
 
-	// Run the hook (using the ptywrapper module)
 	if printEntryCmd == true {
-		showInfoSectionTitle(fmt.Sprintf("Entry command: %s", paleLime.Sprintf(entryCommand)), program.indentLevel+1)
+		showInfoSectionTitle(fmt.Sprintf("Entry command: %s", paleLime.Sprintf(hook.Entry)), program.indentLevel+1)
 	}
 
 	if printOutput == false && printAlerts == true {
 		showText(gray.Sprintf("> The command will run silently. Interactive commands may not function properly. If necessary, press Ctrl+C or use the program-specific shortcut to quit."), program.indentLevel+1)
 	}
 
-	cmd := &ptywrapper.Command{
-		Entry:   entryCommand,
-		Args:    entryArgs,
-		Env:     currentEnv,
-		Discard: !printOutput,
+	options := pacpilot.HookOptions{
+		Env:         env,
+		Interactive: true,
+		Limits:      program.hookLimits,
+		Args:        args,
+	}
+	if printOutput == true {
+		options.Output = os.Stdout
 	}
 
 	if showRulers == true {
 		hr("-", 0.5, incrementProgramIndentLevel(program, 1))
 	}
 
-	completedCmd, err := cmd.RunInPTY()
-	if err != nil {
-		return ptywrapper.Command{}, functionResponse{
+	started := time.Now()
+	result, err := pacpilot.RunHook(context.Background(), hook, options)
+	run := newHookRun(result, err, time.Since(started))
+
+	if errors.Is(err, pacpilot.ErrHookTimedOut) || errors.Is(err, pacpilot.ErrCPULimitExceeded) {
+		if showRulers == true {
+			hr("-", 0.5, incrementProgramIndentLevel(program, 1))
+		}
+
+		return run, functionResponse{
+			exitCode:    1,
+			message:     formatErrorMessage(err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	} else if err != nil {
+		return run, functionResponse{
 			exitCode:    1,
 			message:     fmt.Sprintf("Failed to execute hook -> " + err.Error()),
 			logLevel:    "error",
@@ -164,9 +198,9 @@ func runHook(hookPath string, env map[string]string, printOutput bool, printFini
 	var logLevel string
 	var message string
 
-	if completedCmd.ExitCode != 0 {
+	if result.ExitCode != 0 {
 		logLevel = "error"
-		message = fmt.Sprintf("Failed to execute hook: exit code %v", completedCmd.ExitCode)
+		message = fmt.Sprintf("Failed to execute hook: exit code %v", result.ExitCode)
 	} else {
 		logLevel = "success"
 
@@ -177,10 +211,172 @@ func runHook(hookPath string, env map[string]string, printOutput bool, printFini
 		}
 	}
 
-	return completedCmd, functionResponse{
-		exitCode:    completedCmd.ExitCode,
+	return run, functionResponse{
+		exitCode:    result.ExitCode,
 		message:     message,
 		indentLevel: program.indentLevel,
 		logLevel:    logLevel,
 	}
 }
+
+// Path of the program itself, which starts hooks with resource limits (see
+// runHookLimitsShim)
+const hookLimitsShimPath = "/proc/self/exe"
+
+// Exit code of the shim when it fails to set the limits or run the entry
+// command (like shells, when a command cannot be executed)
+const hookLimitsShimExitCode = 126
+
+// Sets the hook limits and executes the hook's entry command, when the program
+// is started as the hook limits shim. Returns only if it was not.
+func runHookLimitsShim() {
+	limits, exists := os.LookupEnv(pacpilot.HookLimitsShimEnv)
+	if exists == false {
+		return
+	}
+
+	err := pacpilot.RunHookLimitsShim(limits, os.Args[1:])
+	fmt.Fprintf(os.Stderr, "Failed to set hook limits -> %v\n", err)
+	os.Exit(hookLimitsShimExitCode)
+}
+
+// Returns the hook limits set with the command line flags.
+func getHookLimitsFromCLI(timeout time.Duration, cpuTime time.Duration, memory string, openFiles uint64, program Program) (pacpilot.HookLimits, functionResponse) {
+	memoryBytes, err := pacpilot.ParseSize(memory)
+	if err != nil {
+		return pacpilot.HookLimits{}, functionResponse{
+			exitCode:    1,
+			message:     formatErrorMessage(err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	if timeout < 0 || cpuTime < 0 {
+		return pacpilot.HookLimits{}, functionResponse{
+			exitCode:    1,
+			message:     "Hook timeout and CPU time limit should not be negative",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return pacpilot.HookLimits{
+		Timeout:   timeout,
+		CPUTime:   cpuTime,
+		Memory:    memoryBytes,
+		OpenFiles: openFiles,
+	}, functionResponse{
+		exitCode: 0,
+	}
+}
+
+// Returns the environment variables set with the '--env KEY=VALUE' flags.
+func getHookEnvFromCLI(values []string, program Program) (map[string]string, functionResponse) {
+	env := make(map[string]string, len(values))
+
+	for _, value := range values {
+		key, value, found := strings.Cut(value, "=")
+		if found == false || validEnvName.MatchString(key) == false {
+			return nil, functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Invalid environment variable '%s' (expected KEY=VALUE)", key),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		env[key] = value
+	}
+
+	return env, functionResponse{
+		exitCode: 0,
+	}
+}
+
+var validEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Returns the environment of a repo or target with the extra variables (which
+// take precedence).
+func mergeEnvironment(environment map[string]string, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return environment
+	}
+
+	merged := make(map[string]string, len(environment)+len(extra))
+	for key, value := range environment {
+		merged[key] = value
+	}
+	for key, value := range extra {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// Shows a hook and its declaration in the hook manifest (if any).
+func showHook(hook pacpilot.Hook, program Program) {
+	description := ""
+	if hook.Description != "" {
+		description = ": " + hook.Description
+	}
+
+	showText(fmt.Sprintf("- %s (%s)%s", hook.Name, coral.Sprintf(hook.EntryCommand()), description), program.indentLevel+1)
+
+	var limits []string
+	if hook.Limits.Timeout > 0 {
+		limits = append(limits, fmt.Sprintf("timeout %v", hook.Limits.Timeout))
+	}
+	if hook.Limits.CPUTime > 0 {
+		limits = append(limits, fmt.Sprintf("CPU time %v", hook.Limits.CPUTime))
+	}
+	if hook.Limits.Memory > 0 {
+		limits = append(limits, fmt.Sprintf("memory %s", formatBytes(int64(hook.Limits.Memory))))
+	}
+	if hook.Limits.OpenFiles > 0 {
+		limits = append(limits, fmt.Sprintf("%d open files", hook.Limits.OpenFiles))
+	}
+	if len(limits) > 0 {
+		showText(gray.Sprintf("Limits: %s", strings.Join(limits, ", ")), program.indentLevel+2)
+	}
+
+	if len(hook.RequiredEnv) > 0 {
+		showText(gray.Sprintf("Required environment: %s", strings.Join(hook.RequiredEnv, ", ")), program.indentLevel+2)
+	}
+
+	if len(hook.OptionalEnv) > 0 {
+		showText(gray.Sprintf("Optional environment: %s", strings.Join(hook.OptionalEnv, ", ")), program.indentLevel+2)
+	}
+
+	if len(hook.Args) > 0 {
+		showText(gray.Sprintf("Arguments:"), program.indentLevel+2)
+	}
+	for _, arg := range hook.Args {
+		usage := fmt.Sprintf("--%s <%s>", arg.Name, arg.Type)
+		if len(arg.Choices) > 0 {
+			usage = fmt.Sprintf("--%s <%s>", arg.Name, strings.Join(arg.Choices, "|"))
+		} else if arg.Type == pacpilot.HookArgBool {
+			usage = "--" + arg.Name
+		}
+
+		var details []string
+		if arg.Required == true {
+			details = append(details, "required")
+		}
+		if arg.Default != "" {
+			details = append(details, "default: "+arg.Default)
+		}
+		if len(details) > 0 {
+			usage += fmt.Sprintf(" (%s)", strings.Join(details, ", "))
+		}
+		if arg.Description != "" {
+			usage += ": " + arg.Description
+		}
+
+		showText(gray.Sprintf("%s", usage), program.indentLevel+3)
+	}
+
+	if hook.API == false {
+		showText(gray.Sprintf("Not available through the API"), program.indentLevel+2)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"reflect"
+	"testing"
+)
+
+//
+//// HOOK ENVIRONMENT
+//
+
+func TestGetHookEnvFromCLI(t *testing.T) {
+	tests := []struct {
+		values   []string
+		expected map[string]string
+		fails    bool
+	}{
+		{values: nil, expected: map[string]string{}},
+		{values: []string{"PACKAGER=John Doe <john@example.com>"}, expected: map[string]string{"PACKAGER": "John Doe <john@example.com>"}},
+		{values: []string{"A=1", "_b2=", "A=2"}, expected: map[string]string{"A": "2", "_b2": ""}},
+		// Only the first '=' separates the name
+		{values: []string{"FLAGS=-O2 -DX=1"}, expected: map[string]string{"FLAGS": "-O2 -DX=1"}},
+		{values: []string{"PACKAGER"}, fails: true},
+		{values: []string{"=John"}, fails: true},
+		{values: []string{"1A=x"}, fails: true},
+		{values: []string{"MY-VAR=x"}, fails: true},
+		{values: []string{"MY VAR=x"}, fails: true},
+		{values: []string{"A=1", "B.C=2"}, fails: true},
+	}
+
+	for _, test := range tests {
+		env, response := getHookEnvFromCLI(test.values, Program{})
+
+		if test.fails == true {
+			if response.exitCode == 0 {
+				t.Errorf("getHookEnvFromCLI(%q) = %v, expected an error", test.values, env)
+			}
+		} else if response.exitCode != 0 || reflect.DeepEqual(env, test.expected) == false {
+			t.Errorf("getHookEnvFromCLI(%q) = %v, %q, expected %v", test.values, env, response.message, test.expected)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	// External modules
+	fsnotify "github.com/fsnotify/fsnotify"
+)
+
+//
+//// TLS CONFIGURATION
+//
+
+// TLS options of 'repos serve'. TLS is enabled when a certificate is set.
+type serverTLS struct {
+	certPath string
+	keyPath  string
+	// Port of the listener that redirects plain HTTP requests to HTTPS (no
+	// listener if empty)
+	redirectPort string
+	// CA bundle used to verify client certificates (mTLS mode if set)
+	clientCAPath string
+}
+
+func (options serverTLS) enabled() bool {
+	return options.certPath != ""
+}
+
+func validateServerTLS(options serverTLS) error {
+	if (options.certPath == "") != (options.keyPath == "") {
+		return fmt.Errorf("both a certificate and a key should be specified")
+	}
+	if options.redirectPort != "" && options.enabled() == false {
+		return fmt.Errorf("the HTTP redirect listener requires TLS")
+	}
+	if options.clientCAPath != "" && options.enabled() == false {
+		return fmt.Errorf("client certificates (mTLS) require TLS")
+	}
+
+	return nil
+}
+
+//
+//// CERTIFICATE RELOADING
+//
+
+// Time to wait for more changes to the certificate files before reloading
+// them, so that the certificate and key are not read while being replaced
+const certificateReloadDelay = 500 * time.Millisecond
+
+// Serves the certificate to TLS handshakes and reloads it on SIGHUP or when
+// the certificate or key files change. If a reload fails, the previous
+// certificate is kept.
+type certificateReloader struct {
+	certPath    string
+	keyPath     string
+	program     Program
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	reloadTimer *time.Timer
+	timerMutex  sync.Mutex
+}
+
+func newCertificateReloader(certPath string, keyPath string, program Program) (*certificateReloader, error) {
+	reloader := &certificateReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		program:  program,
+	}
+
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificates are usually renewed by replacing the files, so their
+	// directories are watched instead of the files themselves
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{filepath.Dir(certPath), filepath.Dir(keyPath)} {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go reloader.watch(watcher, signals)
+
+	return reloader, nil
+}
+
+func (reloader *certificateReloader) reload() error {
+	certificate, err := tls.LoadX509KeyPair(reloader.certPath, reloader.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate -> %v", err)
+	}
+
+	reloader.mutex.Lock()
+	reloader.certificate = &certificate
+	reloader.mutex.Unlock()
+
+	return nil
+}
+
+func (reloader *certificateReloader) watch(watcher *fsnotify.Watcher, signals chan os.Signal) {
+	certPath, _ := filepath.Abs(reloader.certPath)
+	keyPath, _ := filepath.Abs(reloader.keyPath)
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if ok == false {
+				return
+			}
+
+			eventPath, _ := filepath.Abs(event.Name)
+			if eventPath == certPath || eventPath == keyPath {
+				reloader.scheduleReload("certificate files changed")
+			}
+		case err, ok := <-watcher.Errors:
+			if ok == false {
+				return
+			}
+			showAttention("=> Certificate watcher error -> "+err.Error(), reloader.program.indentLevel)
+		case <-signals:
+			reloader.scheduleReload("received SIGHUP")
+		}
+	}
+}
+
+func (reloader *certificateReloader) scheduleReload(reason string) {
+	reloader.timerMutex.Lock()
+	defer reloader.timerMutex.Unlock()
+
+	if reloader.reloadTimer != nil {
+		return
+	}
+
+	reloader.reloadTimer = time.AfterFunc(certificateReloadDelay, func() {
+		reloader.timerMutex.Lock()
+		reloader.reloadTimer = nil
+		reloader.timerMutex.Unlock()
+
+		err := reloader.reload()
+		if err != nil {
+			showError(fmt.Sprintf("=> Failed to reload TLS certificate (%s), keeping the previous one -> %v", reason, err), reloader.program.indentLevel)
+			return
+		}
+
+		showSuccess(fmt.Sprintf("=> Reloaded TLS certificate (%s)", reason), reloader.program.indentLevel)
+	})
+}
+
+func (reloader *certificateReloader) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+
+	return reloader.certificate, nil
+}
+
+//
+//// HTTP REDIRECT
+//
+
+// Redirects plain HTTP requests to the same URL on the HTTPS port.
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		// 308 keeps the method and body of API requests
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+//
+//// SELF-SIGNED CERTIFICATES
+//
+
+// Creates a self-signed certificate (and its ECDSA P-256 key) valid for the
+// given host names and IP addresses.
+func certsSelfSigned(hosts []string, validDays int, certPath string, keyPath string, program Program) functionResponse {
+	space()
+	showInfoSectionTitle("Creating self-signed certificate", program.indentLevel)
+
+	if len(hosts) == 0 {
+		return functionResponse{
+			exitCode:    1,
+			message:     "At least one host should be specified",
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	if validDays <= 0 {
+		return functionResponse{
+			exitCode:    1,
+			message:     "The validity should be at least one day",
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	for _, path := range []string{certPath, keyPath} {
+		if _, err := os.Stat(path); err == nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("File '%s' already exists", path),
+				logLevel:    "attention",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Failed to generate key -> " + err.Error(),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Failed to generate serial number -> " + err.Error(),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	notBefore := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{program.name}},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(0, 0, validDays),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err == nil {
+		err = writePEMFile(certPath, "CERTIFICATE", certDER, 0644)
+	}
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Failed to create certificate -> " + err.Error(),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err == nil {
+		err = writePEMFile(keyPath, "PRIVATE KEY", keyDER, 0600)
+	}
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Failed to save key -> " + err.Error(),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	showText(fmt.Sprintf("Certificate: %s", paleLime.Sprintf(certPath)), program.indentLevel+1)
+	showText(fmt.Sprintf("Key: %s", paleLime.Sprintf(keyPath)), program.indentLevel+1)
+	showText(fmt.Sprintf("Valid until: %s", paleLime.Sprintf(template.NotAfter.Format(time.RFC1123))), program.indentLevel+1)
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
+	}
+}
+
+func writePEMFile(path string, blockType string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	err = pem.Encode(file, &pem.Block{Type: blockType, Bytes: data})
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,472 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	// External modules
+	gin "github.com/gin-gonic/gin"
+	websocket "github.com/gorilla/websocket"
+)
+
+//
+//// API TOKENS
+//
+
+// Tokens are shown once when created and only their SHA-256 hash is stored.
+// As tokens are random (and not chosen by users), a fast hash is enough.
+const tokenPrefix = "ppt_"
+
+type apiToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Targets   []string  `json:"targets"`
+	Actions   []string  `json:"actions"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Admin tokens can also attach a terminal to running jobs
+	Admin bool `json:"admin,omitempty"`
+}
+
+var errTokenNotFound = errors.New("token not found")
+
+func loadTokens(program Program) ([]apiToken, error) {
+	var tokens []apiToken
+
+	contents, err := os.ReadFile(program.tokensPath)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file -> %v", err)
+	}
+
+	err = json.Unmarshal(contents, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file '%s' -> %v", program.tokensPath, err)
+	}
+
+	return tokens, nil
+}
+
+func saveTokens(tokens []apiToken, program Program) error {
+	contents, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that the tokens are never left
+	// partially written (the server reads them on every request)
+	tempPath := program.tokensPath + ".new"
+	err = os.WriteFile(tempPath, contents, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, program.tokensPath)
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(length int) (string, error) {
+	data := make([]byte, length)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(data), nil
+}
+
+// Returns the token matching the secret sent by a client.
+func findToken(tokens []apiToken, secret string) (apiToken, error) {
+	hash := hashToken(secret)
+
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) == 1 {
+			return token, nil
+		}
+	}
+
+	return apiToken{}, errTokenNotFound
+}
+
+// Scopes are glob patterns: targets are matched as '<repo>/<target>' (e.g.
+// 'myrepo/*') and actions as the name used in the API route (e.g. 'upload' or
+// the name of a hook).
+func scopesAllow(targets []string, actions []string, target Target, action string) bool {
+	return matchAnyPattern(targets, target.Repo.Name+"/"+target.Name) && matchAnyPattern(actions, action)
+}
+
+func matchAnyPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched == true {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateAPIScopes(targets []string, actions []string) error {
+	if len(targets) == 0 {
+		return errors.New("at least one target should be specified")
+	}
+	if len(actions) == 0 {
+		return errors.New("at least one action should be specified")
+	}
+
+	for _, pattern := range targets {
+		if strings.Count(pattern, "/") != 1 {
+			return fmt.Errorf("invalid target '%s' (expected '<repo>/<target>')", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid target pattern '%s' -> %v", pattern, err)
+		}
+	}
+
+	for _, pattern := range actions {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid action pattern '%s' -> %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+//
+//// API CREDENTIALS (SERVER FUNCTIONALITY)
+//
+
+// Credential of an API request: a token or, in mTLS mode, a client
+// certificate.
+type apiCredential struct {
+	// Token ID or certificate subject, recorded in the logs
+	identity string
+	targets  []string
+	actions  []string
+	admin    bool
+}
+
+// Rejects POST requests (and requests to jobs routes) without a valid
+// credential. By default, requests must have an 'Authorization: Bearer
+// <token>' header. In mTLS mode (when client certificates are verified), they
+// must have a client certificate mapped in the clients file instead. The
+// credential is stored in the context for authorizeAPIRequest.
+func requireAPICredential(clientCertificates bool, program Program) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodPost && isJobsRoute(c) == false {
+			c.Next()
+			return
+		}
+
+		var credential apiCredential
+		var err error
+
+		if clientCertificates == true {
+			credential, err = getClientCertificateCredential(c, program)
+		} else {
+			credential, err = getTokenCredential(c, program)
+		}
+		if err != nil {
+			var rejection *apiRejection
+			if errors.As(err, &rejection) {
+				rejectAPIRequest(c, rejection.status, rejection.reason, program)
+			} else {
+				respondWithError(c, err, program)
+				c.Abort()
+			}
+			return
+		}
+
+		c.Set("apiCredential", credential)
+		c.Next()
+	}
+}
+
+// Returned when the credential of an API request is missing or not valid.
+type apiRejection struct {
+	status int
+	reason string
+}
+
+func (rejection *apiRejection) Error() string {
+	return rejection.reason
+}
+
+func getTokenCredential(c *gin.Context, program Program) (apiCredential, error) {
+	secret, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if found == false {
+		secret, found = getWebSocketToken(c)
+	}
+	if found == false || secret == "" {
+		c.Header("WWW-Authenticate", "Bearer")
+		return apiCredential{}, &apiRejection{status: http.StatusUnauthorized, reason: "missing bearer token"}
+	}
+
+	tokens, err := loadTokens(program)
+	if err != nil {
+		return apiCredential{}, err
+	}
+
+	token, err := findToken(tokens, secret)
+	if err != nil {
+		c.Header("WWW-Authenticate", "Bearer")
+		return apiCredential{}, &apiRejection{status: http.StatusUnauthorized, reason: "invalid bearer token"}
+	}
+
+	return apiCredential{
+		identity: fmt.Sprintf("token '%s'", token.ID),
+		targets:  token.Targets,
+		actions:  token.Actions,
+		admin:    token.Admin,
+	}, nil
+}
+
+// Browsers cannot set headers on WebSocket connections, so the token can also
+// be sent as a 'bearer.<token>' subprotocol.
+func getWebSocketToken(c *gin.Context) (string, bool) {
+	if c.IsWebsocket() == false {
+		return "", false
+	}
+
+	for _, protocol := range websocket.Subprotocols(c.Request) {
+		if secret, found := strings.CutPrefix(protocol, "bearer."); found == true {
+			return secret, true
+		}
+	}
+
+	return "", false
+}
+
+// Verifies that the request's credential is scoped to the target and action,
+// and logs the identity of the client. Returns false (after responding)
+// otherwise.
+func authorizeAPIRequest(c *gin.Context, target Target, action string, program Program) bool {
+	value, exists := c.Get("apiCredential")
+	credential, ok := value.(apiCredential)
+	if exists == false || ok == false {
+		rejectAPIRequest(c, http.StatusUnauthorized, "missing credential", program)
+		return false
+	}
+
+	if scopesAllow(credential.targets, credential.actions, target, action) == false {
+		rejectAPIRequest(c, http.StatusForbidden, fmt.Sprintf("%s is not allowed to run '%s' on '%s/%s'", credential.identity, action, target.Repo.Name, target.Name), program)
+		return false
+	}
+
+	showInfo(fmt.Sprintf("=> API request '%s %s' from %s by %s", c.Request.Method, c.Request.URL.Path, c.ClientIP(), credential.identity), program.indentLevel)
+
+	return true
+}
+
+func rejectAPIRequest(c *gin.Context, status int, reason string, program Program) {
+	logSecurityEvent(c, reason, program)
+
+	c.AbortWithStatusJSON(status, gin.H{
+		"message": fmt.Sprintf("%s: %s", http.StatusText(status), reason),
+	})
+}
+
+//
+//// API TOKENS (COMMANDS)
+//
+
+func tokensCreate(name string, targets []string, actions []string, admin bool, program Program) functionResponse {
+	err := validateAPIScopes(targets, actions)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     formatErrorMessage(err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	tokens, err := loadTokens(program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     formatErrorMessage(err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	id, err := randomHex(4)
+	var secret string
+	if err == nil {
+		secret, err = randomHex(32)
+	}
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Failed to generate token -> " + err.Error(),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	secret = tokenPrefix + secret
+
+	tokens = append(tokens, apiToken{
+		ID:        id,
+		Name:      name,
+		Hash:      hashToken(secret),
+		Targets:   targets,
+		Actions:   actions,
+		Admin:     admin,
+		CreatedAt: time.Now().UTC(),
+	})
+
+	err = saveTokens(tokens, program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Failed to save tokens file -> " + err.Error(),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	space()
+	showInfoSectionTitle(fmt.Sprintf("Created token %s", orange.Sprintf(id)), program.indentLevel)
+	showText(gray.Sprintf("> Store the token now. It is not saved and cannot be displayed again."), program.indentLevel+1)
+	space()
+	fmt.Println(secret)
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
+
+func tokensLs(program Program) functionResponse {
+	space()
+	showInfoSectionTitle("Listing tokens", program.indentLevel)
+
+	tokens, err := loadTokens(program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     formatErrorMessage(err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	if len(tokens) == 0 {
+		return functionResponse{
+			exitCode:    0,
+			message:     "No tokens found",
+			logLevel:    "attention",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	space()
+
+	for _, token := range tokens {
+		name := ""
+		if token.Name != "" {
+			name = fmt.Sprintf("(%s) ", blue.Sprintf(token.Name))
+		}
+
+		if token.Admin == true {
+			name += fmt.Sprintf("[%s]", red.Sprintf("admin"))
+		}
+
+		showText(fmt.Sprintf(" - %s %s", orange.Sprintf(token.ID), name), program.indentLevel+1)
+		showText(fmt.Sprintf("Targets: %s", strings.Join(token.Targets, ", ")), program.indentLevel+3)
+		showText(fmt.Sprintf("Actions: %s", strings.Join(token.Actions, ", ")), program.indentLevel+3)
+		showText(gray.Sprintf("Created: %s", token.CreatedAt.Format(time.RFC1123)), program.indentLevel+3)
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
+
+func tokensRevoke(ids []string, program Program) functionResponse {
+	if len(ids) == 0 {
+		return functionResponse{
+			exitCode:    1,
+			message:     "At least one token ID should be specified",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	tokens, err := loadTokens(program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     formatErrorMessage(err),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	notFound := 0
+
+	for _, id := range ids {
+		space()
+		showInfoSectionTitle(fmt.Sprintf("Revoking token %s", orange.Sprintf(id)), program.indentLevel)
+
+		found := false
+		for i, token := range tokens {
+			if token.ID == id {
+				tokens = append(tokens[:i], tokens[i+1:]...)
+				found = true
+				break
+			}
+		}
+
+		if found == false {
+			showError("> Error: Token not found", program.indentLevel+1)
+			notFound++
+			continue
+		}
+
+		err = saveTokens(tokens, program)
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     "Failed to save tokens file -> " + err.Error(),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+
+		showSuccess("> Finished", program.indentLevel+1)
+	}
+
+	if notFound > 0 {
+		space()
+
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("%d token(s) not found", notFound),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	// External modules
+	pacpilot "github.com/fearlessdots/pacpilot/pkg/pacpilot"
+)
+
+//
+//// API TOKENS
+//
+
+func TestHashToken(t *testing.T) {
+	// SHA-256 test vectors
+	tests := map[string]string{
+		"":    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"abc": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	}
+
+	for secret, expected := range tests {
+		if hash := hashToken(secret); hash != expected {
+			t.Errorf("hashToken(%q) = %s, expected %s", secret, hash, expected)
+		}
+	}
+
+	if hashToken(tokenPrefix+"a") == hashToken(tokenPrefix+"b") {
+		t.Error("hashToken() should return different hashes for different tokens")
+	}
+}
+
+func TestRandomHex(t *testing.T) {
+	first, err := randomHex(32)
+	if err != nil {
+		t.Fatalf("randomHex() error = %v", err)
+	}
+	second, _ := randomHex(32)
+
+	if len(first) != 64 || strings.Trim(first, "0123456789abcdef") != "" {
+		t.Errorf("randomHex(32) = %q, expected 64 hexadecimal characters", first)
+	}
+	if first == second {
+		t.Error("randomHex() returned the same value twice")
+	}
+}
+
+func TestFindToken(t *testing.T) {
+	tokens := []apiToken{
+		{ID: "first", Hash: hashToken(tokenPrefix + "first-secret")},
+		{ID: "second", Hash: hashToken(tokenPrefix + "second-secret")},
+	}
+
+	tests := []struct {
+		secret string
+		id     string
+	}{
+		{secret: tokenPrefix + "first-secret", id: "first"},
+		{secret: tokenPrefix + "second-secret", id: "second"},
+		{secret: tokenPrefix + "third-secret"},
+		{secret: ""},
+		// The hash itself is not a valid token
+		{secret: hashToken(tokenPrefix + "first-secret")},
+		{secret: tokenPrefix + "first-secret "},
+	}
+
+	for _, test := range tests {
+		token, err := findToken(tokens, test.secret)
+
+		if test.id == "" {
+			if errors.Is(err, errTokenNotFound) == false {
+				t.Errorf("findToken(%q) = %+v, %v, expected errTokenNotFound", test.secret, token, err)
+			}
+		} else if err != nil || token.ID != test.id {
+			t.Errorf("findToken(%q) = %+v, %v, expected token '%s'", test.secret, token, err, test.id)
+		}
+	}
+
+	if _, err := findToken(nil, tokenPrefix+"first-secret"); errors.Is(err, errTokenNotFound) == false {
+		t.Errorf("findToken() without tokens error = %v, expected errTokenNotFound", err)
+	}
+}
+
+func TestScopesAllow(t *testing.T) {
+	target := func(repo string, name string) Target {
+		return Target{Name: name, Repo: pacpilot.Repo{Name: repo}}
+	}
+
+	tests := []struct {
+		name     string
+		targets  []string
+		actions  []string
+		target   Target
+		action   string
+		expected bool
+	}{
+		{name: "exact scope", targets: []string{"myrepo/x86_64"}, actions: []string{"upload"}, target: target("myrepo", "x86_64"), action: "upload", expected: true},
+		{name: "target pattern", targets: []string{"myrepo/*"}, actions: []string{"upload"}, target: target("myrepo", "aarch64"), action: "upload", expected: true},
+		{name: "repo pattern", targets: []string{"*/x86_64"}, actions: []string{"upload"}, target: target("other", "x86_64"), action: "upload", expected: true},
+		{name: "action pattern", targets: []string{"myrepo/x86_64"}, actions: []string{"build-*"}, target: target("myrepo", "x86_64"), action: "build-all", expected: true},
+		{name: "one of several scopes", targets: []string{"a/b", "myrepo/x86_64"}, actions: []string{"promote", "upload"}, target: target("myrepo", "x86_64"), action: "upload", expected: true},
+
+		{name: "other target", targets: []string{"myrepo/x86_64"}, actions: []string{"upload"}, target: target("myrepo", "aarch64"), action: "upload"},
+		{name: "other repo", targets: []string{"myrepo/*"}, actions: []string{"upload"}, target: target("other", "x86_64"), action: "upload"},
+		{name: "other action", targets: []string{"myrepo/*"}, actions: []string{"upload"}, target: target("myrepo", "x86_64"), action: "promote"},
+		{name: "pattern does not cross slashes", targets: []string{"*"}, actions: []string{"*"}, target: target("myrepo", "x86_64"), action: "upload"},
+		{name: "repo name prefix", targets: []string{"my/*"}, actions: []string{"*"}, target: target("myrepo", "x86_64"), action: "upload"},
+		{name: "no targets", targets: nil, actions: []string{"*"}, target: target("myrepo", "x86_64"), action: "upload"},
+		{name: "no actions", targets: []string{"*/*"}, actions: nil, target: target("myrepo", "x86_64"), action: "upload"},
+		{name: "invalid pattern", targets: []string{"myrepo/["}, actions: []string{"*"}, target: target("myrepo", "["), action: "upload"},
+	}
+
+	for _, test := range tests {
+		if result := scopesAllow(test.targets, test.actions, test.target, test.action); result != test.expected {
+			t.Errorf("%s: scopesAllow() = %v, expected %v", test.name, result, test.expected)
+		}
+	}
+}
+
+func TestValidateAPIScopes(t *testing.T) {
+	tests := []struct {
+		targets []string
+		actions []string
+		fails   bool
+	}{
+		{targets: []string{"myrepo/x86_64"}, actions: []string{"upload"}},
+		{targets: []string{"*/*"}, actions: []string{"*"}},
+		{targets: nil, actions: []string{"upload"}, fails: true},
+		{targets: []string{"myrepo/x86_64"}, actions: nil, fails: true},
+		{targets: []string{"myrepo"}, actions: []string{"upload"}, fails: true},
+		{targets: []string{"myrepo/x86_64/extra"}, actions: []string{"upload"}, fails: true},
+		{targets: []string{"myrepo/[x"}, actions: []string{"upload"}, fails: true},
+		{targets: []string{"myrepo/x86_64"}, actions: []string{"[upload"}, fails: true},
+	}
+
+	for _, test := range tests {
+		err := validateAPIScopes(test.targets, test.actions)
+		if (err != nil) != test.fails {
+			t.Errorf("validateAPIScopes(%q, %q) error = %v, expected failure: %v", test.targets, test.actions, err, test.fails)
+		}
+	}
+}
+
+func TestSaveAndLoadTokens(t *testing.T) {
+	program := Program{tokensPath: t.TempDir() + "/tokens.json"}
+
+	tokens, err := loadTokens(program)
+	if err != nil || len(tokens) != 0 {
+		t.Fatalf("loadTokens() without a file = %v, %v, expected no tokens", tokens, err)
+	}
+
+	saved := []apiToken{{ID: "abc", Name: "ci", Hash: hashToken("secret"), Targets: []string{"myrepo/*"}, Actions: []string{"upload"}, Admin: true}}
+	if err := saveTokens(saved, program); err != nil {
+		t.Fatalf("saveTokens() error = %v", err)
+	}
+
+	info, err := os.Stat(program.tokensPath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("tokens file mode = %v, %v, expected 0600", info.Mode().Perm(), err)
+	}
+
+	contents, _ := os.ReadFile(program.tokensPath)
+	if strings.Contains(string(contents), "secret") == true {
+		t.Error("the tokens file should not contain the secret")
+	}
+
+	tokens, err = loadTokens(program)
+	if err != nil || reflect.DeepEqual(tokens, saved) == false {
+		t.Errorf("loadTokens() = %+v, %v, expected %+v", tokens, err, saved)
+	}
+
+	os.WriteFile(program.tokensPath, []byte("{invalid"), 0600)
+	if _, err := loadTokens(program); err == nil {
+		t.Error("loadTokens() should fail for an invalid file")
+	}
+}
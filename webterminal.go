@@ -0,0 +1,196 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	// External modules
+	gin "github.com/gin-gonic/gin"
+	websocket "github.com/gorilla/websocket"
+)
+
+//
+//// BROWSER TERMINAL (SERVER FUNCTIONALITY)
+//
+
+// Subprotocol of the terminal WebSocket (the browser may also send the token
+// as a 'bearer.<token>' subprotocol)
+const terminalSubprotocol = "pacpilot"
+
+// Time between pings, which keep idle connections from being closed by proxies
+const terminalPingInterval = 30 * time.Second
+
+// Message sent by the browser terminal: keys typed by the user ('input') or
+// the new size of the terminal ('resize').
+type terminalMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+// Attaches the WebSocket of the request to the pseudo terminal of a running
+// job, so that interactive hooks can be completed from a browser. The output
+// written so far is sent first. Only admin credentials can attach terminals.
+func attachTerminal(c *gin.Context, jobs *jobManager, job hookJob, program Program) {
+	value, _ := c.Get("apiCredential")
+	credential := value.(apiCredential)
+
+	if credential.admin == false {
+		rejectAPIRequest(c, http.StatusForbidden, fmt.Sprintf("%s is not allowed to attach terminals (admin credential required)", credential.identity), program)
+		return
+	}
+
+	terminal, output := jobs.terminal(job.ID)
+	if terminal == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "The job is not running.",
+		})
+		return
+	}
+
+	// The default origin check only accepts connections from pages served by
+	// this server, so that other sites cannot use the client certificates of
+	// the browser (in mTLS mode)
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{terminalSubprotocol},
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader already responded
+		return
+	}
+	defer conn.Close()
+
+	showAttention(fmt.Sprintf("=> Job %s: terminal attached by %s", job.ID, credential.identity), program.indentLevel)
+
+	// Forward the messages of the browser to the pseudo terminal until the
+	// connection is closed
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var message terminalMessage
+			if json.Unmarshal(data, &message) != nil {
+				continue
+			}
+
+			switch message.Type {
+			case "input":
+				terminal.Write([]byte(message.Data))
+			case "resize":
+				if message.Rows > 0 && message.Cols > 0 {
+					terminal.Resize(message.Rows, message.Cols)
+				}
+			}
+		}
+	}()
+
+	ping := time.NewTicker(terminalPingInterval)
+	defer ping.Stop()
+
+	offset := 0
+	for {
+		data, end, updated, closed := output.read(offset)
+		offset = end
+
+		if len(data) > 0 {
+			err := conn.WriteMessage(websocket.BinaryMessage, data)
+			if err != nil {
+				return
+			}
+		}
+
+		if closed == true {
+			job, _ = jobs.get(job.ID)
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, fmt.Sprintf("Job %s", job.Status)))
+			break
+		}
+
+		select {
+		case <-updated:
+		case <-ping.C:
+			conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		case <-disconnected:
+			showAttention(fmt.Sprintf("=> Job %s: terminal detached by %s", job.ID, credential.identity), program.indentLevel)
+			return
+		}
+	}
+}
+
+// Page with an xterm.js terminal that attaches to the job given in the 'job'
+// query parameter. The token is entered in the page (it is not needed in mTLS
+// mode, where the browser sends its client certificate).
+const terminalPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>pacpilot terminal</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/xterm@5.3.0/css/xterm.css">
+<script src="https://cdn.jsdelivr.net/npm/xterm@5.3.0/lib/xterm.js"></script>
+<script src="https://cdn.jsdelivr.net/npm/xterm-addon-fit@0.8.0/lib/xterm-addon-fit.js"></script>
+<style>
+body { margin: 0; background: #000; color: #ccc; font-family: monospace; display: flex; flex-direction: column; height: 100vh; }
+form { padding: 8px; }
+#terminal { flex: 1; }
+</style>
+</head>
+<body>
+<form id="connect">
+Job <input id="job" size="20">
+Token <input id="token" type="password" size="40" placeholder="not needed with a client certificate">
+<button>Attach</button>
+</form>
+<div id="terminal"></div>
+<script>
+const form = document.getElementById("connect");
+document.getElementById("job").value = new URLSearchParams(location.search).get("job") || "";
+
+form.addEventListener("submit", (event) => {
+  event.preventDefault();
+  form.style.display = "none";
+
+  const job = document.getElementById("job").value;
+  const token = document.getElementById("token").value;
+  const protocols = ["pacpilot"];
+  if (token !== "") {
+    protocols.push("bearer." + token);
+  }
+
+  const term = new Terminal();
+  const fit = new FitAddon.FitAddon();
+  term.loadAddon(fit);
+  term.open(document.getElementById("terminal"));
+  fit.fit();
+
+  const scheme = location.protocol === "https:" ? "wss://" : "ws://";
+  const ws = new WebSocket(scheme + location.host + "/jobs/" + encodeURIComponent(job) + "/terminal", protocols);
+  ws.binaryType = "arraybuffer";
+
+  const sendResize = () => ws.send(JSON.stringify({type: "resize", rows: term.rows, cols: term.cols}));
+  ws.onopen = sendResize;
+  ws.onmessage = (message) => term.write(new Uint8Array(message.data));
+  ws.onclose = (event) => term.write("\r\n[" + (event.reason || "Disconnected (is the job running and the token an admin token?)") + "]\r\n");
+  term.onData((data) => ws.send(JSON.stringify({type: "input", data: data})));
+  term.onResize(sendResize);
+  window.addEventListener("resize", () => fit.fit());
+  term.focus();
+});
+</script>
+</body>
+</html>
+`